@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/rpc"
+)
+
+import (
+	"github.com/kc1212/virtual-grid/common"
+	"github.com/kc1212/virtual-grid/model"
+)
+
+// client calls the GSs, it fails over to the next address when a GS is offline or not ready
+type client struct {
+	addrs []string
+	creds model.Credentials
+}
+
+// call does the remote call fn on the first GS that is online,
+// errors returned by the remote function itself are not retried unless the GS is not ready.
+func (c *client) call(fn string, args interface{}, reply interface{}) error {
+	if len(c.addrs) == 0 {
+		return errors.New("no GS address, use -addr or set gs_addrs in the config file")
+	}
+
+	var err error
+	for _, addr := range c.addrs {
+		remote, e := common.DialRPC(addr)
+		if e != nil {
+			log.Printf("Node %v is not online, trying the next one\n", addr)
+			err = e
+			continue
+		}
+		e = remote.Call(fn, args, reply)
+		remote.Close()
+
+		err = model.DecodeError(e)
+		if model.IsNotReady(err) {
+			log.Printf("Node %v is not ready, trying the next one\n", addr)
+			continue
+		} else if _, ok := e.(rpc.ServerError); ok || e == nil {
+			return err
+		}
+		log.Printf("Remote call %v failed on %v, %v\n", fn, addr, err.Error())
+	}
+	return err
+}
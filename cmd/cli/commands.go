@@ -0,0 +1,560 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+import "github.com/kc1212/virtual-grid/model"
+
+// command is one subcommand of the CLI
+type command struct {
+	name  string
+	usage string
+	run   func(c *client, p printer, args []string)
+}
+
+var commands = []command{
+	{"submit", "[-count n] [-duration d] [-array range] [-not-before time] [-delay d] [-priority p] [-checkpoint d] [-input files] [-output files] [-script file] [-executor name] [-file jobs.json] [-rm addr] [-key k] [-callback url] [-wait] [-timeout d] [-- command args...]", submitCmd},
+	{"status", "<job id>...", statusCmd},
+	{"list", "", listCmd},
+	{"cancel", "<job id>...", cancelCmd},
+	{"logs", "<job id>...", logsCmd},
+	{"wait", "[-any] [-timeout d] <job id>...", waitCmd},
+	{"nodes", "", nodesCmd},
+	{"leader", "", leaderCmd},
+	{"queue", "", queueCmd},
+	{"deliveries", "", deliveriesCmd},
+	{"events", "[-after seq] [-follow]", eventsCmd},
+	{"report", "[-by user,group,rm] [-window d] [-since time] [-until time] [-export csv|json] [-out file]", reportCmd},
+	{"cron", "create -name n -schedule spec [-policy allow|forbid|replace] [job flags of submit] [-- command args...] | list | pause <name> | resume <name> | delete <name>", cronCmd},
+}
+
+// readJobFile reads a JSON array of model.JobSpec from path, the scripts of the jobs are files
+func readJobFile(path string) ([]model.Job, error) {
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return nil, e
+	}
+	var specs []model.JobSpec
+	if e := json.Unmarshal(b, &specs); e != nil {
+		return nil, e
+	}
+	for i := range specs {
+		if specs[i].Script != "" {
+			if specs[i].Script, e = readScript(specs[i].Script); e != nil {
+				return nil, e
+			}
+		}
+	}
+	return model.JobsFromSpecs(specs, time.Now())
+}
+
+// readScript reads the script of a job, the RM can't reach the files of the client
+func readScript(path string) (string, error) {
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return "", e
+	}
+	if len(b) == 0 {
+		return "", fmt.Errorf("script %v is empty", path)
+	}
+	return string(b), nil
+}
+
+// durationFlag is a time.Duration flag that also accepts a plain number of seconds
+type durationFlag time.Duration
+
+func (d *durationFlag) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *durationFlag) Set(s string) error {
+	if secs, e := strconv.ParseInt(s, 10, 64); e == nil {
+		*d = durationFlag(time.Duration(secs) * time.Second)
+		return nil
+	}
+	v, e := time.ParseDuration(s)
+	*d = durationFlag(v)
+	return e
+}
+
+// parseIDs converts the arguments into job IDs
+func parseIDs(args []string) []int64 {
+	if len(args) == 0 {
+		fatalf("At least one job ID is required\n")
+	}
+	ids := make([]int64, len(args))
+	for i, arg := range args {
+		id, e := strconv.ParseInt(arg, 10, 64)
+		if e != nil {
+			fatalf("Invalid job ID %v\n", arg)
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+// jobFlags are the flags that describe new jobs, they are shared by submit and cron create
+type jobFlags struct {
+	count      *int
+	duration   durationFlag
+	file       *string
+	array      *string
+	callback   *string
+	after      *string
+	delay      *time.Duration
+	priority   *int
+	checkpoint *time.Duration
+	inputs     *string
+	outputs    *string
+	script     *string
+	executor   *string
+}
+
+func addJobFlags(fs *flag.FlagSet) *jobFlags {
+	f := &jobFlags{}
+	f.count = fs.Int("count", 1, fmt.Sprintf("the number of jobs to add, at most %v", model.MaxJobsPerRequest))
+	fs.Var(&f.duration, "duration", "the duration for the jobs, e.g. 1m30s, a plain number is in seconds, it is the time limit of a command or script (default is a random value, or no limit for a command or script)")
+	f.file = fs.String("file", "", "JSON file with a list of jobs, e.g. [{\"duration\": \"5s\", \"count\": 2}]")
+	f.array = fs.String("array", "", "submit every job as a job array with this index range, e.g. 1-10000:2, every task gets its index in "+model.ArrayIndexEnv)
+	f.callback = fs.String("callback", "", "comma separated URLs that receive a JSON event on every state change of the jobs")
+	f.after = fs.String("not-before", "", "do not run the jobs before this time (RFC 3339), they wait in the queue")
+	f.delay = fs.Duration("delay", 0, "do not run the jobs before this duration has passed, they wait in the queue")
+	f.priority = fs.Int("priority", 0, "jobs with a higher priority are scheduled first and may preempt running jobs with a lower priority")
+	f.checkpoint = fs.Duration("checkpoint", 0, "let the jobs checkpoint at this interval so that they resume from the latest checkpoint when they run again")
+	f.inputs = fs.String("input", "", "comma separated files that are staged into the working directory of the jobs, e.g. in.csv=shared:data/in.csv")
+	f.outputs = fs.String("output", "", "comma separated files that are staged out of the working directory of the jobs, e.g. out.csv=object:results/out.csv")
+	f.script = fs.String("script", "", "file with a script that the jobs run instead of a command, with the interpreter of its #! line or with /bin/sh")
+	f.executor = fs.String("executor", "", "the executor that runs the jobs, e.g. "+model.SleepExecutor+", "+model.ProcessExecutor+" or "+model.ScriptExecutor+" (default is chosen by the ResMan)")
+	return f
+}
+
+// jobs creates the jobs that are described by the flags, command is the program and its arguments
+// that every job runs, the jobs sleep if it is empty and there is no script
+func (f *jobFlags) jobs(command []string) []model.Job {
+	var script string
+	if *f.script != "" {
+		var e error
+		if script, e = readScript(*f.script); e != nil {
+			fatalf("Failed to read script, %v\n", e)
+		}
+	}
+	var jobs []model.Job
+	if *f.file != "" {
+		var e error
+		if jobs, e = readJobFile(*f.file); e != nil {
+			fatalf("Failed to read job file %v, %v\n", *f.file, e)
+		}
+	} else {
+		if *f.count < 1 || *f.count > model.MaxJobsPerRequest {
+			fatalf("Invalid count %v, it must be between 1 and %v\n", *f.count, model.MaxJobsPerRequest)
+		}
+		jobs = make([]model.Job, *f.count)
+		for i := range jobs {
+			jobs[i].Duration = time.Duration(f.duration)
+			if f.duration == 0 && len(command) == 0 && script == "" {
+				jobs[i].Duration = time.Duration(rand.Intn(10)+1) * time.Second
+			}
+		}
+	}
+	if *f.array != "" {
+		a, e := model.ParseArraySpec(*f.array)
+		if e != nil {
+			fatalf("%v\n", e)
+		}
+		for i := range jobs {
+			jobs[i].Array = &a
+		}
+	}
+	if *f.callback != "" {
+		for i := range jobs {
+			jobs[i].Callbacks = append(jobs[i].Callbacks, strings.Split(*f.callback, ",")...)
+		}
+	}
+	var notBefore time.Time
+	if *f.after != "" {
+		t, e := time.Parse(time.RFC3339, *f.after)
+		if e != nil {
+			fatalf("Invalid time %v, %v\n", *f.after, e)
+		}
+		notBefore = t
+	}
+	if *f.delay > 0 {
+		notBefore = time.Now().Add(*f.delay)
+	}
+	if !notBefore.IsZero() {
+		for i := range jobs {
+			jobs[i].NotBefore = notBefore
+		}
+	}
+	if *f.priority != 0 {
+		for i := range jobs {
+			jobs[i].Priority = *f.priority
+		}
+	}
+	if *f.checkpoint > 0 {
+		for i := range jobs {
+			jobs[i].Checkpoint = *f.checkpoint
+		}
+	}
+	if len(command) > 0 {
+		for i := range jobs {
+			jobs[i].Command = command
+		}
+	}
+	if script != "" {
+		for i := range jobs {
+			jobs[i].Script = script
+		}
+	}
+	if *f.executor != "" {
+		for i := range jobs {
+			jobs[i].Executor = *f.executor
+		}
+	}
+	inputs, outputs := parseStageFiles(*f.inputs), parseStageFiles(*f.outputs)
+	for i := range jobs {
+		jobs[i].Inputs = append(jobs[i].Inputs, inputs...)
+		jobs[i].Outputs = append(jobs[i].Outputs, outputs...)
+	}
+	return jobs
+}
+
+// parseStageFiles parses a comma separated list of path=scheme:key
+func parseStageFiles(s string) []model.StageFile {
+	if s == "" {
+		return nil
+	}
+	var files []model.StageFile
+	for _, part := range strings.Split(s, ",") {
+		f, e := model.ParseStageFile(part)
+		if e != nil {
+			fatalf("%v\n", e)
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+func submitCmd(c *client, p printer, args []string) {
+	fs := flag.NewFlagSet("submit", flag.ExitOnError)
+	jf := addJobFlags(fs)
+	rmAddr := fs.String("rm", "", "submit the jobs to the RM on this address instead of a GS")
+	key := fs.String("key", "", "idempotency key, a retry with the same key does not create new jobs (default is a random key)")
+	wait := fs.Bool("wait", false, "wait until the jobs are finished, exit with a non-zero status if any of them failed")
+	timeout := fs.Duration("timeout", 0, "with -wait, give up after this duration, 0 means wait forever")
+	fs.Parse(args)
+
+	if *key == "" {
+		*key = randomKey()
+	}
+	jobs := jf.jobs(fs.Args())
+	// failover retries the call on the next GS with the same key, so the jobs are not added twice
+	userArgs := model.UserJobsArgs{Creds: c.creds, Jobs: jobs, IdempotencyKey: *key}
+	var reply model.SubmitReply
+	fn := "GridSdr.AddJobsViaUser"
+	if *rmAddr != "" {
+		// the RM forwards the jobs to a GS if it doesn't have enough capacity
+		c = &client{[]string{*rmAddr}, c.creds}
+		fn = "ResMan.AddJobsViaUser"
+	}
+	if e := c.call(fn, &userArgs, &reply); e != nil {
+		fatalf("Failed to submit jobs, %v\n", e)
+	}
+
+	if reply.Duplicate {
+		log.Printf("Submission %v was already accepted, the jobs were not added again\n", *key)
+	}
+	if *wait {
+		waitForJobs(c, p, reply.IDs, false, *timeout)
+		return
+	}
+	p.submitted(reply)
+}
+
+// randomKey returns a random idempotency key
+func randomKey() string {
+	b := make([]byte, 16)
+	if _, e := crand.Read(b); e != nil {
+		fatalf("Failed to generate idempotency key, %v\n", e)
+	}
+	return hex.EncodeToString(b)
+}
+
+func statusCmd(c *client, p printer, args []string) {
+	var jobs []model.Job
+	if e := c.call("GridSdr.JobStatus", &model.UserIDsArgs{Creds: c.creds, IDs: parseIDs(args)}, &jobs); e != nil {
+		fatalf("Failed to get job status, %v\n", e)
+	}
+	p.jobs(jobs)
+}
+
+func listCmd(c *client, p printer, args []string) {
+	var jobs []model.Job
+	if e := c.call("GridSdr.ListJobs", &c.creds, &jobs); e != nil {
+		fatalf("Failed to list jobs, %v\n", e)
+	}
+	p.jobs(jobs)
+}
+
+func cancelCmd(c *client, p printer, args []string) {
+	reply := -1
+	if e := c.call("GridSdr.CancelJobs", &model.UserIDsArgs{Creds: c.creds, IDs: parseIDs(args)}, &reply); e != nil {
+		fatalf("Failed to cancel jobs, %v\n", e)
+	}
+	if !p.json(map[string]int{"cancelled": reply}) && p.format != formatQuiet {
+		fmt.Printf("Cancelled %v jobs\n", reply)
+	}
+}
+
+func logsCmd(c *client, p printer, args []string) {
+	ids := parseIDs(args)
+	logs := make(map[int64][]string)
+	if e := c.call("GridSdr.JobLogs", &model.UserIDsArgs{Creds: c.creds, IDs: ids}, &logs); e != nil {
+		fatalf("Failed to get logs, %v\n", e)
+	}
+	p.logs(ids, logs)
+}
+
+// waitCmd blocks until the jobs are finished
+func waitCmd(c *client, p printer, args []string) {
+	fs := flag.NewFlagSet("wait", flag.ExitOnError)
+	any := fs.Bool("any", false, "return as soon as one of the jobs is finished")
+	timeout := fs.Duration("timeout", 0, "give up after this duration, 0 means wait forever")
+	fs.Parse(args)
+	waitForJobs(c, p, parseIDs(fs.Args()), *any, *timeout)
+}
+
+// waitForJobs calls GridSdr.WaitJobs until all (or any) of the jobs are finished and prints them.
+// It exits with a non-zero status if a job failed or was cancelled, or on timeout.
+func waitForJobs(c *client, p printer, ids []int64, any bool, timeout time.Duration) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	var reply model.WaitReply
+	for {
+		args := model.WaitArgs{Creds: c.creds, IDs: ids, Any: any}
+		if !deadline.IsZero() {
+			args.Timeout = deadline.Sub(time.Now())
+		}
+		reply = model.WaitReply{}
+		if e := c.call("GridSdr.WaitJobs", &args, &reply); e != nil {
+			fatalf("Failed to wait for jobs, %v\n", e)
+		}
+		// the GS returns after at most a few minutes so we call it again unless it's our timeout
+		if !reply.TimedOut || (!deadline.IsZero() && !time.Now().Before(deadline)) {
+			break
+		}
+	}
+
+	p.jobs(reply.Finished)
+	if reply.TimedOut {
+		fatalf("Timeout, %v jobs are not finished\n", len(reply.Pending))
+	}
+	for _, job := range reply.Finished {
+		if job.State != model.JobCompleted && job.Reason != "" {
+			fatalf("Job %v is %v (%v)\n", job.ID, strings.TrimPrefix(job.State.String(), "Job"), job.Reason)
+		} else if job.State != model.JobCompleted {
+			fatalf("Job %v is %v\n", job.ID, strings.TrimPrefix(job.State.String(), "Job"))
+		}
+	}
+}
+
+func nodesCmd(c *client, p printer, args []string) {
+	var nodes []model.NodeInfo
+	x := 0
+	if e := c.call("GridSdr.GetNodes", &x, &nodes); e != nil {
+		fatalf("Failed to get nodes, %v\n", e)
+	}
+	p.nodes(nodes)
+}
+
+func leaderCmd(c *client, p printer, args []string) {
+	var leader string
+	x := 0
+	if e := c.call("GridSdr.GetLeader", &x, &leader); e != nil {
+		fatalf("Failed to get leader, %v\n", e)
+	}
+	p.leader(leader)
+}
+
+func queueCmd(c *client, p printer, args []string) {
+	var entries []model.QueueEntry
+	if e := c.call("GridSdr.GetQueue", &c.creds, &entries); e != nil {
+		fatalf("Failed to get queue, %v\n", e)
+	}
+	p.queue(entries)
+}
+
+// deliveriesCmd shows the webhook deliveries, they are recorded by the leader so it is asked directly
+func deliveriesCmd(c *client, p printer, args []string) {
+	var leader string
+	x := 0
+	if e := c.call("GridSdr.GetLeader", &x, &leader); e != nil {
+		fatalf("Failed to get leader, %v\n", e)
+	}
+	var deliveries []model.Delivery
+	leaderClient := &client{[]string{leader}, c.creds}
+	if e := leaderClient.call("GridSdr.GetDeliveries", &c.creds, &deliveries); e != nil {
+		fatalf("Failed to get deliveries, %v\n", e)
+	}
+	p.deliveries(deliveries)
+}
+
+// reportCmd aggregates the accounting records of the finished jobs, the table is printed unless the report is exported
+func reportCmd(c *client, p printer, args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	by := fs.String("by", "", "comma separated fields that the jobs are grouped by: "+model.ReportByUser+", "+model.ReportByGroup+" or "+model.ReportByRM)
+	window := fs.Duration("window", 0, "also group the jobs by when they finished in windows of this length, e.g. 24h")
+	since := fs.String("since", "", "only the jobs that finished at or after this time, RFC 3339 or a duration before now, e.g. 168h")
+	until := fs.String("until", "", "only the jobs that finished before this time, RFC 3339 or a duration before now")
+	export := fs.String("export", "", "export the report as csv or json")
+	out := fs.String("out", "", "file that the export is written to (default is stdout)")
+	fs.Parse(args)
+
+	rargs := model.ReportArgs{Creds: c.creds, Window: *window}
+	if *by != "" {
+		rargs.By = strings.Split(*by, ",")
+	}
+	if e := model.ValidateReportBy(rargs.By); e != nil {
+		fatalf("%v\n", e)
+	}
+	if *window < 0 {
+		fatalf("Invalid window %v\n", *window)
+	}
+	var e error
+	now := time.Now()
+	if rargs.Since, e = model.ParseReportTime(*since, now); e != nil {
+		fatalf("%v\n", e)
+	}
+	if rargs.Until, e = model.ParseReportTime(*until, now); e != nil {
+		fatalf("%v\n", e)
+	}
+	if *export != "" && *export != "csv" && *export != "json" {
+		fatalf("Invalid export format %v, expected csv or json\n", *export)
+	}
+
+	var rows []model.ReportRow
+	if e := c.call("GridSdr.Report", &rargs, &rows); e != nil {
+		fatalf("Failed to get report, %v\n", e)
+	}
+	if *export == "" {
+		p.report(rows)
+		return
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		if w, e = os.Create(*out); e != nil {
+			fatalf("Failed to create %v, %v\n", *out, e)
+		}
+	}
+	if *export == "csv" {
+		e = model.WriteReportCSV(w, rows)
+	} else {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		e = enc.Encode(model.ReportJSON(rows))
+	}
+	if e == nil && *out != "" {
+		e = w.Close()
+	}
+	if e != nil {
+		fatalf("Failed to export report, %v\n", e)
+	}
+}
+
+// eventsCmd prints the events after a sequence number, with -follow it keeps waiting for new events.
+// The sequence numbers are the same on every GS so the stream continues when the client fails over.
+func eventsCmd(c *client, p printer, args []string) {
+	fs := flag.NewFlagSet("events", flag.ExitOnError)
+	after := fs.Int64("after", 0, "print the events after this sequence number, 0 prints all the events that the GS remembers")
+	follow := fs.Bool("follow", false, "keep waiting for new events")
+	fs.Parse(args)
+
+	p.eventHeader()
+	cursor := *after
+	for {
+		wargs := model.WatchArgs{Creds: c.creds, After: cursor}
+		if !*follow {
+			wargs.Timeout = time.Millisecond
+		}
+		var reply model.WatchReply
+		if e := c.call("GridSdr.WatchEvents", &wargs, &reply); e != nil {
+			fatalf("Failed to watch events, %v\n", e)
+		}
+		if reply.Truncated {
+			log.Printf("Some events after %v are no longer available\n", cursor)
+		}
+		for _, ev := range reply.Events {
+			p.event(ev)
+		}
+		cursor = reply.Last
+		if reply.TimedOut && !*follow {
+			return
+		}
+	}
+}
+
+// cronCmd creates, lists, pauses, resumes and deletes cron jobs
+func cronCmd(c *client, p printer, args []string) {
+	if len(args) == 0 {
+		fatalf("A cron subcommand is required: create, list, pause, resume or delete\n")
+	}
+	switch sub, args := args[0], args[1:]; sub {
+	case "create":
+		fs := flag.NewFlagSet("cron create", flag.ExitOnError)
+		name := fs.String("name", "", "the unique name of the cron job")
+		schedule := fs.String("schedule", "", "cron expression, e.g. \"*/5 * * * *\", \"@daily\" or \"@every 30s\"")
+		policy := fs.String("policy", string(model.CronAllow), "what to do when the previous run is not finished, \"allow\", \"forbid\" (skip) or \"replace\" (cancel it)")
+		jf := addJobFlags(fs)
+		fs.Parse(args)
+		cron := model.CronJob{Name: *name, Schedule: *schedule, Policy: model.CronPolicy(*policy), Jobs: jf.jobs(fs.Args())}
+		var reply model.CronJob
+		if e := c.call("GridSdr.CreateCron", &model.CronArgs{Creds: c.creds, Cron: cron}, &reply); e != nil {
+			fatalf("Failed to create cron job, %v\n", e)
+		}
+		p.crons([]model.CronJob{reply})
+	case "list":
+		var crons []model.CronJob
+		if e := c.call("GridSdr.ListCrons", &c.creds, &crons); e != nil {
+			fatalf("Failed to list cron jobs, %v\n", e)
+		}
+		p.crons(crons)
+	case "pause", "resume":
+		if len(args) != 1 {
+			fatalf("The name of the cron job is required\n")
+		}
+		var reply model.CronJob
+		if e := c.call("GridSdr.PauseCron", &model.CronNameArgs{Creds: c.creds, Name: args[0], Paused: sub == "pause"}, &reply); e != nil {
+			fatalf("Failed to %v cron job, %v\n", sub, e)
+		}
+		p.crons([]model.CronJob{reply})
+	case "delete":
+		if len(args) != 1 {
+			fatalf("The name of the cron job is required\n")
+		}
+		reply := -1
+		if e := c.call("GridSdr.DeleteCron", &model.CronNameArgs{Creds: c.creds, Name: args[0]}, &reply); e != nil {
+			fatalf("Failed to delete cron job, %v\n", e)
+		}
+		if !p.json(map[string]string{"deleted": args[0]}) && p.format != formatQuiet {
+			fmt.Printf("Deleted cron job %v\n", args[0])
+		}
+	default:
+		fatalf("Unknown cron subcommand %v\n", sub)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+import "github.com/kc1212/virtual-grid/common"
+
+// envPrefix is the prefix of the environment variables of the CLI, it differs from common.EnvPrefix
+// so that the variables of the CLI don't set the flags of a node that runs in the same environment
+const envPrefix = "VGRID_CLI_"
+
+// envName returns the environment variable of the CLI for name
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(name)
+}
+
+// config is the CLI configuration file, it is JSON encoded
+type config struct {
+	Token   string   `json:"token"`
+	GSAddrs []string `json:"gs_addrs"` // tried in order until one is online
+	TLS     bool     `json:"tls"`      // connect to the GSs with TLS, it is implied by TLSCA
+	TLSCA   string   `json:"tls_ca"`   // PEM certificates that the GS certificates are verified with, the system roots if empty
+}
+
+// readConfig reads the config file at path, a missing file results in an empty config.
+// VGRID_CLI_TOKEN and VGRID_CLI_ADDR (comma separated) take precedence over the file.
+func readConfig(path string) (config, error) {
+	var conf config
+	b, e := ioutil.ReadFile(path)
+	if e != nil && !os.IsNotExist(e) {
+		return conf, e
+	} else if e == nil {
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+		if e := d.Decode(&conf); e != nil {
+			return conf, e
+		}
+	}
+
+	if v, ok := os.LookupEnv(envName("token")); ok {
+		conf.Token = v
+	}
+	if v, ok := os.LookupEnv(envName("addr")); ok {
+		conf.GSAddrs = strings.Split(v, ",")
+	}
+	for _, addr := range conf.GSAddrs {
+		if e := common.ValidateAddr("GS", addr); e != nil {
+			return conf, e
+		}
+	}
+	if conf.TLS || conf.TLSCA != "" {
+		if e := common.ClientTLS(conf.TLSCA); e != nil {
+			return conf, e
+		}
+	}
+	return conf, nil
+}
@@ -5,59 +5,76 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
-	"net/rpc"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
-import "github.com/kc1212/virtual-grid/model"
+import (
+	"github.com/kc1212/virtual-grid/common"
+	"github.com/kc1212/virtual-grid/model"
+)
 
-func main() {
-	addr := flag.String("addr", "localhost:3000", "address:port of the grid scheduler")
-	jobsCount := flag.Int("count", 1, "the number of jobs to add")
-	duration := flag.Int64("duration", 0, "the duration for the jobs (default is a random value)")
-	nodeType := flag.String("type", "gs", "add job on \"gs\" or \"rm\"")
-	flag.Parse()
+// fatalf logs the message and exits with a non-zero status
+func fatalf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+	os.Exit(1)
+}
 
-	if *nodeType != "gs" && *nodeType != "rm" {
-		flag.PrintDefaults()
-		return
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %v [flags] <command> [args]\n\ncommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %v %v\n", cmd.name, cmd.usage)
 	}
+	fmt.Fprintf(os.Stderr, "\nflags:\n")
+	flag.PrintDefaults()
+}
 
-	rand.Seed(time.Now().UTC().UnixNano())
-	jobs := make([]model.Job, *jobsCount)
-	for i := range jobs {
-		jobs[i].ID = rand.Int63()
-		var str string
-		if *duration == 0 {
-			str = fmt.Sprintf("%vs", rand.Intn(10)+1)
-		} else {
-			str = fmt.Sprintf("%vs", *duration)
-		}
+func main() {
+	addr := flag.String("addr", "", "comma separated address:port of the grid schedulers, overrides gs_addrs in the config file")
+	defaultConfig := os.Getenv(envName("config"))
+	if defaultConfig == "" {
+		defaultConfig = filepath.Join(os.Getenv("HOME"), ".vgrid.json")
+	}
+	configPath := flag.String("config", defaultConfig, "config file with the API token, the GS addresses and the TLS settings, "+envName("token")+" and "+envName("addr")+" take precedence")
+	format := flag.String("o", formatTable, "output format, \"table\", \"json\" or \"quiet\"")
+	flag.Usage = usage
+	flag.Parse()
 
-		duration, e := time.ParseDuration(str)
-		if e != nil {
-			log.Panic(e)
-		}
-		jobs[i].Duration = duration
-		jobs[i].StartTime = time.Now()
+	log.SetFlags(0)
+	log.SetPrefix("cli: ")
+
+	if flag.NArg() == 0 || !validFormat(*format) {
+		usage()
+		os.Exit(2)
 	}
 
-	reply := -1
-	remote, e := rpc.DialHTTP("tcp", *addr)
+	conf, e := readConfig(*configPath)
 	if e != nil {
-		log.Printf("Node %v is not online, make sure to use the correct address? %v\n", *addr, e.Error())
-		return
+		fatalf("Invalid configuration (%v), %v\n", *configPath, e.Error())
 	}
-
-	if *nodeType == "gs" {
-		if e := remote.Call("GridSdr.AddJobsViaUser", &jobs, &reply); e != nil {
-			log.Printf("Remote call GridSdr.AddJobsViaUser failed on %v, %v\n", *addr, e.Error())
-			return
+	c := &client{conf.GSAddrs, model.Credentials{Token: conf.Token}}
+	if *addr != "" {
+		c.addrs = strings.Split(*addr, ",")
+		for _, a := range c.addrs {
+			if e := common.ValidateAddr("GS", a); e != nil {
+				fatalf("%v\n", e)
+			}
 		}
-	} else if *nodeType == "rm" {
-		if e := remote.Call("ResMan.AddJobsViaUser", &jobs, &reply); e != nil {
-			log.Printf("Remote call ResMan.AddJobsViaUser failed on %v, %v\n", *addr, e.Error())
+	} else if len(c.addrs) == 0 {
+		c.addrs = []string{"localhost:3000"}
+	}
+
+	rand.Seed(time.Now().UTC().UnixNano())
+	name := flag.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			cmd.run(c, printer{*format}, flag.Args()[1:])
 			return
 		}
 	}
+	log.Printf("Unknown command %v\n", name)
+	usage()
+	os.Exit(2)
 }
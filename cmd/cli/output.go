@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+import (
+	"github.com/kc1212/virtual-grid/common"
+	"github.com/kc1212/virtual-grid/model"
+)
+
+// output formats
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatQuiet = "quiet"
+)
+
+// printer writes the results of the commands to stdout in one of the output formats
+type printer struct {
+	format string
+}
+
+func validFormat(f string) bool {
+	return f == formatTable || f == formatJSON || f == formatQuiet
+}
+
+// json prints v as indented JSON, it returns false if the format is not JSON
+func (p printer) json(v interface{}) bool {
+	if p.format != formatJSON {
+		return false
+	}
+	b, e := json.MarshalIndent(v, "", "  ")
+	if e != nil {
+		fatalf("Failed to encode output, %v\n", e)
+	}
+	fmt.Println(string(b))
+	return true
+}
+
+// table prints the rows with aligned columns, the header is not printed in the quiet format
+// and only the first column is printed
+func (p printer) table(header []string, rows [][]string) {
+	if p.format == formatQuiet {
+		for _, row := range rows {
+			fmt.Println(row[0])
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+func (p printer) jobs(jobs []model.Job) {
+	if p.json(jobs) {
+		return
+	}
+	rows := make([][]string, len(jobs))
+	for i, job := range jobs {
+		rows[i] = []string{
+			fmt.Sprint(job.ID),
+			job.Owner,
+			strings.TrimPrefix(job.State.String(), "Job"),
+			fmt.Sprint(job.Priority),
+			job.ResMan,
+			job.Duration.String(),
+			formatTime(job.NotBefore),
+			formatTime(job.StartTime),
+			formatTime(job.FinishTime),
+			formatArray(job),
+			formatCPU(job.Usage.CPUTime),
+			formatBytes(job.Usage.MaxRSS),
+			formatIO(job.Usage),
+			formatError(job),
+		}
+	}
+	p.table([]string{"ID", "OWNER", "STATE", "PRIO", "RM", "DURATION", "NOT BEFORE", "STARTED", "FINISHED", "ARRAY", "CPU", "MAX RSS", "READ/WRITE", "ERROR"}, rows)
+}
+
+// formatCPU shows a CPU time to the millisecond, it is empty for jobs without usage
+func formatCPU(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+// formatBytes shows a number of bytes with a binary suffix, it is empty for 0
+func formatBytes(n int64) string {
+	if n == 0 {
+		return ""
+	}
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%vB", n)
+	}
+	v, i := float64(n)/1024, 0
+	for ; v >= 1024 && i < len(units)-1; i++ {
+		v /= 1024
+	}
+	return fmt.Sprintf("%.1f%ciB", v, units[i])
+}
+
+// formatIO shows the bytes that a job read and wrote
+func formatIO(s model.ExecStats) string {
+	if s.ReadBytes == 0 && s.WriteBytes == 0 {
+		return ""
+	}
+	read, write := formatBytes(s.ReadBytes), formatBytes(s.WriteBytes)
+	if read == "" {
+		read = "0"
+	}
+	if write == "" {
+		write = "0"
+	}
+	return read + "/" + write
+}
+
+// formatError shows the error of a job with the reason if it failed because of its files
+func formatError(job model.Job) string {
+	if job.Reason != "" {
+		return string(job.Reason) + ": " + job.Error
+	}
+	return job.Error
+}
+
+// formatArray shows the range and the tasks of a job array, or the array and the index of an array task
+func formatArray(job model.Job) string {
+	if job.ArrayID != 0 {
+		return fmt.Sprintf("%v[%v]", job.ArrayID, job.ArrayIndex)
+	} else if job.Array == nil {
+		return ""
+	}
+	res := job.Array.String()
+	for s := model.JobQueued; s <= model.JobCancelled; s++ {
+		if n := job.ArrayTasks[s]; n > 0 {
+			res += fmt.Sprintf(" %v:%v", strings.ToLower(strings.TrimPrefix(s.String(), "Job")), n)
+		}
+	}
+	return res
+}
+
+func (p printer) submitted(reply model.SubmitReply) {
+	if p.json(reply) {
+		return
+	}
+	rows := make([][]string, len(reply.IDs))
+	for i, id := range reply.IDs {
+		rows[i] = []string{fmt.Sprint(id)}
+	}
+	p.table([]string{"ID"}, rows)
+}
+
+func (p printer) logs(ids []int64, logs map[int64][]string) {
+	if p.json(logs) {
+		return
+	}
+	for _, id := range ids {
+		lines, ok := logs[id]
+		if !ok {
+			continue
+		}
+		if p.format != formatQuiet {
+			fmt.Printf("==> job %v <==\n", id)
+		}
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+	}
+}
+
+func (p printer) nodes(nodes []model.NodeInfo) {
+	if p.json(nodes) {
+		return
+	}
+	rows := make([][]string, len(nodes))
+	for i, node := range nodes {
+		free, running, cpu, rss := "", "", "", ""
+		if node.Type == common.RMNode && node.Capacity < 0 {
+			free = "offline"
+		} else if node.Type == common.RMNode {
+			free = fmt.Sprintf("%v/%v", node.Capacity, node.Workers)
+			running, cpu, rss = fmt.Sprint(node.Running), formatCPU(node.Usage.CPUTime), formatBytes(node.Usage.RSS)
+		}
+		leader := ""
+		if node.Leader {
+			leader = "*"
+		}
+		rows[i] = []string{node.Addr, strings.TrimSuffix(node.Type.String(), "Node"), fmt.Sprint(node.ID), leader, free, running, cpu, rss,
+			common.FormatLabels(node.Labels), node.UUID}
+	}
+	p.table([]string{"ADDR", "TYPE", "ID", "LEADER", "FREE", "RUNNING", "CPU", "RSS", "LABELS", "UUID"}, rows)
+}
+
+func (p printer) queue(entries []model.QueueEntry) {
+	if p.json(entries) {
+		return
+	}
+	rows := make([][]string, len(entries))
+	for i, e := range entries {
+		rows[i] = []string{e.User, fmt.Sprint(e.Queued), fmt.Sprint(e.Held), fmt.Sprint(e.Deferred), fmt.Sprint(e.Running)}
+	}
+	p.table([]string{"USER", "QUEUED", "HELD", "DEFERRED", "RUNNING"}, rows)
+}
+
+func (p printer) report(rows []model.ReportRow) {
+	if p.json(model.ReportJSON(rows)) {
+		return
+	}
+	table := make([][]string, len(rows))
+	for i, r := range rows {
+		window := ""
+		if !r.Window.IsZero() {
+			window = r.Window.Format(time.RFC3339)
+		}
+		table[i] = []string{window, r.User, r.Group, r.ResMan, fmt.Sprint(r.Jobs), fmt.Sprint(r.Completed), fmt.Sprint(r.Failed),
+			fmt.Sprint(r.Cancelled), r.WallTime.Round(time.Second).String(), formatCPU(r.CPUTime), formatBytes(r.MaxRSS),
+			formatIO(model.ExecStats{ReadBytes: r.ReadBytes, WriteBytes: r.WriteBytes})}
+	}
+	p.table([]string{"WINDOW", "USER", "GROUP", "RM", "JOBS", "COMPLETED", "FAILED", "CANCELLED", "WALL", "CPU", "MAX RSS", "READ/WRITE"}, table)
+}
+
+func (p printer) deliveries(deliveries []model.Delivery) {
+	if p.json(deliveries) {
+		return
+	}
+	rows := make([][]string, len(deliveries))
+	for i, d := range deliveries {
+		status := "failed"
+		if d.Delivered {
+			status = "delivered"
+		}
+		rows[i] = []string{fmt.Sprint(d.JobID), d.Event, d.URL, status, fmt.Sprint(d.Attempts), formatTime(d.Time), d.LastError}
+	}
+	p.table([]string{"JOB", "EVENT", "URL", "STATUS", "ATTEMPTS", "TIME", "ERROR"}, rows)
+}
+
+// eventFormat is the layout of the rows of the events command, the rows are printed as they arrive
+const eventFormat = "%-8v  %-20v  %-11v  %-8v  %v\n"
+
+func (p printer) eventHeader() {
+	if p.format == formatTable {
+		fmt.Printf(eventFormat, "SEQ", "TIME", "TYPE", "JOB", "NODE")
+	}
+}
+
+// event prints one event, the JSON format has one object per line
+func (p printer) event(ev model.Event) {
+	switch p.format {
+	case formatJSON:
+		b, e := json.Marshal(ev)
+		if e != nil {
+			fatalf("Failed to encode output, %v\n", e)
+		}
+		fmt.Println(string(b))
+	case formatQuiet:
+		fmt.Println(ev.Seq)
+	default:
+		job, node := "-", ev.Node
+		if ev.Job != nil {
+			job, node = fmt.Sprint(ev.Job.ID), ev.Job.ResMan
+		}
+		fmt.Printf(eventFormat, ev.Seq, formatTime(ev.Time), ev.Type, job, node)
+	}
+}
+
+func (p printer) crons(crons []model.CronJob) {
+	if p.json(crons) {
+		return
+	}
+	rows := make([][]string, len(crons))
+	for i, cron := range crons {
+		state, next := "active", formatTime(cron.Next)
+		if cron.Paused {
+			state, next = "paused", "-"
+		}
+		ids := make([]string, len(cron.LastIDs))
+		for k, id := range cron.LastIDs {
+			ids[k] = fmt.Sprint(id)
+		}
+		rows[i] = []string{cron.Name, cron.Owner, cron.Schedule, string(cron.Policy), state, next, formatTime(cron.LastRun),
+			fmt.Sprint(cron.Runs), fmt.Sprint(cron.Skipped), strings.Join(ids, ","), cron.LastSkip}
+	}
+	p.table([]string{"NAME", "OWNER", "SCHEDULE", "POLICY", "STATE", "NEXT", "LAST RUN", "RUNS", "SKIPPED", "LAST JOBS", "LAST SKIP"}, rows)
+}
+
+func (p printer) leader(addr string) {
+	if p.json(map[string]string{"leader": addr}) {
+		return
+	}
+	fmt.Println(addr)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
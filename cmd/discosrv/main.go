@@ -2,17 +2,44 @@ package main
 
 import (
 	"flag"
+	"log"
 	"net"
 )
 
-import "github.com/kc1212/virtual-grid/discosrv"
+import (
+	"github.com/kc1212/virtual-grid/common"
+	"github.com/kc1212/virtual-grid/discosrv"
+)
 
 func main() {
 	defaultAddr := net.JoinHostPort("localhost", "3333")
 	discorvAddr := flag.String("addr", defaultAddr, "hostname:port for the DiscoSrv")
+	secretFile := flag.String("cluster-secret", "", "file with the secret that the GSs, RMs and the discovery server share, the internal RPCs are served to every client if empty")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate that the RPCs and the dashboard are served with, TLS is disabled if empty")
+	tlsKey := flag.String("tls-key", "", "PEM key of -tls-cert")
+	tlsCA := flag.String("tls-ca", "", "PEM certificates that the certificates of the other nodes are verified with (default is the system roots)")
+	timings := common.TimingFlags(flag.CommandLine)
+	config := flag.String("config", "", "JSON config file with flag names as keys, flags and "+common.EnvPrefix+"* environment variables take precedence")
 
 	flag.Parse()
 
-	ds := discosrv.Srv{}
+	if e := common.LoadConfig(flag.CommandLine, *config); e != nil {
+		log.Fatal(e)
+	}
+	t, e := timings()
+	if e != nil {
+		log.Fatal(e)
+	}
+	if e := common.LoadClusterSecret(*secretFile); e != nil {
+		log.Fatal(e)
+	}
+	if e := common.LoadTLS(*tlsCert, *tlsKey, *tlsCA); e != nil {
+		log.Fatal(e)
+	}
+	if e := common.ValidateAddr("discovery server", *discorvAddr); e != nil {
+		log.Fatal(e)
+	}
+
+	ds := discosrv.InitSrv(t)
 	ds.Run(*discorvAddr)
 }
@@ -2,20 +2,131 @@ package main
 
 import (
 	"flag"
+	"log"
 	"net"
+	"strings"
 )
 
-import "github.com/kc1212/virtual-grid/model"
+import (
+	"github.com/kc1212/virtual-grid/common"
+	"github.com/kc1212/virtual-grid/model"
+)
 
 func main() {
 	defaultAddr := net.JoinHostPort("localhost", "3000")
 
-	name := flag.String("addr", defaultAddr, "hostname:port for this node")
+	name := flag.String("addr", defaultAddr, "comma separated hostname:port addresses that other nodes use to reach this node, the first one is the main address")
+	bind := flag.String("bind", "", "hostname:port to listen on, e.g. 0.0.0.0:3000, the main -addr if empty")
 	id := flag.Int("id", 0, "id of the node")
+	identity := flag.String("identity", "", "file that stores the UUID of the node, it is created if it does not exist (default is ~/.vgrid/gs-<id>.uuid), a new UUID is used on every start if it is set to \"\"")
 	discosrvAddr := flag.String("discosrv", "localhost:3333", "address of discovery server")
+	secretFile := flag.String("cluster-secret", "", "file with the secret that the GSs, RMs and the discovery server share, the internal RPCs are served to every client if empty")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate that the RPCs and the REST gateway are served with, TLS is disabled if empty")
+	tlsKey := flag.String("tls-key", "", "PEM key of -tls-cert")
+	tlsCA := flag.String("tls-ca", "", "PEM certificates that the certificates of the other nodes are verified with (default is the system roots)")
+	labels := flag.String("labels", "", "comma separated key=value labels of the node, e.g. zone=eu-west,gpu=true")
+	userFile := flag.String("users", "", "JSON file with the API tokens of the users, authentication is disabled if empty")
+	quotaFile := flag.String("quotas", "", "JSON file with the initial quotas of users and groups")
+	policy := flag.String("policy", string(model.PolicyFIFO), "scheduling policy, \"fifo\" or \"fairshare\"")
+	shareFile := flag.String("shares", "", "JSON file with the fair-share half-life and the shares of users and groups")
+	preemptFile := flag.String("preempt", "", "JSON file with the preemption policy, preemption is disabled if empty")
+	callbacks := flag.String("callbacks", "", "comma separated URLs that receive the events of every job")
+	callbackHosts := flag.String("callback-hosts", "", "comma separated hosts that the callbacks of the jobs may be sent to, any host if empty")
+	accountingDB := flag.String("accounting-db", "", "file that the accounting records of the finished jobs are appended to, it is created if it does not exist, accounting is disabled if empty")
+	timings := common.TimingFlags(flag.CommandLine)
+	config := flag.String("config", "", "JSON config file with flag names as keys, flags and "+common.EnvPrefix+"* environment variables take precedence")
 
 	flag.Parse()
 
-	gs := model.InitGridSdr(*id, *name, *discosrvAddr)
+	if e := common.LoadConfig(flag.CommandLine, *config); e != nil {
+		log.Fatal(e)
+	}
+	t, e := timings()
+	if e != nil {
+		log.Fatal(e)
+	}
+	if e := common.LoadClusterSecret(*secretFile); e != nil {
+		log.Fatal(e)
+	}
+	if e := common.LoadTLS(*tlsCert, *tlsKey, *tlsCA); e != nil {
+		log.Fatal(e)
+	}
+	if *userFile != "" && *secretFile == "" {
+		log.Fatal("-users requires -cluster-secret, otherwise any client can call the internal RPCs with jobs of any user")
+	}
+	addrs, e := common.ParseAddrs("node", *name)
+	if e != nil {
+		log.Fatal(e)
+	}
+	if *bind == "" {
+		*bind = addrs[0]
+	}
+	if e := common.ValidateAddr("bind", *bind); e != nil {
+		log.Fatal(e)
+	}
+	if e := common.ValidateAddr("discovery server", *discosrvAddr); e != nil {
+		log.Fatal(e)
+	}
+	// the id is part of the job IDs, see jobIDGen
+	if *id < 0 || *id >= 1<<16 {
+		log.Fatalf("Invalid id %v, it must be between 0 and 65535\n", *id)
+	}
+
+	nodeLabels, e := common.ParseLabels(*labels)
+	if e != nil {
+		log.Fatal(e)
+	}
+
+	identityFile, e := common.IdentityFile(*identity, common.IsSet(flag.CommandLine, "identity"), common.GSNode, *id)
+	if e != nil {
+		log.Fatal(e)
+	}
+	uuid, e := common.LoadIdentity(identityFile)
+	if e != nil {
+		log.Fatal(e)
+	}
+
+	users, e := model.LoadUserTable(*userFile)
+	if e != nil {
+		log.Fatal(e)
+	}
+
+	quotas, e := model.LoadQuotaTable(*quotaFile)
+	if e != nil {
+		log.Fatal(e)
+	}
+
+	if p := model.SchedPolicy(*policy); p != model.PolicyFIFO && p != model.PolicyFairShare {
+		log.Fatalf("Invalid scheduling policy %v\n", *policy)
+	}
+	shares, e := model.LoadFairShareConfig(*shareFile)
+	if e != nil {
+		log.Fatal(e)
+	}
+
+	preempt, e := model.LoadPreemptPolicy(*preemptFile)
+	if e != nil {
+		log.Fatal(e)
+	}
+
+	var hooks model.WebhookConfig
+	if *callbacks != "" {
+		hooks.URLs = strings.Split(*callbacks, ",")
+	}
+	for _, u := range hooks.URLs {
+		if e := model.ValidateCallback(u); e != nil {
+			log.Fatal(e)
+		}
+	}
+	if *callbackHosts != "" {
+		hooks.Hosts = strings.Split(*callbackHosts, ",")
+	}
+
+	accounting, e := model.OpenAccountingDB(*accountingDB)
+	if e != nil {
+		log.Fatal(e)
+	}
+
+	gs := model.InitGridSdr(*id, uuid, addrs, nodeLabels, *bind, *discosrvAddr, users, quotas, model.SchedPolicy(*policy), shares, preempt, hooks, accounting, t)
 	gs.Run()
 }
@@ -2,21 +2,121 @@ package main
 
 import (
 	"flag"
+	"io/ioutil"
+	"log"
 	"net"
+	"os"
+	"strings"
 )
 
-import "github.com/kc1212/virtual-grid/model"
+import (
+	"github.com/kc1212/virtual-grid/common"
+	"github.com/kc1212/virtual-grid/model"
+)
 
 func main() {
+	// the RM re-executes itself to start the commands of the jobs in their sandbox
+	model.SandboxInit()
+
 	defaultAddr := net.JoinHostPort("localhost", "3000")
 
 	n := flag.Int("nodes", 32, "number of workers")
 	id := flag.Int("id", 0, "id of the ResMan")
-	addr := flag.String("addr", defaultAddr, "hostname:port for this ResMan")
+	identity := flag.String("identity", "", "file that stores the UUID of the node, it is created if it does not exist (default is ~/.vgrid/rm-<id>.uuid), a new UUID is used on every start if it is set to \"\"")
+	addr := flag.String("addr", defaultAddr, "comma separated hostname:port addresses that other nodes use to reach this ResMan, the first one is the main address")
+	bind := flag.String("bind", "", "hostname:port to listen on, e.g. 0.0.0.0:3000, the main -addr if empty")
 	discosrvAddr := flag.String("discosrv", "localhost:3333", "address of discovery server")
+	secretFile := flag.String("cluster-secret", "", "file with the secret that the GSs, RMs and the discovery server share, the internal RPCs are served to every client if empty")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate that the RPCs and the REST gateway are served with, TLS is disabled if empty")
+	tlsKey := flag.String("tls-key", "", "PEM key of -tls-cert")
+	tlsCA := flag.String("tls-ca", "", "PEM certificates that the certificates of the other nodes are verified with (default is the system roots)")
+	labels := flag.String("labels", "", "comma separated key=value labels of the node, e.g. zone=eu-west,gpu=true")
+	userFile := flag.String("users", "", "JSON file with the API tokens of the users, authentication is disabled if empty")
+	checkpointStore := flag.String("checkpoint-store", "", "shared directory for the checkpoints of the jobs, it must be the same on every ResMan, checkpointing is disabled if empty")
+	sharedDir := flag.String("shared-dir", "", "shared directory that files with "+model.SharedScheme+": URIs are staged from and to")
+	objectStore := flag.String("object-store", "", "base URL of an HTTP object store that files with "+model.ObjectScheme+": URIs are staged from and to")
+	objectToken := flag.String("object-store-token", "", "bearer token for the HTTP object store")
+	sandboxFile := flag.String("sandbox", "", "JSON file with the rlimits, cgroup limits and user of the commands of the jobs, they run without limits if empty")
+	executor := flag.String("executor", "", "executor of the jobs that don't select one and have neither a command nor a script, one of "+strings.Join(model.Executors(), ", ")+" (default is "+model.SleepExecutor+", jobs with a script use "+model.ScriptExecutor+" and jobs with a command "+model.ProcessExecutor+")")
+	workDir := flag.String("work-dir", "", "local directory for the files of the running jobs (default is a new temporary directory)")
+	timings := common.TimingFlags(flag.CommandLine)
+	config := flag.String("config", "", "JSON config file with flag names as keys, flags and "+common.EnvPrefix+"* environment variables take precedence")
 
 	flag.Parse()
 
-	rm := model.InitResMan(*n, *id, *addr, *discosrvAddr)
+	if e := common.LoadConfig(flag.CommandLine, *config); e != nil {
+		log.Fatal(e)
+	}
+	t, e := timings()
+	if e != nil {
+		log.Fatal(e)
+	}
+	if e := common.LoadClusterSecret(*secretFile); e != nil {
+		log.Fatal(e)
+	}
+	if e := common.LoadTLS(*tlsCert, *tlsKey, *tlsCA); e != nil {
+		log.Fatal(e)
+	}
+	if *userFile != "" && *secretFile == "" {
+		log.Fatal("-users requires -cluster-secret, otherwise any client can call the internal RPCs with jobs of any user")
+	}
+	addrs, e := common.ParseAddrs("node", *addr)
+	if e != nil {
+		log.Fatal(e)
+	}
+	if *bind == "" {
+		*bind = addrs[0]
+	}
+	if e := common.ValidateAddr("bind", *bind); e != nil {
+		log.Fatal(e)
+	}
+	if e := common.ValidateAddr("discovery server", *discosrvAddr); e != nil {
+		log.Fatal(e)
+	}
+	if *n <= 0 {
+		log.Fatalf("Invalid number of workers %v\n", *n)
+	}
+
+	nodeLabels, e := common.ParseLabels(*labels)
+	if e != nil {
+		log.Fatal(e)
+	}
+
+	identityFile, e := common.IdentityFile(*identity, common.IsSet(flag.CommandLine, "identity"), common.RMNode, *id)
+	if e != nil {
+		log.Fatal(e)
+	}
+	uuid, e := common.LoadIdentity(identityFile)
+	if e != nil {
+		log.Fatal(e)
+	}
+
+	users, e := model.LoadUserTable(*userFile)
+	if e != nil {
+		log.Fatal(e)
+	}
+
+	storage, e := model.StorageBackends(*sharedDir, *objectStore, *objectToken)
+	if e != nil {
+		log.Fatal(e)
+	}
+	sandbox, e := model.LoadSandboxConfig(*sandboxFile)
+	if e != nil {
+		log.Fatal(e)
+	}
+	if *executor != "" && !model.HasExecutor(*executor) {
+		log.Fatalf("Unknown executor %v, expected one of %v\n", *executor, strings.Join(model.Executors(), ", "))
+	}
+	if *workDir == "" {
+		if *workDir, e = ioutil.TempDir("", "resman"); e != nil {
+			log.Fatal(e)
+		}
+		// the user of the sandbox must reach the directories of its tasks
+		if e := os.Chmod(*workDir, 0755); e != nil {
+			log.Fatal(e)
+		}
+	}
+
+	rm := model.InitResMan(*n, *id, uuid, addrs, nodeLabels, *bind, *discosrvAddr, users, model.CheckpointConfig{WorkDir: *workDir, Store: *checkpointStore}, storage, sandbox, *executor, t)
 	rm.Run()
 }
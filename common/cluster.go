@@ -0,0 +1,53 @@
+package common
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/rpc"
+	"strings"
+)
+
+// clusterSecretHeader carries the cluster secret in the CONNECT request of an RPC connection
+const clusterSecretHeader = "Vgrid-Cluster-Secret"
+
+// clusterSecret is presented on every RPC connection that this process opens, see LoadClusterSecret
+var clusterSecret string
+
+// LoadClusterSecret reads the secret that the GSs, the RMs and the discovery server share from the file at path.
+// The internal RPCs of a node are only served on the connections that present the secret, they are served
+// on every connection if the path is empty.
+func LoadClusterSecret(path string) error {
+	if path == "" {
+		log.Println("No cluster secret, every client can call the internal RPCs")
+		return nil
+	}
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return e
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return fmt.Errorf("cluster secret file %v is empty", path)
+	}
+	clusterSecret = s
+	return nil
+}
+
+// rpcServer serves all the RPCs to the nodes of the cluster and only the public RPCs to the other clients
+type rpcServer struct {
+	internal *rpc.Server
+	public   *rpc.Server
+	secret   string // every client is a node of the cluster if it is empty
+}
+
+func (s *rpcServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	secret := req.Header.Get(clusterSecretHeader)
+	if s.secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(s.secret)) == 1 {
+		s.internal.ServeHTTP(w, req)
+		return
+	}
+	s.public.ServeHTTP(w, req)
+}
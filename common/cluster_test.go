@@ -0,0 +1,68 @@
+package common
+
+import (
+	"net/http/httptest"
+	"net/rpc"
+	"testing"
+)
+
+type echo struct{}
+
+func (e *echo) Public(x *int, reply *int) error {
+	*reply = *x
+	return nil
+}
+
+func (e *echo) Internal(x *int, reply *int) error {
+	*reply = *x
+	return nil
+}
+
+type echoAPI interface {
+	Public(x *int, reply *int) error
+}
+
+type publicEcho struct {
+	echoAPI
+}
+
+func TestRPCServerSecret(t *testing.T) {
+	defer func(s string) { clusterSecret = s }(clusterSecret)
+
+	tests := []struct {
+		server   string // the secret of the server
+		client   string // the secret that the client presents
+		method   string
+		accepted bool
+	}{
+		{"", "", "Echo.Internal", true},
+		{"", "other", "Echo.Internal", true},
+		{"s3cret", "s3cret", "Echo.Internal", true},
+		{"s3cret", "s3cret", "Echo.Public", true},
+		{"s3cret", "", "Echo.Public", true},
+		{"s3cret", "", "Echo.Internal", false},
+		{"s3cret", "wrong", "Echo.Internal", false},
+		{"s3cret", "s3cre", "Echo.Internal", false},
+	}
+	for _, test := range tests {
+		srv := &rpcServer{rpc.NewServer(), rpc.NewServer(), test.server}
+		srv.internal.RegisterName("Echo", &echo{})
+		srv.public.RegisterName("Echo", &publicEcho{&echo{}})
+		ts := httptest.NewServer(srv)
+
+		clusterSecret = test.client
+		remote, e := DialRPC(ts.Listener.Addr().String())
+		if e != nil {
+			t.Fatal(e)
+		}
+		x, reply := 7, 0
+		e = remote.Call(test.method, &x, &reply)
+		remote.Close()
+		ts.Close()
+
+		if accepted := e == nil && reply == x; accepted != test.accepted {
+			t.Errorf("server secret %q, client secret %q, %v: accepted is %v, expected %v (%v)",
+				test.server, test.client, test.method, accepted, test.accepted, e)
+		}
+	}
+}
@@ -2,10 +2,10 @@ package common
 
 //go:generate stringer -type=MsgType
 //go:generate stringer -type=MutexState
+//go:generate stringer -type=NodeType
 
 import (
 	"log"
-	"net"
 	"net/http"
 	"net/rpc"
 	"sync"
@@ -22,6 +22,7 @@ const (
 	GSUpMsg
 	RMUpMsg
 	GetCapacityMsg
+	TermMsg
 )
 
 // MutexState are all the possible states for Ricart-Agrawala algorithm
@@ -35,9 +36,12 @@ const (
 
 // Node is a generic node
 type Node struct {
-	ID   int
-	Addr string
-	Type NodeType
+	ID     int
+	Addr   string
+	Type   NodeType
+	Addrs  []string          // all the advertised addresses, Addr is the first one
+	UUID   string            // stays the same when the addresses change, see LoadIdentity
+	Labels map[string]string // set by the operator, e.g. zone=eu-west, see ParseLabels
 }
 
 // NodeType can be either for GS, RM or DS (discosrv)
@@ -160,12 +164,22 @@ func (s *SyncedSet) GetAll() map[string]IntClient {
 	return s.S
 }
 
-// RunRPC registers and runs the RPC server.
-func RunRPC(s interface{}, addr string) {
+// RunRPC registers and runs the RPC server. The RPCs of s are served to the nodes of the cluster and only the RPCs
+// of public to the other clients, both under the service name. public is usually a struct that embeds an interface
+// with the user facing methods of s, there are no public RPCs if it is nil.
+func RunRPC(name string, s interface{}, public interface{}, addr string) {
 	log.Printf("Initialising RPC on addr %v\n", addr)
-	rpc.Register(s)
-	rpc.HandleHTTP()
-	l, e := net.Listen("tcp", addr)
+	srv := &rpcServer{rpc.NewServer(), rpc.NewServer(), clusterSecret}
+	if e := srv.internal.RegisterName(name, s); e != nil {
+		log.Panic("runRPC failed", e)
+	}
+	if public != nil {
+		if e := srv.public.RegisterName(name, public); e != nil {
+			log.Panic("runRPC failed", e)
+		}
+	}
+	http.Handle(rpc.DefaultRPCPath, srv)
+	l, e := Listen(addr)
 	if e != nil {
 		log.Panic("runRPC failed", e)
 	}
@@ -187,7 +201,7 @@ func RemoteCallNoFail(remote *rpc.Client, fn string, args interface{}, reply int
 func DialAndCallNoFail(addr string, fn string, args interface{}) (int, error) {
 	// var reply interface{}
 	reply := -1
-	remote, e1 := rpc.DialHTTP("tcp", addr)
+	remote, e1 := DialRPC(addr)
 	if e1 != nil {
 		log.Printf("Node %v not online (DialHTTP)\n", addr)
 		return reply, e1
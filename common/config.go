@@ -0,0 +1,159 @@
+package common
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// EnvPrefix is the prefix of the environment variables that set flags, e.g. VGRID_DISCOSRV sets -discosrv
+const EnvPrefix = "VGRID_"
+
+// EnvName returns the environment variable for the flag name
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// LoadConfig sets the flags of fs that were not given on the command line, first from the environment
+// and then from the JSON config file at path, so flags take precedence over the environment and the environment
+// over the file. The file is an object with flag names as keys, e.g. {"addr": "localhost:3000", "id": 1},
+// a list of strings is joined with commas and an object of strings is written as labels, see ParseLabels.
+// If path is empty then VGRID_CONFIG is used, if that is empty too then there is no config file.
+func LoadConfig(fs *flag.FlagSet, path string) error {
+	if path == "" {
+		path = os.Getenv(EnvName("config"))
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	file := make(map[string]json.RawMessage)
+	if path != "" {
+		b, e := ioutil.ReadFile(path)
+		if e != nil {
+			return e
+		}
+		if e := json.Unmarshal(b, &file); e != nil {
+			return fmt.Errorf("failed to parse config file %v: %v", path, e)
+		}
+		for k := range file {
+			if fs.Lookup(k) == nil {
+				return fmt.Errorf("unknown option %v in config file %v", k, path)
+			}
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		if v, ok := os.LookupEnv(EnvName(f.Name)); ok {
+			if e := fs.Set(f.Name, v); e != nil {
+				err = fmt.Errorf("invalid value %q for %v: %v", v, EnvName(f.Name), e)
+			}
+			return
+		}
+		raw, ok := file[f.Name]
+		if !ok {
+			return
+		}
+		v, e := configValue(raw)
+		if e == nil {
+			e = fs.Set(f.Name, v)
+		}
+		if e != nil {
+			err = fmt.Errorf("invalid value %s for %v in config file %v: %v", raw, f.Name, path, e)
+		}
+	})
+	return err
+}
+
+// IsSet checks whether the flag name of fs was set on the command line, in the environment or in the config file
+func IsSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+	return set
+}
+
+// configValue converts a JSON value to the string form of a flag value
+func configValue(raw json.RawMessage) (string, error) {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, nil
+	}
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		return strings.Join(list, ","), nil
+	}
+	var labels map[string]string
+	if json.Unmarshal(raw, &labels) == nil {
+		return FormatLabels(labels), nil
+	}
+	var v interface{}
+	if e := json.Unmarshal(raw, &v); e != nil {
+		return "", e
+	}
+	switch v.(type) {
+	case float64, bool:
+		// numbers and booleans are written as they are in the file
+		return string(raw), nil
+	}
+	return "", fmt.Errorf("unsupported value")
+}
+
+// ValidateAddr checks that addr is a host:port address
+func ValidateAddr(name string, addr string) error {
+	if _, _, e := net.SplitHostPort(addr); e != nil {
+		return fmt.Errorf("invalid %v address %q: %v", name, addr, e)
+	}
+	return nil
+}
+
+// ParseAddrs splits the comma separated host:port addresses in addrs and validates every one of them
+func ParseAddrs(name string, addrs string) ([]string, error) {
+	var res []string
+	for _, addr := range strings.Split(addrs, ",") {
+		addr = strings.TrimSpace(addr)
+		if e := ValidateAddr(name, addr); e != nil {
+			return nil, e
+		}
+		res = append(res, addr)
+	}
+	return res, nil
+}
+
+// ParseLabels parses comma separated key=value labels, e.g. "zone=eu-west,gpu=true". The keys must be unique
+// and must not be empty, an empty string has no labels.
+func ParseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	if strings.TrimSpace(s) == "" {
+		return labels, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		k := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || k == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", kv)
+		}
+		if _, ok := labels[k]; ok {
+			return nil, fmt.Errorf("duplicate label %v", k)
+		}
+		labels[k] = strings.TrimSpace(parts[1])
+	}
+	return labels, nil
+}
+
+// FormatLabels returns the labels in the form of ParseLabels, sorted by key
+func FormatLabels(labels map[string]string) string {
+	var res []string
+	for k, v := range labels {
+		res = append(res, k+"="+v)
+	}
+	sort.Strings(res)
+	return strings.Join(res, ",")
+}
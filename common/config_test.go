@@ -0,0 +1,57 @@
+package common
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		s    string
+		want map[string]string
+		ok   bool
+	}{
+		{"", map[string]string{}, true},
+		{"zone=eu-west", map[string]string{"zone": "eu-west"}, true},
+		{"zone=eu-west, gpu=true", map[string]string{"zone": "eu-west", "gpu": "true"}, true},
+		{"empty=", map[string]string{"empty": ""}, true},
+		{"url=a=b", map[string]string{"url": "a=b"}, true},
+		{"zone", nil, false},
+		{"=x", nil, false},
+		{"zone=a,zone=b", nil, false},
+		{"zone=a,", nil, false},
+	}
+	for _, test := range tests {
+		labels, e := ParseLabels(test.s)
+		if (e == nil) != test.ok || (e == nil && !reflect.DeepEqual(labels, test.want)) {
+			t.Errorf("ParseLabels(%q) = %v, %v, expected %v", test.s, labels, e, test.want)
+		}
+		if e == nil {
+			if again, _ := ParseLabels(FormatLabels(labels)); !reflect.DeepEqual(again, labels) {
+				t.Errorf("FormatLabels(%v) = %q does not parse to the same labels", labels, FormatLabels(labels))
+			}
+		}
+	}
+}
+
+func TestConfigValue(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+		ok   bool
+	}{
+		{`"localhost:3000"`, "localhost:3000", true},
+		{`["a:1", "b:2"]`, "a:1,b:2", true},
+		{`3`, "3", true},
+		{`true`, "true", true},
+		{`{"zone": "eu-west", "gpu": "true"}`, "gpu=true,zone=eu-west", true},
+		{`{"gpu": true}`, "", false},
+	}
+	for _, test := range tests {
+		v, e := configValue(json.RawMessage(test.raw))
+		if (e == nil) != test.ok || v != test.want {
+			t.Errorf("configValue(%v) = %q, %v, expected %q", test.raw, v, e, test.want)
+		}
+	}
+}
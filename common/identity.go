@@ -0,0 +1,136 @@
+package common
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// probeTimeout is the longest wait for a connection when checking whether a node is reachable
+const probeTimeout = 2 * time.Second
+
+var uuidPattern = regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$")
+
+// NewUUID returns a random (version 4) UUID
+func NewUUID() string {
+	b := make([]byte, 16)
+	if _, e := io.ReadFull(rand.Reader, b); e != nil {
+		panic(e)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// LoadIdentity returns the UUID that is stored in the file at path, the file is created with a new UUID
+// if it doesn't exist. If path is empty then a new UUID is returned every time.
+func LoadIdentity(path string) (string, error) {
+	if path == "" {
+		return NewUUID(), nil
+	}
+	b, e := ioutil.ReadFile(path)
+	if os.IsNotExist(e) {
+		id := NewUUID()
+		if e := os.MkdirAll(filepath.Dir(path), 0755); e != nil {
+			return "", e
+		}
+		return id, ioutil.WriteFile(path, []byte(id+"\n"), 0644)
+	} else if e != nil {
+		return "", e
+	}
+	id := strings.TrimSpace(string(b))
+	if !uuidPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid UUID %q in identity file %v", id, path)
+	}
+	return id, nil
+}
+
+// IdentityFile returns the identity file of a node, path if it was set. Otherwise it is a file for the type and
+// the ID of the node in ~/.vgrid, so that the node keeps its UUID when it restarts. An empty path is only used
+// if it was set explicitly, the node gets a new UUID on every start then.
+func IdentityFile(path string, set bool, typ NodeType, id int) (string, error) {
+	if set {
+		return path, nil
+	}
+	home, e := os.UserHomeDir()
+	if e != nil {
+		return "", fmt.Errorf("no directory for the identity file, use -identity: %v", e)
+	}
+	prefix := "gs"
+	if typ == RMNode {
+		prefix = "rm"
+	}
+	return filepath.Join(home, ".vgrid", fmt.Sprintf("%v-%v.uuid", prefix, id)), nil
+}
+
+// DialRPC connects to the RPC server of a node like rpc.DialHTTP, it presents the cluster secret
+// and uses TLS if it was configured, see LoadTLS and ClientTLS
+func DialRPC(addr string) (*rpc.Client, error) {
+	return dialRPC(addr, 0)
+}
+
+// dialRPC is DialRPC with a timeout, 0 is no timeout
+func dialRPC(addr string, timeout time.Duration) (*rpc.Client, error) {
+	var conn net.Conn
+	var e error
+	if tlsClient != nil {
+		conn, e = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, tlsClient)
+	} else {
+		conn, e = net.DialTimeout("tcp", addr, timeout)
+	}
+	if e != nil {
+		return nil, e
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	header := ""
+	if clusterSecret != "" {
+		header = clusterSecretHeader + ": " + clusterSecret + "\n"
+	}
+	io.WriteString(conn, "CONNECT "+rpc.DefaultRPCPath+" HTTP/1.0\n"+header+"\n")
+	resp, e := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if e == nil && resp.Status != "200 Connected to Go RPC" {
+		e = errors.New("unexpected HTTP response: " + resp.Status)
+	}
+	if e != nil {
+		conn.Close()
+		return nil, e
+	}
+	conn.SetDeadline(time.Time{})
+	return rpc.NewClient(conn), nil
+}
+
+// GetIdentity asks the node of type t on the first reachable address in addrs who it is
+func GetIdentity(addrs []string, t NodeType) (Node, error) {
+	fn := "GridSdr.GetIdentity"
+	if t == RMNode {
+		fn = "ResMan.GetIdentity"
+	}
+	var node Node
+	for _, addr := range addrs {
+		remote, e := dialRPC(addr, probeTimeout)
+		if e != nil {
+			continue
+		}
+		x := 0
+		e = remote.Call(fn, &x, &node)
+		remote.Close()
+		if e == nil {
+			return node, nil
+		}
+	}
+	return node, errors.New("None of the addresses " + strings.Join(addrs, ",") + " are reachable")
+}
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIdentityFile(t *testing.T) {
+	home, e := ioutil.TempDir("", "home")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	tests := []struct {
+		args []string // the command line
+		typ  NodeType
+		id   int
+		want string
+	}{
+		{nil, GSNode, 3, filepath.Join(home, ".vgrid", "gs-3.uuid")},
+		{nil, RMNode, 10, filepath.Join(home, ".vgrid", "rm-10.uuid")},
+		{[]string{"-identity", "/var/lib/vgrid/id"}, GSNode, 3, "/var/lib/vgrid/id"},
+		// an empty file is an explicit opt-out
+		{[]string{"-identity", ""}, RMNode, 10, ""},
+	}
+	for _, test := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		identity := fs.String("identity", "", "")
+		if e := fs.Parse(test.args); e != nil {
+			t.Fatal(e)
+		}
+		got, e := IdentityFile(*identity, IsSet(fs, "identity"), test.typ, test.id)
+		if e != nil || got != test.want {
+			t.Errorf("%v: identity file is %q, %v, expected %q", test.args, got, e, test.want)
+		}
+	}
+}
+
+func TestLoadIdentity(t *testing.T) {
+	dir, e := ioutil.TempDir("", "identity")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	// the UUID is kept across restarts
+	path := filepath.Join(dir, ".vgrid", "gs-1.uuid")
+	first, e := LoadIdentity(path)
+	if e != nil || !uuidPattern.MatchString(first) {
+		t.Fatalf("first start: %q, %v", first, e)
+	}
+	if again, e := LoadIdentity(path); e != nil || again != first {
+		t.Errorf("restart: %q, %v, expected %q", again, e, first)
+	}
+	// without a file every start gets a new UUID
+	if a, b := mustIdentity(t, ""), mustIdentity(t, ""); a == b {
+		t.Errorf("the same UUID %v was returned twice without a file", a)
+	}
+	invalid := filepath.Join(dir, "invalid")
+	ioutil.WriteFile(invalid, []byte("not a uuid\n"), 0644)
+	if _, e := LoadIdentity(invalid); e == nil {
+		t.Error("an invalid identity file was accepted")
+	}
+}
+
+func mustIdentity(t *testing.T, path string) string {
+	id, e := LoadIdentity(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return id
+}
@@ -0,0 +1,180 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resolveAttempts is how many times the advertised addresses of a node are probed, once per second
+const resolveAttempts = 30
+
+// Member is one node in a Membership
+type Member struct {
+	ID     int
+	UUID   string
+	Addr   string            // the address that is used to reach the node
+	Addrs  []string          // all the advertised addresses of the node
+	Seen   time.Time         // the last time the node joined
+	Labels map[string]string // the labels of the node, see Node
+}
+
+// Membership is a concurrent set of nodes keyed by their UUID,
+// a node that comes back with other addresses replaces its old entry instead of being added twice.
+type Membership struct {
+	sync.RWMutex
+	m map[string]*Member
+}
+
+// NewMembership creates an empty membership
+func NewMembership() *Membership {
+	return &Membership{m: make(map[string]*Member)}
+}
+
+// Join adds or updates node. If addr is empty and the node advertises more than one address then the first
+// address that accepts connections is used, it is probed in the background because the node may not be listening yet.
+func (ms *Membership) Join(node Node, addr string) {
+	addrs := node.Addrs
+	if len(addrs) == 0 {
+		addrs = []string{node.Addr}
+	}
+	resolve := addr == "" && len(addrs) > 1
+	if addr == "" {
+		addr = addrs[0]
+	}
+
+	ms.Lock()
+	defer ms.Unlock()
+	if old, ok := ms.m[node.UUID]; ok && old.ID == node.ID && sameAddrs(old.Addrs, addrs) {
+		// keep the address that was resolved before
+		old.Seen, old.Labels = time.Now(), node.Labels
+		return
+	} else if ok {
+		log.Printf("Node %v moved from %v to %v\n", node.UUID, old.Addrs, addrs)
+	}
+	ms.m[node.UUID] = &Member{node.ID, node.UUID, addr, addrs, time.Now(), node.Labels}
+	if resolve {
+		go ms.resolve(node.UUID, addrs)
+	}
+}
+
+// CheckJoin returns an error if node may not join because another node that is still online has the same UUID
+// or the same ID. Members that conflict with node but are offline are removed.
+func (ms *Membership) CheckJoin(node Node) error {
+	if node.UUID == "" {
+		return fmt.Errorf("%v %v has no UUID", node.Type, node.Addr)
+	}
+	for _, m := range ms.Members() {
+		if m.UUID == node.UUID && (m.ID != node.ID || !sameAddrs(m.Addrs, node.Addrs)) {
+			if other, e := GetIdentity(m.Addrs, node.Type); e == nil && other.UUID == m.UUID {
+				return fmt.Errorf("%v %v on %v has the same UUID as %v", node.Type, node.UUID, node.Addrs, m.Addrs)
+			}
+			ms.Leave(m.UUID)
+		} else if m.UUID != node.UUID && m.ID == node.ID {
+			if other, e := GetIdentity(m.Addrs, node.Type); e == nil && other.UUID == m.UUID {
+				return fmt.Errorf("%v %v on %v has the same ID %v as %v on %v",
+					node.Type, node.UUID, node.Addrs, node.ID, m.UUID, m.Addrs)
+			}
+			ms.Leave(m.UUID)
+		}
+	}
+	return nil
+}
+
+// resolve probes the addresses of node uuid and uses the first one that works
+func (ms *Membership) resolve(uuid string, addrs []string) {
+	for i := 0; i < resolveAttempts; i++ {
+		addr, e := PickAddr(addrs)
+		if e == nil {
+			ms.Lock()
+			if m, ok := ms.m[uuid]; ok && sameAddrs(m.Addrs, addrs) {
+				m.Addr = addr
+			}
+			ms.Unlock()
+			return
+		}
+		time.Sleep(time.Second)
+	}
+	log.Printf("None of the addresses %v of node %v are reachable\n", addrs, uuid)
+}
+
+func sameAddrs(a []string, b []string) bool {
+	return strings.Join(a, ",") == strings.Join(b, ",")
+}
+
+// Addr returns the address that is used for node uuid
+func (ms *Membership) Addr(uuid string) (string, bool) {
+	ms.RLock()
+	defer ms.RUnlock()
+	m, ok := ms.m[uuid]
+	if !ok {
+		return "", false
+	}
+	return m.Addr, true
+}
+
+// GetAll returns the address of every node mapped to its ID
+func (ms *Membership) GetAll() map[string]IntClient {
+	ms.RLock()
+	defer ms.RUnlock()
+	res := make(map[string]IntClient)
+	for _, m := range ms.m {
+		res[m.Addr] = IntClient{ID: int64(m.ID)}
+	}
+	return res
+}
+
+// Members returns a copy of all the members ordered by ID
+func (ms *Membership) Members() []Member {
+	ms.RLock()
+	defer ms.RUnlock()
+	res := make([]Member, 0, len(ms.m))
+	for _, m := range ms.m {
+		res = append(res, *m)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+// Nodes returns all the members as nodes of type t
+func (ms *Membership) Nodes(t NodeType) []Node {
+	var res []Node
+	for _, m := range ms.Members() {
+		res = append(res, Node{ID: m.ID, Addr: m.Addr, Type: t, Addrs: m.Addrs, UUID: m.UUID, Labels: m.Labels})
+	}
+	return res
+}
+
+// Leave removes node uuid
+func (ms *Membership) Leave(uuid string) {
+	ms.Lock()
+	defer ms.Unlock()
+	delete(ms.m, uuid)
+}
+
+// RemoveOlder removes the members that were last seen before t
+func (ms *Membership) RemoveOlder(t time.Time) {
+	ms.Lock()
+	defer ms.Unlock()
+	for uuid, m := range ms.m {
+		if m.Seen.Before(t) {
+			delete(ms.m, uuid)
+		}
+	}
+}
+
+// PickAddr returns the first address in addrs that accepts RPC connections
+func PickAddr(addrs []string) (string, error) {
+	for _, addr := range addrs {
+		remote, e := dialRPC(addr, probeTimeout)
+		if e == nil {
+			remote.Close()
+			return addr, nil
+		}
+	}
+	return "", errors.New("None of the addresses " + strings.Join(addrs, ",") + " are reachable")
+}
@@ -0,0 +1,209 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// Timings are the intervals and timeouts of the protocols, all the nodes of a grid should use the same values
+type Timings struct {
+	Heartbeat     time.Duration // between "I'm alive" messages to the discovery server
+	DeadThreshold time.Duration // the discovery server removes nodes that are silent for this long
+	Poll          time.Duration // how often the leader and the RMs are checked
+	CSTimeout     time.Duration // the wait for the critical section responses, the adaptive timeout is never shorter
+	MaxCSTimeout  time.Duration // the longest adaptive critical section timeout
+	AdaptiveCS    bool          // wait longer than CSTimeout if the observed response times are longer
+	Tick          time.Duration // the period of the scheduling, task and completion loops
+	ElectionSleep time.Duration // the minimum duration of an election
+	Lease         time.Duration // the leader may schedule for this long after a majority of the GSs renewed its lease
+}
+
+// timingsFile is the on-disk format of the timings, the durations are strings like "10s", missing fields keep their defaults
+type timingsFile struct {
+	Heartbeat     string `json:"heartbeat"`
+	DeadThreshold string `json:"dead_threshold"`
+	Poll          string `json:"poll"`
+	CSTimeout     string `json:"cs_timeout"`
+	MaxCSTimeout  string `json:"max_cs_timeout"`
+	AdaptiveCS    *bool  `json:"adaptive_cs"`
+	Tick          string `json:"tick"`
+	ElectionSleep string `json:"election_sleep"`
+	Lease         string `json:"lease"`
+}
+
+// DefaultTimings returns the timings that are used when nothing is configured
+func DefaultTimings() Timings {
+	return Timings{
+		Heartbeat:     10 * time.Second,
+		DeadThreshold: 20 * time.Second,
+		Poll:          time.Second,
+		CSTimeout:     5 * time.Second,
+		MaxCSTimeout:  30 * time.Second,
+		AdaptiveCS:    false,
+		Tick:          100 * time.Millisecond,
+		ElectionSleep: time.Second,
+		Lease:         5 * time.Second,
+	}
+}
+
+// Validate checks that the timings are usable
+func (t Timings) Validate() error {
+	for name, d := range map[string]time.Duration{
+		"heartbeat": t.Heartbeat, "poll": t.Poll, "cs_timeout": t.CSTimeout,
+		"max_cs_timeout": t.MaxCSTimeout, "tick": t.Tick, "election_sleep": t.ElectionSleep, "lease": t.Lease,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("%v must be positive, got %v", name, d)
+		}
+	}
+	if t.DeadThreshold < time.Second {
+		return fmt.Errorf("dead_threshold must be at least 1s, got %v", t.DeadThreshold)
+	}
+	if t.DeadThreshold <= t.Heartbeat {
+		return errors.New("dead_threshold must be longer than heartbeat")
+	}
+	if t.MaxCSTimeout < t.CSTimeout {
+		return errors.New("max_cs_timeout must not be shorter than cs_timeout")
+	}
+	if t.Lease <= t.Poll {
+		return errors.New("lease must be longer than poll because the lease is renewed every poll")
+	}
+	return nil
+}
+
+// LoadTimings reads the JSON timings file at path on top of t
+func (t *Timings) LoadTimings(path string) error {
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return e
+	}
+	var f timingsFile
+	if e := json.Unmarshal(b, &f); e != nil {
+		return fmt.Errorf("failed to parse timings file %v: %v", path, e)
+	}
+	for _, v := range []struct {
+		s string
+		d *time.Duration
+	}{
+		{f.Heartbeat, &t.Heartbeat}, {f.DeadThreshold, &t.DeadThreshold}, {f.Poll, &t.Poll},
+		{f.CSTimeout, &t.CSTimeout}, {f.MaxCSTimeout, &t.MaxCSTimeout}, {f.Tick, &t.Tick},
+		{f.ElectionSleep, &t.ElectionSleep}, {f.Lease, &t.Lease},
+	} {
+		if v.s == "" {
+			continue
+		}
+		d, e := time.ParseDuration(v.s)
+		if e != nil {
+			return fmt.Errorf("invalid duration in timings file %v: %v", path, e)
+		}
+		*v.d = d
+	}
+	if f.AdaptiveCS != nil {
+		t.AdaptiveCS = *f.AdaptiveCS
+	}
+	return nil
+}
+
+// TimingFlags registers a flag for every timing and a -timings flag for the timings file on fs.
+// The returned function must be called after parsing, it starts from the defaults, then applies the file
+// and then the flags that were set, so flags take precedence over the file.
+func TimingFlags(fs *flag.FlagSet) func() (Timings, error) {
+	def := DefaultTimings()
+	path := fs.String("timings", "", "JSON file with the timings, e.g. {\"heartbeat\": \"5s\"}, flags take precedence")
+	var flags Timings
+	fs.DurationVar(&flags.Heartbeat, "heartbeat", def.Heartbeat, "interval of the \"I'm alive\" messages to the discovery server")
+	fs.DurationVar(&flags.DeadThreshold, "dead-threshold", def.DeadThreshold, "the discovery server removes nodes that are silent for this long")
+	fs.DurationVar(&flags.Poll, "poll", def.Poll, "interval for checking the leader and the RMs")
+	fs.DurationVar(&flags.CSTimeout, "cs-timeout", def.CSTimeout, "the wait for the critical section responses, -adaptive-cs never waits shorter")
+	fs.DurationVar(&flags.MaxCSTimeout, "max-cs-timeout", def.MaxCSTimeout, "the longest critical section timeout with -adaptive-cs")
+	fs.BoolVar(&flags.AdaptiveCS, "adaptive-cs", def.AdaptiveCS, "wait longer than -cs-timeout if the observed response times are longer")
+	fs.DurationVar(&flags.Tick, "tick", def.Tick, "period of the scheduling, task and completion loops")
+	fs.DurationVar(&flags.ElectionSleep, "election-sleep", def.ElectionSleep, "the minimum duration of an election")
+	fs.DurationVar(&flags.Lease, "lease", def.Lease, "the leader may schedule for this long after a majority of the GSs renewed its lease")
+
+	return func() (Timings, error) {
+		t := def
+		if *path != "" {
+			if e := t.LoadTimings(*path); e != nil {
+				return t, e
+			}
+		}
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "heartbeat":
+				t.Heartbeat = flags.Heartbeat
+			case "dead-threshold":
+				t.DeadThreshold = flags.DeadThreshold
+			case "poll":
+				t.Poll = flags.Poll
+			case "cs-timeout":
+				t.CSTimeout = flags.CSTimeout
+			case "max-cs-timeout":
+				t.MaxCSTimeout = flags.MaxCSTimeout
+			case "adaptive-cs":
+				t.AdaptiveCS = flags.AdaptiveCS
+			case "tick":
+				t.Tick = flags.Tick
+			case "election-sleep":
+				t.ElectionSleep = flags.ElectionSleep
+			case "lease":
+				t.Lease = flags.Lease
+			}
+		})
+		return t, t.Validate()
+	}
+}
+
+// RTTEstimator computes a timeout from observed response times in the same way as TCP (RFC 6298),
+// the timeout is kept between min and max. Unlike TCP it starts at min, so min is the timeout that is
+// safe without any samples and the samples can only make it longer.
+type RTTEstimator struct {
+	sync.Mutex
+	srtt   time.Duration // smoothed response time, 0 until the first sample
+	rttvar time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+// NewRTTEstimator creates an estimator, the timeout is min until the first sample
+func NewRTTEstimator(min time.Duration, max time.Duration) *RTTEstimator {
+	return &RTTEstimator{min: min, max: max}
+}
+
+// Observe adds a response time sample
+func (r *RTTEstimator) Observe(d time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	if r.srtt == 0 {
+		r.srtt, r.rttvar = d, d/2
+		return
+	}
+	diff := r.srtt - d
+	if diff < 0 {
+		diff = -diff
+	}
+	r.rttvar = (3*r.rttvar + diff) / 4
+	r.srtt = (7*r.srtt + d) / 8
+}
+
+// Timeout returns the current timeout
+func (r *RTTEstimator) Timeout() time.Duration {
+	r.Lock()
+	defer r.Unlock()
+	if r.srtt == 0 {
+		return r.min
+	}
+	t := r.srtt + 4*r.rttvar
+	if t < r.min {
+		return r.min
+	}
+	if t > r.max {
+		return r.max
+	}
+	return t
+}
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTTEstimator(t *testing.T) {
+	tests := []struct {
+		samples []time.Duration
+		want    time.Duration
+	}{
+		{nil, time.Second},
+		{[]time.Duration{2 * time.Second}, 6 * time.Second},
+		{[]time.Duration{2 * time.Second, 2 * time.Second}, 5 * time.Second},
+		// never shorter than min
+		{[]time.Duration{100 * time.Millisecond}, time.Second},
+		{append([]time.Duration{2 * time.Second}, repeat(100*time.Millisecond, 50)...), time.Second},
+		// never longer than max
+		{[]time.Duration{10 * time.Second}, 10 * time.Second},
+	}
+	for _, test := range tests {
+		r := NewRTTEstimator(time.Second, 10*time.Second)
+		for _, d := range test.samples {
+			r.Observe(d)
+		}
+		if got := r.Timeout(); got != test.want {
+			t.Errorf("samples %v: timeout is %v, expected %v", test.samples, got, test.want)
+		}
+	}
+}
+
+func TestTimingsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Timings)
+		valid  bool
+	}{
+		{"default", func(t *Timings) {}, true},
+		{"zero poll", func(t *Timings) { t.Poll = 0 }, false},
+		{"negative tick", func(t *Timings) { t.Tick = -time.Second }, false},
+		{"short dead threshold", func(t *Timings) { t.DeadThreshold = 500 * time.Millisecond }, false},
+		{"dead threshold not after heartbeat", func(t *Timings) { t.DeadThreshold = t.Heartbeat }, false},
+		{"max cs timeout below cs timeout", func(t *Timings) { t.MaxCSTimeout = t.CSTimeout - 1 }, false},
+		{"max cs timeout equal to cs timeout", func(t *Timings) { t.MaxCSTimeout = t.CSTimeout }, true},
+		{"lease not after poll", func(t *Timings) { t.Lease = t.Poll }, false},
+	}
+	for _, test := range tests {
+		timings := DefaultTimings()
+		test.change(&timings)
+		if e := timings.Validate(); (e == nil) != test.valid {
+			t.Errorf("%v: Validate returned %v, expected valid to be %v", test.name, e, test.valid)
+		}
+	}
+}
+
+func repeat(d time.Duration, n int) []time.Duration {
+	res := make([]time.Duration, n)
+	for i := range res {
+		res[i] = d
+	}
+	return res
+}
@@ -0,0 +1,70 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+)
+
+// tlsServer is the TLS configuration of the listeners of this process and tlsClient of the connections
+// that it opens, see LoadTLS and ClientTLS. They are nil without TLS.
+var tlsServer, tlsClient *tls.Config
+
+// LoadTLS makes the listeners of this process serve TLS with the certificate and key in the files cert and key,
+// and the RPC connections that it opens use TLS too. The certificates of the other nodes are verified with
+// the CA certificates in the file ca, or with the system roots if it is empty. All the nodes of a grid must
+// use TLS if one does, it is disabled if cert, key and ca are empty.
+func LoadTLS(cert string, key string, ca string) error {
+	if cert == "" && key == "" && ca == "" {
+		return nil
+	}
+	if cert == "" || key == "" {
+		return errors.New("TLS needs both a certificate and a key")
+	}
+	pair, e := tls.LoadX509KeyPair(cert, key)
+	if e != nil {
+		return fmt.Errorf("failed to load the TLS certificate %v: %v", cert, e)
+	}
+	if e := ClientTLS(ca); e != nil {
+		return e
+	}
+	tlsServer = &tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS12}
+	log.Printf("Serving TLS with the certificate %v\n", cert)
+	return nil
+}
+
+// ClientTLS makes the RPC connections of this process use TLS, the certificates of the servers are verified
+// with the CA certificates in the file ca, or with the system roots if it is empty
+func ClientTLS(ca string) error {
+	c := &tls.Config{MinVersion: tls.VersionTLS12}
+	if ca != "" {
+		b, e := ioutil.ReadFile(ca)
+		if e != nil {
+			return e
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no PEM certificates in the CA file %v", ca)
+		}
+	}
+	tlsClient = c
+	return nil
+}
+
+// TLSClientConfig returns the TLS configuration of the connections that this process opens, nil without TLS
+func TLSClientConfig() *tls.Config {
+	return tlsClient
+}
+
+// Listen listens on the TCP address addr, the connections use TLS if it was loaded with LoadTLS
+func Listen(addr string) (net.Listener, error) {
+	l, e := net.Listen("tcp", addr)
+	if e != nil || tlsServer == nil {
+		return l, e
+	}
+	return tls.NewListener(l, tlsServer), nil
+}
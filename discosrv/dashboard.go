@@ -0,0 +1,198 @@
+package discosrv
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+import "github.com/kc1212/virtual-grid/common"
+
+const (
+	dashboardPoll    = 2 * time.Second // how often the GSs are asked for their status
+	dashboardSamples = 300             // number of queue depth samples that are kept, 10 minutes
+)
+
+// GSStatus is the state of the grid as seen by one GS, the discovery server shows it on the dashboard
+type GSStatus struct {
+	Addr    string
+	Leader  string
+	Queued  int // jobs waiting in incomingJobs
+	Running int // jobs in scheduledJobs
+	RMs     []RMStatus
+}
+
+// RMStatus is the state of one RM as seen by a GS
+type RMStatus struct {
+	Addr     string
+	Capacity int // number of free workers, -1 if offline
+	Running  int // jobs scheduled on this RM
+}
+
+// queueSample is the number of queued and running jobs at one point in time
+type queueSample struct {
+	Time    time.Time `json:"time"`
+	Queued  int       `json:"queued"`
+	Running int       `json:"running"`
+}
+
+type gsView struct {
+	Addr         string `json:"addr"`
+	HeartbeatAge int64  `json:"heartbeat_age"` // seconds since the last "I'm alive" message
+	Leader       bool   `json:"leader"`
+}
+
+type rmView struct {
+	Addr         string `json:"addr"`
+	HeartbeatAge int64  `json:"heartbeat_age"` // -1 if the RM is not known by the discovery server
+	Capacity     int    `json:"capacity"`      // -1 if unknown or offline
+	Running      int    `json:"running"`
+}
+
+// dashboardStatus is the reply of /api/status
+type dashboardStatus struct {
+	Time   time.Time     `json:"time"`
+	Leader string        `json:"leader"`
+	Source string        `json:"source"` // the GS that reported the status
+	Error  string        `json:"error,omitempty"`
+	Dead   float64       `json:"dead_after"` // seconds without a heartbeat after which a node is dead, see DeadThreshold
+	GSs    []gsView      `json:"gss"`
+	RMs    []rmView      `json:"rms"`
+	Queue  []queueSample `json:"queue"`
+}
+
+// dashboard keeps the last status that is reported by the GSs and the queue depth history
+type dashboard struct {
+	sync.Mutex
+	status  GSStatus
+	err     string
+	samples []queueSample
+}
+
+// pollGSs asks a GS for its status periodically, the leader is preferred because it has the latest view
+func (ds *Srv) pollGSs() {
+	for {
+		st, e := ds.fetchStatus()
+		ds.dash.Lock()
+		if e != nil {
+			ds.dash.err = e.Error()
+		} else {
+			ds.dash.status, ds.dash.err = st, ""
+			ds.dash.samples = append(ds.dash.samples, queueSample{time.Now(), st.Queued, st.Running})
+			if len(ds.dash.samples) > dashboardSamples {
+				ds.dash.samples = ds.dash.samples[1:]
+			}
+		}
+		ds.dash.Unlock()
+		time.Sleep(dashboardPoll)
+	}
+}
+
+func (ds *Srv) fetchStatus() (GSStatus, error) {
+	var addrs []string
+	for _, m := range ds.gsSet.Members() {
+		addrs = append(addrs, m.Addrs...)
+	}
+	for _, addr := range addrs {
+		st, e := getGSStatus(addr)
+		if e != nil {
+			continue
+		}
+		if st.Leader != "" && st.Leader != addr {
+			if ls, e := getGSStatus(st.Leader); e == nil {
+				return ls, nil
+			}
+		}
+		return st, nil
+	}
+	return GSStatus{}, errors.New("No GS is online")
+}
+
+func getGSStatus(addr string) (GSStatus, error) {
+	reply := GSStatus{}
+	remote, e := common.DialRPC(addr)
+	if e != nil {
+		return reply, e
+	}
+	defer remote.Close()
+	x := 0
+	e = common.RemoteCallNoFail(remote, "GridSdr.GetStatus", &x, &reply)
+	return reply, e
+}
+
+// heartbeats returns the age in seconds of the last "I'm alive" message of every node in set,
+// the ages are keyed by the main address and aliases maps every advertised address to the main address
+func heartbeats(set *common.Membership, now time.Time) (ages map[string]int64, aliases map[string]string) {
+	ages = make(map[string]int64)
+	aliases = make(map[string]string)
+	for _, m := range set.Members() {
+		ages[m.Addr] = int64(now.Sub(m.Seen) / time.Second)
+		for _, addr := range m.Addrs {
+			aliases[addr] = m.Addr
+		}
+	}
+	return
+}
+
+func (ds *Srv) getDashboardStatus() dashboardStatus {
+	now := time.Now()
+	gsAges, gsAliases := heartbeats(ds.gsSet, now)
+	rmAges, rmAliases := heartbeats(ds.rmSet, now)
+
+	ds.dash.Lock()
+	defer ds.dash.Unlock()
+	st := ds.dash.status
+	res := dashboardStatus{
+		Time:   now,
+		Leader: st.Leader,
+		Source: st.Addr,
+		Error:  ds.dash.err,
+		Dead:   ds.timings.DeadThreshold.Seconds(),
+		GSs:    []gsView{},
+		RMs:    []rmView{},
+		Queue:  append([]queueSample{}, ds.dash.samples...),
+	}
+
+	for addr, age := range gsAges {
+		res.GSs = append(res.GSs, gsView{addr, age, addr == gsAliases[st.Leader]})
+	}
+	// the RMs that the GS knows about may include some that stopped sending heartbeats
+	seen := make(map[string]bool)
+	for _, rm := range st.RMs {
+		age, ok := rmAges[rmAliases[rm.Addr]]
+		if !ok {
+			age = -1
+		}
+		res.RMs = append(res.RMs, rmView{rm.Addr, age, rm.Capacity, rm.Running})
+		seen[rmAliases[rm.Addr]] = true
+	}
+	for addr, age := range rmAges {
+		if !seen[addr] {
+			res.RMs = append(res.RMs, rmView{addr, age, -1, 0})
+		}
+	}
+	sort.Slice(res.GSs, func(i, j int) bool { return res.GSs[i].Addr < res.GSs[j].Addr })
+	sort.Slice(res.RMs, func(i, j int) bool { return res.RMs[i].Addr < res.RMs[j].Addr })
+	return res
+}
+
+func (ds *Srv) serveDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, dashboardHTML)
+}
+
+func (ds *Srv) serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if e := json.NewEncoder(w).Encode(ds.getDashboardStatus()); e != nil {
+		log.Printf("Failed to write status, %v\n", e)
+	}
+}
@@ -0,0 +1,109 @@
+package discosrv
+
+// dashboardHTML is the dashboard page, it is self-contained and polls /api/status
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>virtual-grid</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin-top: 1.5em; }
+table { border-collapse: collapse; min-width: 30em; }
+th, td { text-align: left; padding: 0.3em 1em 0.3em 0; border-bottom: 1px solid #ddd; }
+.stale { color: #b00; }
+.error { color: #b00; }
+svg { border: 1px solid #ddd; }
+.queued { stroke: #1f77b4; fill: none; stroke-width: 2; }
+.running { stroke: #2ca02c; fill: none; stroke-width: 2; }
+</style>
+</head>
+<body>
+<h1>virtual-grid</h1>
+<p>Leader: <b id="leader">-</b>, reported by <span id="source">-</span> at <span id="time">-</span>
+<span id="error" class="error"></span></p>
+
+<h2>Grid Schedulers</h2>
+<table><thead><tr><th>Address</th><th>Heartbeat</th><th>Leader</th></tr></thead><tbody id="gss"></tbody></table>
+
+<h2>Resource Managers</h2>
+<table><thead><tr><th>Address</th><th>Heartbeat</th><th>Free workers</th><th>Running jobs</th></tr></thead><tbody id="rms"></tbody></table>
+
+<h2>Queue depth</h2>
+<p><span style="color:#1f77b4">queued</span>, <span style="color:#2ca02c">running</span>, max <span id="max">0</span></p>
+<svg id="chart" width="600" height="200"></svg>
+
+<script>
+function cell(row, text, cls) {
+  var td = document.createElement("td");
+  td.textContent = text;
+  if (cls) td.className = cls;
+  row.appendChild(td);
+}
+
+function age(secs) {
+  return secs < 0 ? "unknown" : secs + "s ago";
+}
+
+function fill(id, items, fn) {
+  var body = document.getElementById(id);
+  body.innerHTML = "";
+  items.forEach(function(item) {
+    var row = document.createElement("tr");
+    fn(row, item);
+    body.appendChild(row);
+  });
+}
+
+function line(samples, key, max, w, h) {
+  if (samples.length < 2) return "";
+  var t0 = Date.parse(samples[0].time), t1 = Date.parse(samples[samples.length - 1].time);
+  return samples.map(function(s, i) {
+    var x = (Date.parse(s.time) - t0) / Math.max(t1 - t0, 1) * (w - 10) + 5;
+    var y = h - 5 - s[key] / max * (h - 10);
+    return (i == 0 ? "M" : "L") + x.toFixed(1) + "," + y.toFixed(1);
+  }).join(" ");
+}
+
+function chart(samples) {
+  var svg = document.getElementById("chart");
+  var w = svg.width.baseVal.value, h = svg.height.baseVal.value;
+  var max = 1;
+  samples.forEach(function(s) { max = Math.max(max, s.queued, s.running); });
+  document.getElementById("max").textContent = max;
+  svg.innerHTML = '<path class="queued" d="' + line(samples, "queued", max, w, h) + '"/>' +
+    '<path class="running" d="' + line(samples, "running", max, w, h) + '"/>';
+}
+
+function render(st) {
+  document.getElementById("leader").textContent = st.leader || "-";
+  document.getElementById("source").textContent = st.source || "-";
+  document.getElementById("time").textContent = new Date(st.time).toLocaleTimeString();
+  document.getElementById("error").textContent = st.error || "";
+  fill("gss", st.gss, function(row, gs) {
+    cell(row, gs.addr);
+    cell(row, age(gs.heartbeat_age), gs.heartbeat_age > st.dead_after ? "stale" : "");
+    cell(row, gs.leader ? "*" : "");
+  });
+  fill("rms", st.rms, function(row, rm) {
+    cell(row, rm.addr);
+    cell(row, age(rm.heartbeat_age), rm.heartbeat_age < 0 || rm.heartbeat_age > st.dead_after ? "stale" : "");
+    cell(row, rm.capacity < 0 ? "offline" : rm.capacity, rm.capacity < 0 ? "stale" : "");
+    cell(row, rm.running);
+  });
+  chart(st.queue);
+}
+
+function refresh() {
+  fetch("/api/status").then(function(r) { return r.json(); }).then(render).catch(function(e) {
+    document.getElementById("error").textContent = "discovery server unreachable: " + e;
+  });
+}
+
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body>
+</html>
+`
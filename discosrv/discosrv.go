@@ -6,7 +6,6 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"net/rpc"
 	"time"
 )
 
@@ -14,8 +13,10 @@ import "github.com/kc1212/virtual-grid/common"
 
 // Srv represents the discovery server
 type Srv struct {
-	gsSet *common.SyncedSet
-	rmSet *common.SyncedSet
+	gsSet   *common.Membership
+	rmSet   *common.Membership
+	dash    dashboard
+	timings common.Timings
 }
 
 // Args is for RPC argument
@@ -23,46 +24,76 @@ type Args struct {
 	Addr     string
 	Type     common.NodeType
 	NeedList bool
+	ID       int
+	Addrs    []string // all the advertised addresses, Addr is the first one
+	UUID     string
+	Labels   map[string]string
 }
 
-// Reply is for RPC responses
+// Reply is for RPC responses, GSs and RMs are the main addresses of GSNodes and RMNodes
 type Reply struct {
-	GSs   []string
-	RMs   []string
-	Reply int
+	GSs     []string
+	RMs     []string
+	Reply   int
+	GSNodes []common.Node
+	RMNodes []common.Node
+}
+
+// InitSrv creates a discovery server
+func InitSrv(timings common.Timings) Srv {
+	return Srv{timings: timings}
 }
 
 // Run runs the DiscoSrv
 func (ds *Srv) Run(addr string) {
-	ds.gsSet = &common.SyncedSet{S: make(map[string]common.IntClient)}
-	ds.rmSet = &common.SyncedSet{S: make(map[string]common.IntClient)}
-	go common.RunRPC(ds, addr)
+	ds.gsSet = common.NewMembership()
+	ds.rmSet = common.NewMembership()
+	// only the nodes of the cluster may call the discovery server
+	go common.RunRPC("Srv", ds, nil, addr)
 	go ds.runRemoveDead()
-	http.HandleFunc("/", ds.hello)
-	http.ListenAndServe(":8333", nil)
+	go ds.pollGSs()
+	http.HandleFunc("/", ds.serveDashboard)
+	http.HandleFunc("/api/status", ds.serveStatus)
+	http.HandleFunc("/hello", ds.hello)
+	// the dashboard uses TLS like the RPCs
+	l, e := common.Listen(":8333")
+	if e != nil {
+		log.Panic("Failed to serve the dashboard", e)
+	}
+	http.Serve(l, nil)
 }
 
 // ImAlive RPC, called by GS or RM to update their status
 func (ds *Srv) ImAlive(args *Args, reply *Reply) error {
-	now := time.Now().Unix()
+	node := common.Node{ID: args.ID, Addr: args.Addr, Type: args.Type, Addrs: args.Addrs, UUID: args.UUID, Labels: args.Labels}
+	if len(node.Addrs) == 0 {
+		node.Addrs = []string{args.Addr}
+	}
 	reply.Reply = 0
+	var set *common.Membership
 	if args.Type == common.GSNode {
-		ds.gsSet.SetInt(args.Addr, now)
+		set = ds.gsSet
 	} else if args.Type == common.RMNode {
-		ds.rmSet.SetInt(args.Addr, now)
+		set = ds.rmSet
 	} else {
 		reply.Reply = 1
 		return errors.New("Invalid NodeType!")
 	}
-
+	// NeedList is only set when the node comes online
 	if args.NeedList {
-		ds.gsSet.RLock()
-		reply.GSs = common.SliceFromMap(ds.gsSet.S)
-		ds.gsSet.RUnlock()
+		if e := set.CheckJoin(node); e != nil {
+			reply.Reply = 1
+			log.Printf("Rejected %v\n", e)
+			return e
+		}
+	}
+	set.Join(node, node.Addrs[0])
 
-		ds.rmSet.RLock()
-		reply.RMs = common.SliceFromMap(ds.rmSet.S)
-		ds.rmSet.RUnlock()
+	if args.NeedList {
+		reply.GSNodes = ds.gsSet.Nodes(common.GSNode)
+		reply.GSs = common.SliceFromMap(ds.gsSet.GetAll())
+		reply.RMNodes = ds.rmSet.Nodes(common.RMNode)
+		reply.RMs = common.SliceFromMap(ds.rmSet.GetAll())
 	}
 	return nil
 }
@@ -70,8 +101,8 @@ func (ds *Srv) ImAlive(args *Args, reply *Reply) error {
 // TODO some repeated code in "ImAliveProbe" and "ImAlivePoll"
 
 // ImAliveProbe sends a probe message to discosrv, discosrv should return a list of RMs and GSs.
-func ImAliveProbe(nodeAddr string, nodeType common.NodeType, dsAddr string) (Reply, error) {
-	remote, e := rpc.DialHTTP("tcp", dsAddr)
+func ImAliveProbe(node common.Node, dsAddr string) (Reply, error) {
+	remote, e := common.DialRPC(dsAddr)
 	reply := Reply{}
 	if e != nil {
 		log.Printf("Node %v not online (DialHTTP)\n", dsAddr)
@@ -80,16 +111,20 @@ func ImAliveProbe(nodeAddr string, nodeType common.NodeType, dsAddr string) (Rep
 	defer remote.Close()
 
 	args := Args{
-		nodeAddr,
-		nodeType,
-		true}
+		node.Addr,
+		node.Type,
+		true,
+		node.ID,
+		node.Addrs,
+		node.UUID,
+		node.Labels}
 	e = common.RemoteCallNoFail(remote, "Srv.ImAlive", &args, &reply)
 	return reply, e
 }
 
-// ImAlivePoll polls the discosrv to inform it that the node on `nodeAddr` is online.
-func ImAlivePoll(nodeAddr string, nodeType common.NodeType, dsAddr string) (Reply, error) {
-	remote, e := rpc.DialHTTP("tcp", dsAddr)
+// ImAlivePoll polls the discosrv every `interval` to inform it that `node` is online.
+func ImAlivePoll(node common.Node, dsAddr string, interval time.Duration) (Reply, error) {
+	remote, e := common.DialRPC(dsAddr)
 	reply := Reply{}
 	if e != nil {
 		log.Printf("Node %v not online (DialHTTP)\n", dsAddr)
@@ -98,40 +133,29 @@ func ImAlivePoll(nodeAddr string, nodeType common.NodeType, dsAddr string) (Repl
 	defer remote.Close()
 
 	args := Args{
-		nodeAddr,
-		nodeType,
-		false}
+		node.Addr,
+		node.Type,
+		false,
+		node.ID,
+		node.Addrs,
+		node.UUID,
+		node.Labels}
 	for {
 		// TODO check whether discosrv is still online, otherwise redail
 		common.RemoteCallNoFail(remote, "Srv.ImAlive", &args, &reply)
-		time.Sleep(10 * time.Second)
+		time.Sleep(interval)
 	}
 }
 
 func (ds *Srv) runRemoveDead() {
 	for {
-		time.Sleep(time.Second)
+		time.Sleep(ds.timings.Poll)
 
-		threshold := int64(20)
-		t := time.Now().Unix()
 		log.Printf("%v GSs, %v RMs\n", len(ds.gsSet.GetAll()), len(ds.rmSet.GetAll()))
 
-		// TODO repeated code, loop over the two sets
-		ds.gsSet.Lock()
-		for k := range ds.gsSet.S {
-			if t-ds.gsSet.S[k].ID > threshold {
-				delete(ds.gsSet.S, k)
-			}
-		}
-		ds.gsSet.Unlock()
-
-		ds.rmSet.Lock()
-		for k := range ds.rmSet.S {
-			if t-ds.rmSet.S[k].ID > threshold {
-				delete(ds.rmSet.S, k)
-			}
-		}
-		ds.rmSet.Unlock()
+		dead := time.Now().Add(-ds.timings.DeadThreshold)
+		ds.gsSet.RemoveOlder(dead)
+		ds.rmSet.RemoveOlder(dead)
 	}
 }
 
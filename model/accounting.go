@@ -0,0 +1,376 @@
+package model
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the fields that a report can be grouped by
+const (
+	ReportByUser  = "user"
+	ReportByGroup = "group"
+	ReportByRM    = "rm"
+)
+
+// AccountingRecord is what the accounting database keeps about a finished job
+type AccountingRecord struct {
+	JobID      int64         `json:"job_id"`
+	Owner      string        `json:"owner"`
+	Group      string        `json:"group,omitempty"`
+	State      string        `json:"state"`
+	Reason     FailReason    `json:"reason,omitempty"`
+	ResMan     string        `json:"rm,omitempty"` // address of the RM that ran the job, empty if it never ran
+	ResManID   string        `json:"rm_id,omitempty"`
+	ArrayID    int64         `json:"array_id,omitempty"`
+	Cron       string        `json:"cron,omitempty"`
+	StartTime  time.Time     `json:"start_time"`
+	FinishTime time.Time     `json:"finish_time"`
+	WallTime   time.Duration `json:"wall_time"` // nanoseconds, 0 if the job never ran
+	CPUTime    time.Duration `json:"cpu_time"`
+	MaxRSS     int64         `json:"max_rss"`
+	ReadBytes  int64         `json:"read_bytes"`
+	WriteBytes int64         `json:"write_bytes"`
+}
+
+func newAccountingRecord(job Job) AccountingRecord {
+	rec := AccountingRecord{
+		JobID:      job.ID,
+		Owner:      job.Owner,
+		Group:      job.Group,
+		State:      strings.TrimPrefix(job.State.String(), "Job"),
+		Reason:     job.Reason,
+		ResMan:     job.ResMan,
+		ResManID:   job.ResManID,
+		ArrayID:    job.ArrayID,
+		Cron:       job.Cron,
+		StartTime:  job.StartTime,
+		FinishTime: job.FinishTime,
+	}
+	if rec.FinishTime.IsZero() {
+		rec.FinishTime = time.Now()
+	}
+	rec.setRun(job)
+	return rec
+}
+
+// setRun sets the run time and the resource usage that the RM reported for job
+func (rec *AccountingRecord) setRun(job Job) {
+	// the StartTime of a job that never ran is when it was submitted
+	if job.ResMan != "" && job.FinishTime.After(job.StartTime) {
+		rec.StartTime, rec.FinishTime = job.StartTime, job.FinishTime
+		rec.WallTime = job.FinishTime.Sub(job.StartTime)
+	}
+	rec.CPUTime, rec.MaxRSS, rec.ReadBytes, rec.WriteBytes = job.Usage.CPUTime, job.Usage.MaxRSS, job.Usage.ReadBytes, job.Usage.WriteBytes
+}
+
+// AccountingDB is an append-only file of accounting records with one JSON record per line, every GS keeps its own copy.
+// A later record of a job replaces the earlier ones when the file is read.
+type AccountingDB struct {
+	sync.Mutex
+	file    *os.File
+	records []AccountingRecord
+	index   map[int64]int // the position of the record of every job in records
+}
+
+// OpenAccountingDB opens the accounting database in path and reads its records, it is created if it does not exist.
+// It returns nil if path is empty, accounting is disabled then.
+func OpenAccountingDB(path string) (*AccountingDB, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, e := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if e != nil {
+		return nil, e
+	}
+	db := &AccountingDB{file: f, index: make(map[int64]int)}
+	r := bufio.NewReader(f)
+	invalid := 0
+	var line []byte
+	for {
+		line, e = r.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var rec AccountingRecord
+			if json.Unmarshal(line, &rec) != nil {
+				invalid++
+				continue
+			}
+			db.put(rec)
+		}
+		if e != nil {
+			break
+		}
+	}
+	if e != io.EOF {
+		f.Close()
+		return nil, e
+	}
+	// the last line is incomplete if the GS crashed while writing it, the next record starts on a new line
+	if len(line) > 0 {
+		invalid++
+		if _, e := f.Write([]byte("\n")); e != nil {
+			f.Close()
+			return nil, e
+		}
+	}
+	if invalid > 0 {
+		log.Printf("Skipped %v invalid accounting records in %v\n", invalid, path)
+	}
+	log.Printf("Loaded %v accounting records from %v\n", len(db.records), path)
+	return db, nil
+}
+
+func (db *AccountingDB) put(rec AccountingRecord) {
+	if i, ok := db.index[rec.JobID]; ok {
+		db.records[i] = rec
+		return
+	}
+	db.index[rec.JobID] = len(db.records)
+	db.records = append(db.records, rec)
+}
+
+// write appends rec to the file, the record is only kept if it was written
+func (db *AccountingDB) write(rec AccountingRecord) {
+	b, e := json.Marshal(rec)
+	if e == nil {
+		_, e = db.file.Write(append(b, '\n'))
+	}
+	if e != nil {
+		log.Printf("Failed to write the accounting record of job %v: %v\n", rec.JobID, e)
+		return
+	}
+	db.put(rec)
+}
+
+// record adds the record of a job that reached a terminal state, jobs that are already recorded and job arrays are
+// skipped because the tasks of an array are recorded by themselves
+func (db *AccountingDB) record(job Job) {
+	if db == nil || !job.State.terminal() || job.isArray() {
+		return
+	}
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.index[job.ID]; ok {
+		return
+	}
+	db.write(newAccountingRecord(job))
+}
+
+// amend adds the run time and the usage of a job that was recorded before its RM reported them,
+// e.g. because the job was cancelled while it ran
+func (db *AccountingDB) amend(done Job) {
+	if db == nil || done.FinishTime.IsZero() {
+		return
+	}
+	db.Lock()
+	defer db.Unlock()
+	i, ok := db.index[done.ID]
+	if !ok || db.records[i].WallTime > 0 {
+		return
+	}
+	rec := db.records[i]
+	done.ResMan = rec.ResMan
+	rec.setRun(done)
+	db.write(rec)
+}
+
+// ReportArgs is the RPC argument of Report
+type ReportArgs struct {
+	Creds  Credentials
+	By     []string      // the fields that the jobs are grouped by, see ReportByUser
+	Window time.Duration // the jobs are also grouped by when they finished in windows of this length if it is not 0
+	Since  time.Time     // only jobs that finished at or after Since, if it is not zero
+	Until  time.Time     // only jobs that finished before Until, if it is not zero
+}
+
+// ReportRow is the usage of one group of jobs in a report, the fields that the jobs are not grouped by are empty
+type ReportRow struct {
+	Window     time.Time // the start of the window
+	User       string
+	Group      string
+	ResMan     string
+	Jobs       int
+	Completed  int
+	Failed     int
+	Cancelled  int
+	WallTime   time.Duration
+	CPUTime    time.Duration
+	MaxRSS     int64 // the highest of all the jobs
+	ReadBytes  int64
+	WriteBytes int64
+}
+
+// ReportRowJSON is how a ReportRow is exported as JSON, with the same fields as the CSV export
+type ReportRowJSON struct {
+	Window      string  `json:"window,omitempty"` // RFC 3339
+	User        string  `json:"user,omitempty"`
+	Group       string  `json:"group,omitempty"`
+	ResMan      string  `json:"rm,omitempty"`
+	Jobs        int     `json:"jobs"`
+	Completed   int     `json:"completed"`
+	Failed      int     `json:"failed"`
+	Cancelled   int     `json:"cancelled"`
+	WallSeconds float64 `json:"wall_seconds"`
+	CPUSeconds  float64 `json:"cpu_seconds"`
+	MaxRSS      int64   `json:"max_rss_bytes"`
+	ReadBytes   int64   `json:"read_bytes"`
+	WriteBytes  int64   `json:"write_bytes"`
+}
+
+// ReportJSON converts the rows of a report for the JSON export
+func ReportJSON(rows []ReportRow) []ReportRowJSON {
+	res := make([]ReportRowJSON, len(rows))
+	for i, r := range rows {
+		res[i] = ReportRowJSON{"", r.User, r.Group, r.ResMan, r.Jobs, r.Completed, r.Failed, r.Cancelled,
+			r.WallTime.Seconds(), r.CPUTime.Seconds(), r.MaxRSS, r.ReadBytes, r.WriteBytes}
+		if !r.Window.IsZero() {
+			res[i].Window = r.Window.Format(time.RFC3339)
+		}
+	}
+	return res
+}
+
+// ParseReportTime parses the start or the end of a report, an RFC 3339 time or a duration before now, e.g. 168h
+func ParseReportTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, e := time.Parse(time.RFC3339, s); e == nil {
+		return t, nil
+	}
+	d, e := time.ParseDuration(s)
+	if e != nil || d < 0 {
+		return time.Time{}, errorf(errInvalid, "Invalid time %v, expected an RFC 3339 time or a duration before now", strconv.Quote(s))
+	}
+	return now.Add(-d), nil
+}
+
+// ValidateReportBy checks the fields that a report is grouped by
+func ValidateReportBy(by []string) error {
+	for _, f := range by {
+		if f != ReportByUser && f != ReportByGroup && f != ReportByRM {
+			return errorf(errInvalid, "Invalid report field %v, expected %v, %v or %v", strconv.Quote(f), ReportByUser, ReportByGroup, ReportByRM)
+		}
+	}
+	return nil
+}
+
+// report aggregates the records that user may access
+func (db *AccountingDB) report(args ReportArgs, user User) []ReportRow {
+	by := make(map[string]bool)
+	for _, f := range args.By {
+		by[f] = true
+	}
+	db.Lock()
+	defer db.Unlock()
+	groups := make(map[ReportRow]*ReportRow)
+	for _, rec := range db.records {
+		if !user.CanAccess(Job{ID: rec.JobID, Owner: rec.Owner}) ||
+			(!args.Since.IsZero() && rec.FinishTime.Before(args.Since)) ||
+			(!args.Until.IsZero() && !rec.FinishTime.Before(args.Until)) {
+			continue
+		}
+		var key ReportRow
+		if args.Window > 0 {
+			key.Window = rec.FinishTime.UTC().Truncate(args.Window)
+		}
+		if by[ReportByUser] {
+			key.User = rec.Owner
+		}
+		if by[ReportByGroup] {
+			key.Group = rec.Group
+		}
+		if by[ReportByRM] {
+			key.ResMan = rec.ResMan
+		}
+		row, ok := groups[key]
+		if !ok {
+			row = &ReportRow{Window: key.Window, User: key.User, Group: key.Group, ResMan: key.ResMan}
+			groups[key] = row
+		}
+		row.Jobs++
+		switch rec.State {
+		case "Completed":
+			row.Completed++
+		case "Failed":
+			row.Failed++
+		case "Cancelled":
+			row.Cancelled++
+		}
+		row.WallTime += rec.WallTime
+		row.CPUTime += rec.CPUTime
+		if rec.MaxRSS > row.MaxRSS {
+			row.MaxRSS = rec.MaxRSS
+		}
+		row.ReadBytes += rec.ReadBytes
+		row.WriteBytes += rec.WriteBytes
+	}
+
+	rows := make([]ReportRow, 0, len(groups))
+	for _, row := range groups {
+		rows = append(rows, *row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if !a.Window.Equal(b.Window) {
+			return a.Window.Before(b.Window)
+		}
+		if a.User != b.User {
+			return a.User < b.User
+		}
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		return a.ResMan < b.ResMan
+	})
+	return rows
+}
+
+// Report is called by the client to aggregate the accounting records, users that are not admins only get their own jobs
+func (gs *GridSdr) Report(args *ReportArgs, reply *[]ReportRow) error {
+	if !gs.ready.Get().(bool) {
+		return errorf(errNotReady, "Can't report because I'm not ready")
+	}
+	user, e := gs.users.Authenticate(args.Creds)
+	if e != nil {
+		return e
+	}
+	if gs.accounting == nil {
+		return errorf(errDisabled, "Accounting is disabled on %v", gs.Addr)
+	}
+	if e := ValidateReportBy(args.By); e != nil {
+		return e
+	}
+	if args.Window < 0 {
+		return errorf(errInvalid, "The report window must not be negative")
+	}
+	*reply = gs.accounting.report(*args, user)
+	return nil
+}
+
+// WriteReportCSV writes the rows of a report as CSV with a header, the durations are in seconds
+func WriteReportCSV(w io.Writer, rows []ReportRow) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"window", "user", "group", "rm", "jobs", "completed", "failed", "cancelled",
+		"wall_seconds", "cpu_seconds", "max_rss_bytes", "read_bytes", "write_bytes"})
+	for _, r := range rows {
+		window := ""
+		if !r.Window.IsZero() {
+			window = r.Window.Format(time.RFC3339)
+		}
+		cw.Write([]string{window, r.User, r.Group, r.ResMan, strconv.Itoa(r.Jobs), strconv.Itoa(r.Completed),
+			strconv.Itoa(r.Failed), strconv.Itoa(r.Cancelled),
+			strconv.FormatFloat(r.WallTime.Seconds(), 'f', 3, 64), strconv.FormatFloat(r.CPUTime.Seconds(), 'f', 3, 64),
+			strconv.FormatInt(r.MaxRSS, 10), strconv.FormatInt(r.ReadBytes, 10), strconv.FormatInt(r.WriteBytes, 10)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,126 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAccountingDBReload(t *testing.T) {
+	dir, e := ioutil.TempDir("", "accounting")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	finish := start.Add(time.Minute)
+	tests := []struct {
+		name    string
+		content string  // written to the file before it is opened
+		jobs    []Job   // recorded
+		want    []int64 // the IDs of the records after a reload
+	}{
+		{name: "empty"},
+		{
+			name: "recorded jobs",
+			jobs: []Job{
+				{ID: 1, Owner: "alice", State: JobCompleted, ResMan: "rm", StartTime: start, FinishTime: finish},
+				{ID: 2, Owner: "bob", State: JobFailed, StartTime: start, FinishTime: finish},
+			},
+			want: []int64{1, 2},
+		},
+		{
+			name: "running and array jobs are not recorded",
+			jobs: []Job{
+				{ID: 1, Owner: "alice", State: JobScheduled},
+				{ID: 2, Owner: "alice", State: JobCompleted, Array: &ArraySpec{0, 1, 1}, FinishTime: finish},
+			},
+		},
+		{
+			name: "a job is recorded once",
+			jobs: []Job{
+				{ID: 1, Owner: "alice", State: JobCompleted, FinishTime: finish},
+				{ID: 1, Owner: "alice", State: JobFailed, FinishTime: finish},
+			},
+			want: []int64{1},
+		},
+		{
+			name:    "invalid and incomplete lines are skipped",
+			content: "{\"job_id\": 7, \"owner\": \"alice\", \"state\": \"Completed\"}\nnot json\n{\"job_id\": 8",
+			jobs:    []Job{{ID: 9, Owner: "alice", State: JobCompleted, FinishTime: finish}},
+			want:    []int64{7, 9},
+		},
+	}
+	for i, test := range tests {
+		path := filepath.Join(dir, test.name)
+		if test.content != "" {
+			if e := ioutil.WriteFile(path, []byte(test.content), 0644); e != nil {
+				t.Fatal(e)
+			}
+		}
+		db, e := OpenAccountingDB(path)
+		if e != nil {
+			t.Fatalf("%v: failed to open: %v", test.name, e)
+		}
+		for _, job := range test.jobs {
+			db.record(job)
+		}
+		db.file.Close()
+
+		reloaded, e := OpenAccountingDB(path)
+		if e != nil {
+			t.Fatalf("%v: failed to reopen: %v", test.name, e)
+		}
+		var got []int64
+		for _, rec := range reloaded.records {
+			got = append(got, rec.JobID)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("test %v: reloaded %v, expected %v", i, got, test.want)
+		}
+		// the records that were written are read back as they were kept
+		if !reflect.DeepEqual(db.records, reloaded.records) && test.content == "" {
+			t.Errorf("test %v: reloaded %+v, expected %+v", i, reloaded.records, db.records)
+		}
+		admin := User{Name: "root", Admin: true}
+		if r, w := reloaded.report(ReportArgs{}, admin), db.report(ReportArgs{}, admin); !reflect.DeepEqual(r, w) {
+			t.Errorf("test %v: report after a reload is %+v, expected %+v", i, r, w)
+		}
+		reloaded.file.Close()
+	}
+}
+
+func TestAccountingDBAmendReload(t *testing.T) {
+	f, e := ioutil.TempFile("", "accounting")
+	if e != nil {
+		t.Fatal(e)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	db, e := OpenAccountingDB(f.Name())
+	if e != nil {
+		t.Fatal(e)
+	}
+	db.record(Job{ID: 1, Owner: "alice", State: JobCancelled, ResMan: "rm", StartTime: start, FinishTime: start})
+	db.amend(Job{ID: 1, StartTime: start, FinishTime: start.Add(time.Minute), Usage: ExecStats{CPUTime: time.Second}})
+	db.file.Close()
+
+	// the later record of the job replaces the earlier one
+	db, e = OpenAccountingDB(f.Name())
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer db.file.Close()
+	if len(db.records) != 1 {
+		t.Fatalf("reloaded %v records, expected 1", len(db.records))
+	}
+	if rec := db.records[0]; rec.WallTime != time.Minute || rec.CPUTime != time.Second || rec.State != "Cancelled" {
+		t.Errorf("reloaded %+v, expected the amended record", rec)
+	}
+}
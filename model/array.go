@@ -0,0 +1,278 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxArrayTasks is the largest number of tasks in a job array
+const maxArrayTasks = 1000000
+
+// maxArrayIndex bounds the indices of a job array to -maxArrayIndex..maxArrayIndex,
+// so that the number of tasks can't overflow
+const maxArrayIndex = 1<<31 - 1
+
+// ArrayIndexEnv is the environment variable that holds the index of an array task
+const ArrayIndexEnv = "ARRAY_INDEX"
+
+// ArraySpec is the index range of a job array, e.g. "1-10000:2" is 1, 3, ..., 9999.
+// A job array stays in incomingJobs as one job, its tasks are created when they are scheduled.
+type ArraySpec struct {
+	Start int
+	End   int
+	Step  int
+}
+
+// ParseArraySpec parses "start-end:step", "start-end" or a single index
+func ParseArraySpec(s string) (ArraySpec, error) {
+	spec := ArraySpec{Step: 1}
+	rng := s
+	if i := strings.Index(s, ":"); i >= 0 {
+		step, e := strconv.Atoi(s[i+1:])
+		if e != nil || step <= 0 {
+			return spec, errorf(errInvalid, "invalid step in job array %q", s)
+		}
+		spec.Step, rng = step, s[:i]
+	}
+	if rng == "" {
+		return spec, errorf(errInvalid, "invalid range in job array %q", s)
+	}
+	var e1, e2 error
+	if i := strings.Index(rng[1:], "-"); i >= 0 {
+		// the start may be negative, so the separator is searched after the first character
+		spec.Start, e1 = strconv.Atoi(rng[:i+1])
+		spec.End, e2 = strconv.Atoi(rng[i+2:])
+	} else {
+		spec.Start, e1 = strconv.Atoi(rng)
+		spec.End = spec.Start
+	}
+	if e1 != nil || e2 != nil {
+		return spec, errorf(errInvalid, "invalid range in job array %q", s)
+	}
+	return spec, spec.Validate()
+}
+
+// Validate checks that the array has at least one and at most maxArrayTasks tasks and that its indices are bounded
+func (a ArraySpec) Validate() error {
+	if a.Step <= 0 || a.End < a.Start {
+		return errorf(errInvalid, "invalid job array %v", a)
+	}
+	if a.Start < -maxArrayIndex || a.End > maxArrayIndex {
+		return errorf(errInvalid, "job array %v has an index beyond %v", a, maxArrayIndex)
+	}
+	if a.count() > maxArrayTasks {
+		return errorf(errInvalid, "job array %v has more than %v tasks", a, maxArrayTasks)
+	}
+	return nil
+}
+
+func (a ArraySpec) String() string {
+	if a.Step == 1 {
+		return fmt.Sprintf("%v-%v", a.Start, a.End)
+	}
+	return fmt.Sprintf("%v-%v:%v", a.Start, a.End, a.Step)
+}
+
+// Count returns the number of tasks of a valid array
+func (a ArraySpec) Count() int {
+	return int(a.count())
+}
+
+// count computes the number of tasks in int64 after the indices were converted, so that End-Start can't overflow
+func (a ArraySpec) count() int64 {
+	return (int64(a.End)-int64(a.Start))/int64(a.Step) + 1
+}
+
+// Index returns the index of the k-th task
+func (a ArraySpec) Index(k int) int {
+	return a.Start + k*a.Step
+}
+
+// isArray checks whether the job is a job array rather than a job or an array task
+func (j Job) isArray() bool {
+	return j.Array != nil && j.ArrayID == 0
+}
+
+// taskCount is the number of jobs that j stands for in incomingJobs, i.e. the tasks of a job array that
+// did not leave the queue yet
+func (j Job) taskCount() int {
+	if j.isArray() {
+		return j.Array.Count() - j.ArrayNext
+	}
+	return 1
+}
+
+// arrayTaskID returns the ID of the k-th task of the job array with ID arrayID,
+// the IDs are reserved when the array is submitted, see jobIDGen
+func arrayTaskID(arrayID int64, k int) int64 {
+	return arrayID + int64(k+1)<<nodeIDBits
+}
+
+// arrayPosition returns k if id is the ID of the k-th task of array
+func arrayPosition(array Job, id int64) (int, bool) {
+	d := id - array.ID
+	if d <= 0 || d%(1<<nodeIDBits) != 0 {
+		return 0, false
+	}
+	k := int(d>>nodeIDBits) - 1
+	return k, k < array.Array.Count()
+}
+
+// task creates the k-th task of the job array j
+func (j Job) task(k int) Job {
+	t := j
+	t.ID = arrayTaskID(j.ID, k)
+	t.ArrayID = j.ID
+	t.ArrayIndex = j.Array.Index(k)
+	t.ArrayNext = 0
+	t.Env = make(map[string]string)
+	for key, v := range j.Env {
+		t.Env[key] = v
+	}
+	t.Env[ArrayIndexEnv] = strconv.Itoa(t.ArrayIndex)
+	return t
+}
+
+// expandArrays replaces every job array in jobs by its next n tasks that are still queued
+func expandArrays(jobs []Job, n int) []Job {
+	if len(filterJobs(jobs, Job.isArray)) == 0 {
+		return jobs
+	}
+	var res []Job
+	for _, job := range jobs {
+		if !job.isArray() {
+			res = append(res, job)
+			continue
+		}
+		for k := job.ArrayNext; k < job.Array.Count() && k < job.ArrayNext+n; k++ {
+			res = append(res, job.task(k))
+		}
+	}
+	return res
+}
+
+// dropJobs removes the jobs with the given IDs from incomingJobs. The ID of a job array removes the whole array
+// and the ID of an array task marks it and the tasks before it as no longer queued, the tasks of an array are
+// always scheduled in order. The array itself stays in the queue until all its tasks are finished.
+func dropJobs(jobs []Job, ids []int64) []Job {
+	set := make(map[int64]bool)
+	for _, id := range ids {
+		set[id] = true
+	}
+	var res []Job
+	for _, job := range jobs {
+		if set[job.ID] {
+			continue
+		}
+		if job.isArray() {
+			for _, id := range ids {
+				if k, ok := arrayPosition(job, id); ok && k >= job.ArrayNext {
+					job.ArrayNext = k + 1
+				}
+			}
+		}
+		res = append(res, job)
+	}
+	return res
+}
+
+// arrayTasks returns the tasks of the job array id in jobs
+func arrayTasks(jobs []Job, id int64) []Job {
+	return filterJobs(jobs, func(j Job) bool { return j.ArrayID == id })
+}
+
+// summarizeArrays sets the number of tasks in each state, and the state, of the unfinished job arrays in jobs.
+// incoming and scheduled are the job queues, setStates must have been called on jobs.
+func (gs *GridSdr) summarizeArrays(jobs []Job, incoming []Job, scheduled []Job) {
+	for i, job := range jobs {
+		if !job.isArray() || job.State.terminal() {
+			continue
+		}
+		counts := gs.history.arrayCounts(job.ID)
+		counts[job.State] += job.taskCount()
+		counts[JobQueued] += len(arrayTasks(incoming, job.ID))
+		if running := len(arrayTasks(scheduled, job.ID)); running > 0 {
+			counts[JobScheduled] += running
+			jobs[i].State = JobScheduled
+		}
+		for s, n := range counts {
+			if n == 0 {
+				delete(counts, s)
+			}
+		}
+		jobs[i].ArrayTasks = counts
+	}
+}
+
+// arrayTally counts the finished tasks of a job array
+type arrayTally struct {
+	counts map[JobState]int
+	start  time.Time
+}
+
+// tally counts a task that was added to the history, it must hold the lock
+func (h *jobHistory) tally(task Job) {
+	t, ok := h.arrays[task.ArrayID]
+	if !ok {
+		t = &arrayTally{counts: make(map[JobState]int), start: task.StartTime}
+		h.arrays[task.ArrayID] = t
+	}
+	t.counts[task.State]++
+	if task.StartTime.Before(t.start) {
+		t.start = task.StartTime
+	}
+}
+
+// arrayCounts returns the number of finished tasks of the job array id in each state
+func (h *jobHistory) arrayCounts(id int64) map[JobState]int {
+	h.Lock()
+	defer h.Unlock()
+	counts := make(map[JobState]int)
+	if t, ok := h.arrays[id]; ok {
+		for s, n := range t.counts {
+			counts[s] = n
+		}
+	}
+	return counts
+}
+
+// finishArray adds the job array of task to the history if all its tasks are finished.
+// The array fails if a task failed and it is cancelled if a task was cancelled.
+func (h *jobHistory) finishArray(task Job) (Job, bool) {
+	if task.ArrayID == 0 || task.Array == nil {
+		return Job{}, false
+	}
+	h.Lock()
+	t, ok := h.arrays[task.ArrayID]
+	done := 0
+	if ok {
+		for _, n := range t.counts {
+			done += n
+		}
+	}
+	if _, finished := h.jobs[task.ArrayID]; !ok || finished || done < task.Array.Count() {
+		h.Unlock()
+		return Job{}, false
+	}
+	array := task
+	array.ID, array.ArrayID, array.ArrayIndex = task.ArrayID, 0, 0
+	array.ResMan, array.ResManID, array.Error = "", "", ""
+	array.StartTime, array.FinishTime = t.start, time.Now()
+	array.Env = make(map[string]string)
+	for k, v := range task.Env {
+		if k != ArrayIndexEnv {
+			array.Env[k] = v
+		}
+	}
+	array.State = JobCompleted
+	if t.counts[JobFailed] > 0 {
+		array.State, array.Error = JobFailed, fmt.Sprintf("%v tasks failed", t.counts[JobFailed])
+	} else if t.counts[JobCancelled] > 0 {
+		array.State = JobCancelled
+	}
+	array.ArrayTasks = t.counts
+	h.Unlock()
+	return array, h.add(array)
+}
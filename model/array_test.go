@@ -0,0 +1,76 @@
+package model
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestParseArraySpec(t *testing.T) {
+	tests := []struct {
+		s    string
+		want ArraySpec
+		ok   bool
+	}{
+		{"1-10", ArraySpec{1, 10, 1}, true},
+		{"1-10000:2", ArraySpec{1, 10000, 2}, true},
+		{"5", ArraySpec{5, 5, 1}, true},
+		{"-3-3", ArraySpec{-3, 3, 1}, true},
+		{"-5--1:2", ArraySpec{-5, -1, 2}, true},
+		{"-4", ArraySpec{-4, -4, 1}, true},
+		{"", ArraySpec{}, false},
+		{":5", ArraySpec{}, false},
+		{"-", ArraySpec{}, false},
+		{"1-", ArraySpec{}, false},
+		{"1-10:", ArraySpec{}, false},
+		{"1-10:0", ArraySpec{}, false},
+		{"1-10:-1", ArraySpec{}, false},
+		{"10-1", ArraySpec{}, false},
+		{"a-b", ArraySpec{}, false},
+		{"0-1000000", ArraySpec{}, false},
+		{"0-999999", ArraySpec{0, 999999, 1}, true},
+		// End-Start overflows an int
+		{"-9000000000000000000-9000000000000000000", ArraySpec{}, false},
+		{"-9000000000000000000-9000000000000000000:9000000000000000000", ArraySpec{}, false},
+		{"2147483647", ArraySpec{2147483647, 2147483647, 1}, true},
+		{"2147483648", ArraySpec{}, false},
+		{"-2147483648", ArraySpec{}, false},
+	}
+	for _, test := range tests {
+		spec, e := ParseArraySpec(test.s)
+		if (e == nil) != test.ok || (e == nil && spec != test.want) {
+			t.Errorf("ParseArraySpec(%q) = %v, %v, expected %v", test.s, spec, e, test.want)
+		}
+		if e != nil && kindOf(e) != errInvalid {
+			t.Errorf("ParseArraySpec(%q) returned %v which is not a validation error", test.s, e)
+		}
+	}
+}
+
+func TestArrayPosition(t *testing.T) {
+	array := Job{ID: 3<<nodeIDBits | 1, Array: &ArraySpec{1, 10, 3}} // 4 tasks: 1, 4, 7, 10
+	tests := []struct {
+		id int64
+		k  int
+		ok bool
+	}{
+		{arrayTaskID(array.ID, 0), 0, true},
+		{arrayTaskID(array.ID, 3), 3, true},
+		{arrayTaskID(array.ID, 4), 4, false},
+		{array.ID, 0, false},
+		{array.ID - 1<<nodeIDBits, 0, false},
+		{arrayTaskID(array.ID, 1) + 1, 0, false},
+		{arrayTaskID(array.ID, 1) - 1, 0, false},
+	}
+	for _, test := range tests {
+		k, ok := arrayPosition(array, test.id)
+		if ok != test.ok || (ok && k != test.k) {
+			t.Errorf("arrayPosition(%v) = %v, %v, expected %v, %v", test.id, k, ok, test.k, test.ok)
+		}
+	}
+	for k := 0; k < array.Array.Count(); k++ {
+		index := array.Array.Index(k)
+		if task := array.task(k); task.ID != arrayTaskID(array.ID, k) || task.ArrayIndex != index || task.Env[ArrayIndexEnv] != strconv.Itoa(index) {
+			t.Errorf("task %v has ID %v and index %v (%v), expected index %v", k, task.ID, task.ArrayIndex, task.Env[ArrayIndexEnv], index)
+		}
+	}
+}
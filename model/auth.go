@@ -0,0 +1,99 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+// AnonymousUser is used for every request when authentication is disabled
+const AnonymousUser = "anonymous"
+
+// User is an authenticated principal, it is looked up from the API token
+type User struct {
+	Name  string
+	Group string
+	Admin bool
+}
+
+// Credentials are sent by the client with every user facing RPC call
+type Credentials struct {
+	Token string
+}
+
+// UserJobsArgs is the RPC argument for submitting jobs as a user
+type UserJobsArgs struct {
+	Creds          Credentials
+	Jobs           []Job // the IDs are assigned by the GS
+	IdempotencyKey string
+}
+
+// UserIDsArgs is the RPC argument for user requests on existing jobs, e.g. status or cancel
+type UserIDsArgs struct {
+	Creds Credentials
+	IDs   []int64
+}
+
+// UserTable maps API tokens to users, it is read only after loading.
+// A nil UserTable means authentication is disabled.
+type UserTable struct {
+	users map[string]User
+}
+
+// userFile is the on-disk format of the user table
+type userFile struct {
+	Users []struct {
+		Name  string `json:"name"`
+		Group string `json:"group"`
+		Token string `json:"token"`
+		Admin bool   `json:"admin"`
+	} `json:"users"`
+}
+
+// LoadUserTable reads the JSON user file at path, an empty path disables authentication.
+func LoadUserTable(path string) (*UserTable, error) {
+	if path == "" {
+		log.Println("No user file, authentication is disabled")
+		return nil, nil
+	}
+
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return nil, e
+	}
+	var f userFile
+	if e := json.Unmarshal(b, &f); e != nil {
+		return nil, fmt.Errorf("failed to parse user file %v: %v", path, e)
+	}
+
+	t := &UserTable{make(map[string]User)}
+	for _, u := range f.Users {
+		if u.Name == "" || u.Token == "" {
+			return nil, fmt.Errorf("user file %v has an entry without name or token", path)
+		}
+		if _, ok := t.users[u.Token]; ok {
+			return nil, fmt.Errorf("user file %v has a duplicate token for %v", path, u.Name)
+		}
+		t.users[u.Token] = User{u.Name, u.Group, u.Admin}
+	}
+	log.Printf("Loaded %v users from %v\n", len(t.users), path)
+	return t, nil
+}
+
+// Authenticate finds the user that owns the token in creds.
+func (t *UserTable) Authenticate(creds Credentials) (User, error) {
+	if t == nil {
+		return User{AnonymousUser, "", true}, nil
+	}
+	u, ok := t.users[creds.Token]
+	if !ok {
+		return User{}, errorf(errUnauthenticated, "Authentication failed, invalid token")
+	}
+	return u, nil
+}
+
+// CanAccess checks whether the user is allowed to query or modify the job
+func (u User) CanAccess(job Job) bool {
+	return u.Admin || job.Owner == u.Name
+}
@@ -0,0 +1,217 @@
+package model
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CheckpointDirEnv is the environment variable that holds the checkpoint directory of a job,
+// the job writes its state there when it is signalled to checkpoint
+const CheckpointDirEnv = "CHECKPOINT_DIR"
+
+// progressFile is the file in the checkpoint directory where a sleep job writes how long it slept
+const progressFile = "progress"
+
+// latestFile is the file in the directory of a job in the checkpoint store with the number of the latest checkpoint
+const latestFile = "latest"
+
+// CheckpointConfig configures checkpointing on a RM
+type CheckpointConfig struct {
+	WorkDir string // local directory for the checkpoint directories of the running jobs
+	Store   string // shared directory that every RM can reach, checkpointing is disabled if empty
+}
+
+// checkpointStore keeps the checkpoints of the jobs in a shared directory, every job has a directory
+// with numbered checkpoints and the latest file that points to the newest complete one
+type checkpointStore struct {
+	dir string
+}
+
+func (s checkpointStore) enabled() bool {
+	return s.dir != ""
+}
+
+func (s checkpointStore) jobDir(id int64) string {
+	return filepath.Join(s.dir, strconv.FormatInt(id, 10))
+}
+
+// latest returns the number of the latest checkpoint of job id, 0 if there is none
+func (s checkpointStore) latest(id int64) (int, error) {
+	b, e := ioutil.ReadFile(filepath.Join(s.jobDir(id), latestFile))
+	if os.IsNotExist(e) {
+		return 0, nil
+	} else if e != nil {
+		return 0, e
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+// upload copies the files in src to a new checkpoint of job id and makes it the latest,
+// the previous checkpoint is kept in case a RM is still downloading it
+func (s checkpointStore) upload(id int64, src string) (int, error) {
+	dir := s.jobDir(id)
+	if e := os.MkdirAll(dir, 0755); e != nil {
+		return 0, e
+	}
+	n, e := s.latest(id)
+	if e != nil {
+		return 0, e
+	}
+	n++
+
+	tmp, e := ioutil.TempDir(dir, ".upload-")
+	if e != nil {
+		return 0, e
+	}
+	defer os.RemoveAll(tmp)
+	if e := copyDir(src, tmp); e != nil {
+		return 0, e
+	}
+	// a checkpoint only becomes visible when it is complete
+	if e := os.Rename(tmp, filepath.Join(dir, strconv.Itoa(n))); e != nil {
+		return 0, e
+	}
+	if e := writeFileAtomic(filepath.Join(dir, latestFile), []byte(strconv.Itoa(n)+"\n")); e != nil {
+		return 0, e
+	}
+	os.RemoveAll(filepath.Join(dir, strconv.Itoa(n-2)))
+	return n, nil
+}
+
+// download copies the latest checkpoint of job id to dst and returns its number, 0 if there is none
+func (s checkpointStore) download(id int64, dst string) (int, error) {
+	n, e := s.latest(id)
+	if e != nil || n == 0 {
+		return 0, e
+	}
+	return n, copyDir(filepath.Join(s.jobDir(id), strconv.Itoa(n)), dst)
+}
+
+// remove deletes all checkpoints of job id
+func (s checkpointStore) remove(id int64) error {
+	return os.RemoveAll(s.jobDir(id))
+}
+
+// copyDir copies the regular files and directories in src to dst, dst must exist
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+		rel, e := filepath.Rel(src, path)
+		if e != nil || rel == "." {
+			return e
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm())
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, e := os.Open(src)
+	if e != nil {
+		return e
+	}
+	defer in.Close()
+	out, e := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if e != nil {
+		return e
+	}
+	if _, e := io.Copy(out, in); e != nil {
+		out.Close()
+		return e
+	}
+	return out.Close()
+}
+
+// writeFileAtomic replaces path with data so that readers never see a partial file
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if e := ioutil.WriteFile(tmp, data, 0644); e != nil {
+		return e
+	}
+	return os.Rename(tmp, path)
+}
+
+// writeProgress is what a sleep job does when it is signalled to checkpoint, it writes how long it slept to dir
+func writeProgress(dir string, slept time.Duration) error {
+	return writeFileAtomic(filepath.Join(dir, progressFile), []byte(slept.String()+"\n"))
+}
+
+// readProgress returns how long a sleep job slept according to the checkpoint in dir, 0 if there is none
+func readProgress(dir string) (time.Duration, error) {
+	b, e := ioutil.ReadFile(filepath.Join(dir, progressFile))
+	if os.IsNotExist(e) {
+		return 0, nil
+	} else if e != nil {
+		return 0, e
+	}
+	d, e := time.ParseDuration(strings.TrimSpace(string(b)))
+	if e != nil {
+		return 0, fmt.Errorf("invalid progress in checkpoint: %v", e)
+	}
+	return d, nil
+}
+
+// jobCheckpoint is the checkpoint directory of a job that runs on this RM
+type jobCheckpoint struct {
+	id    int64
+	dir   string // local directory that the job writes its checkpoints to
+	store checkpointStore
+}
+
+// restoreCheckpoint creates the local checkpoint directory of job and fills it with the latest checkpoint from the store,
+// it returns nil if the job or this RM does not checkpoint
+func (rm *ResMan) restoreCheckpoint(job Job) (*jobCheckpoint, error) {
+	if job.Checkpoint <= 0 || !rm.checkpoints.enabled() {
+		return nil, nil
+	}
+	ck := &jobCheckpoint{job.ID, filepath.Join(rm.workDir, "checkpoints", strconv.FormatInt(job.ID, 10)), rm.checkpoints}
+	// a leftover from an earlier run on this RM may be older than the checkpoint in the store
+	os.RemoveAll(ck.dir)
+	if e := os.MkdirAll(ck.dir, 0755); e != nil {
+		return nil, e
+	}
+	n, e := ck.store.download(job.ID, ck.dir)
+	if e != nil {
+		ck.discard(false)
+		return nil, e
+	}
+	if n > 0 {
+		rm.jobs.logf(job.ID, "restored checkpoint %v", n)
+	}
+	return ck, nil
+}
+
+// discard removes the local checkpoint directory, and the checkpoints in the store if the job does not run again
+func (ck *jobCheckpoint) discard(final bool) {
+	os.RemoveAll(ck.dir)
+	if final {
+		ck.store.remove(ck.id)
+	}
+}
+
+// uploadCheckpoint uploads the checkpoint directory of a running job to the store,
+// failures are logged because the job can go on without it
+func (rm *ResMan) uploadCheckpoint(ck *jobCheckpoint) {
+	n, e := ck.store.upload(ck.id, ck.dir)
+	if e != nil {
+		log.Printf("Failed to upload the checkpoint of job %v: %v\n", ck.id, e)
+		rm.jobs.logf(ck.id, "checkpoint failed: %v", e)
+		return
+	}
+	rm.jobs.logf(ck.id, "saved checkpoint %v", n)
+}
@@ -0,0 +1,167 @@
+package model
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readDir returns the contents of the regular files below dir by their relative paths
+func readDir(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string)
+	e := filepath.Walk(dir, func(path string, info os.FileInfo, e error) error {
+		if e != nil || !info.Mode().IsRegular() {
+			return e
+		}
+		b, e := ioutil.ReadFile(path)
+		rel, _ := filepath.Rel(dir, path)
+		files[rel] = string(b)
+		return e
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	return files
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	root, e := ioutil.TempDir("", "checkpoint")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(root)
+	store := checkpointStore{filepath.Join(root, "store")}
+	src := filepath.Join(root, "src")
+
+	// every upload is a new checkpoint, only the latest two are kept
+	versions := []map[string]string{
+		{"state": "1"},
+		{"state": "2", filepath.Join("sub", "data"): "x"},
+		{"state": "3"},
+	}
+	for i, files := range versions {
+		os.RemoveAll(src)
+		for name, data := range files {
+			os.MkdirAll(filepath.Dir(filepath.Join(src, name)), 0755)
+			if e := ioutil.WriteFile(filepath.Join(src, name), []byte(data), 0644); e != nil {
+				t.Fatal(e)
+			}
+		}
+		n, e := store.upload(42, src)
+		if e != nil || n != i+1 {
+			t.Fatalf("upload %v: checkpoint %v, %v", i+1, n, e)
+		}
+
+		dst := filepath.Join(root, fmt.Sprintf("dst%v", i))
+		os.MkdirAll(dst, 0755)
+		if n, e := store.download(42, dst); e != nil || n != i+1 {
+			t.Fatalf("download %v: checkpoint %v, %v", i+1, n, e)
+		}
+		if got := readDir(t, dst); !reflect.DeepEqual(got, files) {
+			t.Errorf("checkpoint %v has %v, expected %v", i+1, got, files)
+		}
+	}
+
+	// the latest file is replaced, there are no leftovers from the uploads
+	entries, _ := ioutil.ReadDir(store.jobDir(42))
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"2", "3", latestFile}; !reflect.DeepEqual(names, want) {
+		t.Errorf("the store has %v, expected %v", names, want)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(store.jobDir(42), latestFile)); string(b) != "3\n" {
+		t.Errorf("the latest file has %q, expected \"3\\n\"", b)
+	}
+
+	// jobs without checkpoints download nothing
+	if n, e := store.download(7, filepath.Join(root, "dst0")); n != 0 || e != nil {
+		t.Errorf("download without checkpoints: checkpoint %v, %v", n, e)
+	}
+	if e := store.remove(42); e != nil {
+		t.Fatal(e)
+	}
+	if n, e := store.latest(42); n != 0 || e != nil {
+		t.Errorf("latest after remove: checkpoint %v, %v", n, e)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, e := ioutil.TempDir("", "atomic")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, latestFile)
+
+	for _, data := range []string{"1\n", "22\n", "3\n"} {
+		if e := writeFileAtomic(path, []byte(data)); e != nil {
+			t.Fatal(e)
+		}
+		if b, _ := ioutil.ReadFile(path); string(b) != data {
+			t.Errorf("the file has %q, expected %q", b, data)
+		}
+	}
+	if _, e := os.Stat(path + ".tmp"); !os.IsNotExist(e) {
+		t.Errorf("the temporary file was left behind: %v", e)
+	}
+}
+
+func TestSleepExecutorProgress(t *testing.T) {
+	tests := []struct {
+		name     string
+		progress string // the content of the progress file, none if empty
+		slept    time.Duration
+		ignored  bool
+	}{
+		{"no checkpoint", "", 0, false},
+		{"resumed", "1h30m\n", 90 * time.Minute, false},
+		{"corrupt", "half an hour\n", 0, true},
+		{"truncated", "1h3", 0, true},
+	}
+	for _, test := range tests {
+		dir, e := ioutil.TempDir("", "progress")
+		if e != nil {
+			t.Fatal(e)
+		}
+		if test.progress != "" {
+			ioutil.WriteFile(filepath.Join(dir, progressFile), []byte(test.progress), 0644)
+		}
+
+		var logs []string
+		x := &sleepExecutor{}
+		spec := TaskSpec{Job: Job{ID: 1, Duration: 2 * time.Hour}, Env: map[string]string{CheckpointDirEnv: dir},
+			Log: func(format string, v ...interface{}) { logs = append(logs, fmt.Sprintf(format, v...)) }}
+		if e := x.Start(spec); e != nil {
+			t.Fatalf("%v: %v", test.name, e)
+		}
+		x.Kill()
+		x.Wait()
+		os.RemoveAll(dir)
+
+		ignored := strings.Contains(strings.Join(logs, "\n"), "ignoring checkpoint")
+		if x.slept != test.slept || ignored != test.ignored {
+			t.Errorf("%v: slept %v and ignored is %v, expected %v and %v (log %q)",
+				test.name, x.slept, ignored, test.slept, test.ignored, logs)
+		}
+	}
+
+	// the progress that a job writes is what it reads when it is resumed
+	dir, e := ioutil.TempDir("", "progress")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	if e := writeProgress(dir, 42*time.Second); e != nil {
+		t.Fatal(e)
+	}
+	if d, e := readProgress(dir); d != 42*time.Second || e != nil {
+		t.Errorf("read progress %v, %v, expected 42s", d, e)
+	}
+}
@@ -0,0 +1,535 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+import "github.com/kc1212/virtual-grid/common"
+
+// CronPolicy decides what happens when a cron job fires while the jobs of its previous run are still queued or running
+type CronPolicy string
+
+const (
+	CronAllow   CronPolicy = "allow"   // start the new run anyway
+	CronForbid  CronPolicy = "forbid"  // skip the new run
+	CronReplace CronPolicy = "replace" // cancel the previous run and start the new one
+)
+
+// maxCatchUp is the largest number of missed fire times that are run when there was no leader for a while,
+// older fire times are skipped
+const maxCatchUp = 10
+
+// CronJob creates the jobs in Jobs at every fire time of Schedule. The cron jobs are replicated between the GSs
+// and only changed in the critical section, the leader fires them.
+type CronJob struct {
+	Name     string // unique
+	Owner    string
+	Group    string
+	Schedule string // e.g. "*/5 * * * *", "@daily" or "@every 30s", in the local time of the leader
+	Policy   CronPolicy
+	Jobs     []Job // the template of every run
+	Paused   bool
+	Next     time.Time // the next fire time
+	LastRun  time.Time // the fire time of the last run
+	LastIDs  []int64   // the jobs of the last run
+	LastSkip string    // why the last skipped fire time was skipped
+	Runs     int
+	Skipped  int
+	Deleted  bool // only set when a deletion is replicated
+}
+
+// CronArgs is the RPC argument for creating a cron job
+type CronArgs struct {
+	Creds Credentials
+	Cron  CronJob
+}
+
+// CronNameArgs is the RPC argument for pausing, resuming and deleting a cron job
+type CronNameArgs struct {
+	Creds  Credentials
+	Name   string
+	Paused bool
+}
+
+// cronSchedule is a parsed schedule, the fields are bit sets of the allowed values
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	anyDay                        bool // day of month or day of week is "*", so both must match
+	every                         time.Duration
+}
+
+// cronFields are the ranges of minute, hour, day of month, month and day of week, Sunday is 0 or 7
+var cronFields = [5]struct{ min, max int }{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCronSchedule parses a cron expression with five fields, a descriptor such as "@daily" or "@every duration"
+func parseCronSchedule(s string) (cronSchedule, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "@every ") {
+		d, e := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(s, "@every ")))
+		if e != nil || d < time.Second {
+			return cronSchedule{}, errorf(errInvalid, "invalid interval in schedule %q, it must be at least 1s", s)
+		}
+		return cronSchedule{every: d}, nil
+	}
+	if d, ok := cronDescriptors[s]; ok {
+		s = d
+	}
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return cronSchedule{}, errorf(errInvalid, "schedule %q must have 5 fields: minute hour day-of-month month day-of-week", s)
+	}
+	var bits [5]uint64
+	for i, f := range fields {
+		b, e := parseCronField(f, cronFields[i].min, cronFields[i].max)
+		if e != nil {
+			return cronSchedule{}, errorf(errInvalid, "invalid field %q in schedule %q", f, s)
+		}
+		bits[i] = b
+	}
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	anyDay := strings.HasPrefix(fields[2], "*") || strings.HasPrefix(fields[4], "*")
+	return cronSchedule{bits[0], bits[1], bits[2], bits[3], bits[4], anyDay, 0}, nil
+}
+
+// parseCronField parses a comma separated list of "*", "a", "a-b", each optionally followed by "/step"
+func parseCronField(f string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(f, ",") {
+		rng, step, stepped := part, 1, false
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, e := strconv.Atoi(part[i+1:])
+			if e != nil || n <= 0 {
+				return 0, errors.New("invalid step")
+			}
+			rng, step, stepped = part[:i], n, true
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			var e1, e2 error
+			if i := strings.Index(rng, "-"); i >= 0 {
+				lo, e1 = strconv.Atoi(rng[:i])
+				hi, e2 = strconv.Atoi(rng[i+1:])
+			} else if lo, e1 = strconv.Atoi(rng); !stepped {
+				// "a/step" runs from a to the end of the range
+				hi = lo
+			}
+			if e1 != nil || e2 != nil || lo < min || hi > max || lo > hi {
+				return 0, errors.New("invalid range")
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// next returns the first fire time after t, it is zero if the schedule never fires, e.g. on the 30th of February
+func (s cronSchedule) next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Truncate(s.every).Add(s.every)
+	}
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		} else if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		} else if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		} else if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+		} else {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches checks the day of month and the day of week, like cron only one of them has to match
+// if both are restricted
+func (s cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.anyDay {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// validate checks a new cron job and sets the defaults, the first fire time is after now
+func (c *CronJob) validate(now time.Time) error {
+	if c.Name == "" || strings.ContainsAny(c.Name, " \t\n") {
+		return errorf(errInvalid, "Invalid cron job name %q", c.Name)
+	}
+	if c.Policy == "" {
+		c.Policy = CronAllow
+	}
+	if c.Policy != CronAllow && c.Policy != CronForbid && c.Policy != CronReplace {
+		return errorf(errInvalid, "Invalid concurrency policy %q, it must be %v, %v or %v", c.Policy, CronAllow, CronForbid, CronReplace)
+	}
+	if len(c.Jobs) == 0 {
+		return errorf(errInvalid, "Cron job %v has no jobs", c.Name)
+	}
+	// the jobs are checked like the jobs that are submitted by AddJobsViaUser, they are not checked again when they run
+	for _, job := range c.Jobs {
+		if e := job.validate(); e != nil {
+			return e
+		}
+		if job.Array != nil {
+			if e := job.Array.Validate(); e != nil {
+				return e
+			}
+		}
+	}
+	s, e := parseCronSchedule(c.Schedule)
+	if e != nil {
+		return e
+	}
+	if c.Next = s.next(now); c.Next.IsZero() {
+		return errorf(errInvalid, "Schedule %q never fires", c.Schedule)
+	}
+	return nil
+}
+
+// next returns the first fire time of the cron job after t, the schedule was validated when the cron job was created
+func (c CronJob) next(t time.Time) time.Time {
+	s, _ := parseCronSchedule(c.Schedule)
+	return s.next(t)
+}
+
+// due returns the fire time that should run next and the number of older fire times that are skipped
+// because more than maxCatchUp fire times are due at now
+func (c CronJob) due(now time.Time) (time.Time, int) {
+	var times []time.Time // the last maxCatchUp due fire times
+	n := 0
+	for t := c.Next; !t.IsZero() && !t.After(now); t = c.next(t) {
+		n++
+		if times = append(times, t); len(times) > maxCatchUp {
+			times = times[1:]
+		}
+	}
+	if n > maxCatchUp {
+		return times[0], n - maxCatchUp
+	}
+	return c.Next, 0
+}
+
+// cronKey is the idempotency key of the run at fire time, so a fire time runs only once even if a new leader fires it again
+func cronKey(name string, fire time.Time) string {
+	return fmt.Sprintf("cron:%v:%v", name, fire.UnixNano())
+}
+
+// jobs creates the jobs of the run at fire time
+func (c CronJob) jobs(fire time.Time) []Job {
+	now := time.Now()
+	jobs := make([]Job, len(c.Jobs))
+	for i, t := range c.Jobs {
+		jobs[i] = Job{Owner: c.Owner, Group: c.Group, Duration: t.Duration, StartTime: now, SubmitKey: cronKey(c.Name, fire),
+			Callbacks: t.Callbacks, Env: t.Env, Array: t.Array, Cron: c.Name, Priority: t.Priority, Checkpoint: t.Checkpoint,
+			Inputs: t.Inputs, Outputs: t.Outputs, Command: t.Command, Script: t.Script, Executor: t.Executor}
+	}
+	return jobs
+}
+
+// cronTable is the replicated set of cron jobs
+type cronTable struct {
+	sync.Mutex
+	m map[string]CronJob
+}
+
+func newCronTable() *cronTable {
+	return &cronTable{m: make(map[string]CronJob)}
+}
+
+func (t *cronTable) get(name string) (CronJob, bool) {
+	t.Lock()
+	defer t.Unlock()
+	c, ok := t.m[name]
+	return c, ok
+}
+
+// set adds or replaces a cron job, a cron job with Deleted is removed
+func (t *cronTable) set(c CronJob) {
+	t.Lock()
+	defer t.Unlock()
+	if c.Deleted {
+		delete(t.m, c.Name)
+	} else {
+		t.m[c.Name] = c
+	}
+}
+
+// getAll returns all the cron jobs ordered by name
+func (t *cronTable) getAll() []CronJob {
+	t.Lock()
+	defer t.Unlock()
+	res := make([]CronJob, 0, len(t.m))
+	for _, c := range t.m {
+		res = append(res, c)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// runCrons fires the cron jobs that are due every tick, only the leader does this.
+// The fire times are handled one at a time, so the runs that were missed while there was no leader are caught up.
+func (gs *GridSdr) runCrons() {
+	for {
+		time.Sleep(gs.timings.Tick)
+		if !gs.imLeader() || !gs.ready.Get().(bool) {
+			continue
+		}
+		now := time.Now()
+		for _, cron := range gs.crons.getAll() {
+			if !cron.Paused && !cron.Next.After(now) {
+				gs.fireCron(cron, now)
+			}
+		}
+	}
+}
+
+// fireCron runs or skips the next due fire time of cron according to its concurrency policy
+func (gs *GridSdr) fireCron(cron CronJob, now time.Time) {
+	fire, dropped := cron.due(now)
+	if dropped > 0 {
+		log.Printf("Cron job %v missed %v fire times, catching up from %v\n", cron.Name, dropped, fire)
+	}
+
+	skip := ""
+	if _, ok := gs.jobIDs.lookup(cron.Owner, cronKey(cron.Name, fire)); ok {
+		// a previous leader fired it already, the run is only recorded
+	} else if active := gs.activeJobs(cron.LastIDs); len(active) > 0 && cron.Policy == CronForbid {
+		skip = fmt.Sprintf("%v jobs of the previous run are not finished", len(active))
+	} else {
+		if len(active) > 0 && cron.Policy == CronReplace {
+			log.Printf("Cron job %v replaces %v jobs of the previous run\n", cron.Name, len(active))
+			gs.cancelJobs(active)
+		}
+		queued := countJobs(gs.getIncomingJobs())
+		quotas := gs.quotas.get()
+		for _, job := range cron.jobs(fire) {
+			if quotas.exceedsQueued(job, queued) {
+				skip = fmt.Sprintf("The jobs exceed the queued quota of %v", job.Owner)
+				break
+			}
+			queued.add(job)
+		}
+	}
+	if skip != "" {
+		log.Printf("Cron job %v skipped %v, %v\n", cron.Name, fire, skip)
+	}
+	gs.runCronAsTask(cron.Name, cron.Next, fire, dropped, skip)
+}
+
+// activeJobs returns the jobs out of ids that are still queued or running, including the tasks of job arrays
+func (gs *GridSdr) activeJobs(ids []int64) []Job {
+	if len(ids) == 0 {
+		return nil
+	}
+	set := make(map[int64]bool)
+	for _, id := range ids {
+		set[id] = true
+	}
+	incoming, scheduled := gs.getJobs()
+	return filterJobs(append(incoming, scheduled...), func(j Job) bool { return set[j.ID] || set[j.ArrayID] })
+}
+
+// runCronAsTask adds the jobs of the run at fire time, or records that it is skipped, and moves the cron job
+// to its next fire time. Nothing happens if the cron job was changed since next was read, e.g. it was paused.
+func (gs *GridSdr) runCronAsTask(name string, next time.Time, fire time.Time, dropped int, skip string) {
+	c := make(chan int)
+	gs.tasks <- func() (interface{}, error) {
+		cron, ok := gs.crons.get(name)
+		if !ok || cron.Paused || !cron.Next.Equal(next) {
+			c <- 0
+			return 0, nil
+		}
+
+		jobs := cron.jobs(fire)
+		var reply SubmitReply
+		if gs.findDuplicate(jobs, &reply) {
+			skip = ""
+		} else if skip == "" {
+			gs.jobIDs.assign(jobs)
+			// add jobs to myself
+			r := -1
+			if e := gs.AddJobs(&jobs, &r); e != nil {
+				c <- 0
+				return 0, nil
+			}
+			// and to the others
+			rpcJobsGo(common.SliceFromMap(gs.gsNodes.GetAll()), &jobs, rpcSyncJobs)
+			reply.IDs = jobIDs(jobs)
+		}
+
+		if skip == "" {
+			cron.LastRun, cron.LastIDs = fire, reply.IDs
+			cron.Runs++
+		} else {
+			cron.LastSkip = skip
+			cron.Skipped++
+		}
+		cron.Skipped += dropped
+		cron.Next = cron.next(fire)
+		gs.setCron(cron)
+		c <- 0
+		return 0, nil
+	}
+	<-c
+}
+
+// setCron changes a cron job on myself and on the others
+// NOTE: it must run in the CS.
+func (gs *GridSdr) setCron(cron CronJob) {
+	gs.crons.set(cron)
+	rpcCronGo(common.SliceFromMap(gs.gsNodes.GetAll()), &cron)
+}
+
+// findUserCron authenticates the user and finds the cron job name, the user must own it or be an admin
+func (gs *GridSdr) findUserCron(creds Credentials, name string) (CronJob, error) {
+	user, e := gs.users.Authenticate(creds)
+	if e != nil {
+		return CronJob{}, e
+	}
+	cron, ok := gs.crons.get(name)
+	if !ok {
+		return cron, errorf(errNotFound, "Cron job %v does not exist", name)
+	}
+	if !user.CanAccess(Job{Owner: cron.Owner}) {
+		return cron, errorf(errForbidden, "User %v may not access cron job %v", user.Name, name)
+	}
+	return cron, nil
+}
+
+// CreateCron is called by the client to add a cron job, the reply is the new cron job with its first fire time.
+func (gs *GridSdr) CreateCron(args *CronArgs, reply *CronJob) error {
+	if !gs.ready.Get().(bool) {
+		return errorf(errNotReady, "Can't create cron jobs because I'm not ready")
+	}
+	user, e := gs.users.Authenticate(args.Creds)
+	if e != nil {
+		return e
+	}
+	cron := CronJob{Name: args.Cron.Name, Owner: user.Name, Group: user.Group, Schedule: args.Cron.Schedule,
+		Policy: args.Cron.Policy, Jobs: args.Cron.Jobs}
+	if e := cron.validate(time.Now()); e != nil {
+		return e
+	}
+	for _, job := range cron.Jobs {
+		if e := gs.preempt.checkPriority(user, job); e != nil {
+			return e
+		}
+		if e := gs.webhooks.checkHosts(job); e != nil {
+			return e
+		}
+	}
+
+	c := make(chan error)
+	gs.tasks <- func() (interface{}, error) {
+		// the name is checked in the CS, the same name may be created on another GS at the same time
+		if _, ok := gs.crons.get(cron.Name); ok {
+			c <- errorf(errConflict, "Cron job %v already exists", cron.Name)
+			return 0, nil
+		}
+		gs.setCron(cron)
+		c <- nil
+		return 0, nil
+	}
+	if e := <-c; e != nil {
+		return e
+	}
+	log.Printf("Cron job %v of %v created, next run at %v\n", cron.Name, cron.Owner, cron.Next)
+	*reply = cron
+	return nil
+}
+
+// ListCrons is called by the client to list the cron jobs that are accessible by the user.
+func (gs *GridSdr) ListCrons(creds *Credentials, reply *[]CronJob) error {
+	user, e := gs.users.Authenticate(*creds)
+	if e != nil {
+		return e
+	}
+	var crons []CronJob
+	for _, cron := range gs.crons.getAll() {
+		if user.CanAccess(Job{Owner: cron.Owner}) {
+			crons = append(crons, cron)
+		}
+	}
+	*reply = crons
+	return nil
+}
+
+// PauseCron is called by the client to pause or resume a cron job, the fire times while it is paused are skipped.
+func (gs *GridSdr) PauseCron(args *CronNameArgs, reply *CronJob) error {
+	if _, e := gs.findUserCron(args.Creds, args.Name); e != nil {
+		return e
+	}
+
+	c := make(chan error)
+	gs.tasks <- func() (interface{}, error) {
+		cron, ok := gs.crons.get(args.Name)
+		if !ok {
+			c <- errorf(errNotFound, "Cron job %v does not exist", args.Name)
+			return 0, nil
+		}
+		if cron.Paused && !args.Paused {
+			// resume from now on
+			cron.Next = cron.next(time.Now())
+		}
+		cron.Paused = args.Paused
+		gs.setCron(cron)
+		*reply = cron
+		c <- nil
+		return 0, nil
+	}
+	return <-c
+}
+
+// DeleteCron is called by the client to delete a cron job, the jobs that it created are not cancelled.
+func (gs *GridSdr) DeleteCron(args *CronNameArgs, reply *int) error {
+	cron, e := gs.findUserCron(args.Creds, args.Name)
+	if e != nil {
+		return e
+	}
+
+	c := make(chan int)
+	gs.tasks <- func() (interface{}, error) {
+		cron.Deleted = true
+		gs.setCron(cron)
+		c <- 0
+		return 0, nil
+	}
+	<-c
+	log.Printf("Cron job %v deleted\n", cron.Name)
+	*reply = 0
+	return nil
+}
+
+// SyncCron is called by another GS to replicate a change of a cron job.
+// NOTE: this function should not be called directly by the client, it requires CS.
+func (gs *GridSdr) SyncCron(cron *CronJob, reply *int) error {
+	gs.crons.set(*cron)
+	*reply = 0
+	return nil
+}
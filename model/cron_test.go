@@ -0,0 +1,112 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 30, 0, time.UTC) // a Thursday
+	at := func(month time.Month, day int, hour int, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		schedule string
+		want     time.Time
+	}{
+		{"*/5 * * * *", at(1, 1, 0, 5)},
+		{"* * * * *", at(1, 1, 0, 1)},
+		{"@daily", at(1, 2, 0, 0)},
+		{"@monthly", at(2, 1, 0, 0)},
+		{"30 12 15 * *", at(1, 15, 12, 30)},
+		{"0 0 * * 1", at(1, 5, 0, 0)},
+		{"0 0 * * 7", at(1, 4, 0, 0)},
+		{"0 0 * * 0", at(1, 4, 0, 0)},
+		// day of month or day of week if both are restricted
+		{"0 0 13 * 5", at(1, 2, 0, 0)},
+		{"0 9-17/4 * * *", at(1, 1, 9, 0)},
+		{"0 20/2 * * *", at(1, 1, 20, 0)},
+		{"15,45 * * * *", at(1, 1, 0, 15)},
+		{"0 0 1 3,6 *", at(3, 1, 0, 0)},
+		{"@every 30s", at(1, 1, 0, 1)},
+		{"@every 1h", at(1, 1, 1, 0)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		s, e := parseCronSchedule(test.schedule)
+		if e != nil {
+			t.Errorf("%q: %v", test.schedule, e)
+			continue
+		}
+		if got := s.next(from); !got.Equal(test.want) {
+			t.Errorf("%q: next is %v, expected %v", test.schedule, got, test.want)
+		}
+	}
+}
+
+func TestParseCronScheduleInvalid(t *testing.T) {
+	for _, s := range []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *",
+		"* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@every 1ms", "@every x", "@hourly x"} {
+		if _, e := parseCronSchedule(s); e == nil {
+			t.Errorf("%q is not a valid schedule", s)
+		}
+	}
+}
+
+func TestCronJobDue(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cron := CronJob{Schedule: "@every 1m", Next: t0}
+	tests := []struct {
+		now     time.Time
+		fire    time.Time
+		skipped int
+	}{
+		{t0.Add(-time.Second), t0, 0},
+		{t0, t0, 0},
+		{t0.Add(5 * time.Minute), t0, 0},
+		{t0.Add(time.Duration(maxCatchUp-1) * time.Minute), t0, 0},
+		{t0.Add(time.Duration(maxCatchUp) * time.Minute), t0.Add(time.Minute), 1},
+		{t0.Add(20 * time.Minute), t0.Add(time.Duration(21-maxCatchUp) * time.Minute), 21 - maxCatchUp},
+	}
+	for _, test := range tests {
+		fire, skipped := cron.due(test.now)
+		if !fire.Equal(test.fire) || skipped != test.skipped {
+			t.Errorf("due at %v: %v and %v skipped, expected %v and %v skipped",
+				test.now.Sub(t0), fire.Sub(t0), skipped, test.fire.Sub(t0), test.skipped)
+		}
+	}
+}
+
+func TestCronJobValidate(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 30, 0, time.UTC)
+	job := Job{Duration: time.Second}
+	tests := []struct {
+		name string
+		cron CronJob
+		ok   bool
+	}{
+		{"valid", CronJob{Name: "backup", Schedule: "@daily", Jobs: []Job{job}}, true},
+		{"no name", CronJob{Schedule: "@daily", Jobs: []Job{job}}, false},
+		{"space in name", CronJob{Name: "a b", Schedule: "@daily", Jobs: []Job{job}}, false},
+		{"policy", CronJob{Name: "c", Schedule: "@daily", Policy: "sometimes", Jobs: []Job{job}}, false},
+		{"no jobs", CronJob{Name: "c", Schedule: "@daily"}, false},
+		{"schedule", CronJob{Name: "c", Schedule: "daily", Jobs: []Job{job}}, false},
+		{"never fires", CronJob{Name: "c", Schedule: "0 0 31 4 *", Jobs: []Job{job}}, false},
+		{"job array", CronJob{Name: "c", Schedule: "@daily", Jobs: []Job{{Array: &ArraySpec{5, 1, 1}}}}, false},
+		{"command and script", CronJob{Name: "c", Schedule: "@daily", Jobs: []Job{{Command: []string{"true"}, Script: "true"}}}, false},
+		{"input outside the shared directory", CronJob{Name: "c", Schedule: "@daily",
+			Jobs: []Job{{Inputs: []StageFile{{"shadow", "shared:../../../etc/shadow"}}}}}, false},
+		{"output outside the working directory", CronJob{Name: "c", Schedule: "@daily",
+			Jobs: []Job{{Outputs: []StageFile{{"../out", "shared:out"}}}}}, false},
+	}
+	for _, test := range tests {
+		cron := test.cron
+		e := cron.validate(now)
+		if (e == nil) != test.ok {
+			t.Errorf("%v: validate returned %v", test.name, e)
+		}
+		if e == nil && (cron.Policy != CronAllow || !cron.Next.Equal(time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC))) {
+			t.Errorf("%v: policy %v and next fire time %v were not set", test.name, cron.Policy, cron.Next)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"net/rpc"
+	"strings"
+)
+
+// errKind classifies the errors of the user facing RPCs, the REST gateway maps it to a HTTP status code
+type errKind int
+
+const (
+	errInternal        errKind = iota // a failure of the grid rather than of the request
+	errInvalid                        // the request is invalid, e.g. a job with both a command and a script
+	errUnauthenticated                // the token is invalid
+	errForbidden                      // the user may not do it, e.g. access a job of another user
+	errNotFound                       // a job or a cron job does not exist
+	errConflict                       // the state of the job does not allow it, e.g. cancelling a finished job
+	errQuota                          // a quota would be exceeded
+	errNotReady                       // the GS is not ready yet
+	errDisabled                       // the feature is disabled on the GS
+)
+
+// kindError is an error of a user facing RPC and its kind. Only the message is sent to the RPC clients,
+// so the kind is only known to the callers in this package, e.g. the REST gateway.
+type kindError struct {
+	kind errKind
+	msg  string
+}
+
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+// errorf formats an error of the given kind
+func errorf(kind errKind, format string, a ...interface{}) error {
+	return &kindError{kind, fmt.Sprintf(format, a...)}
+}
+
+// kindOf returns the kind of e, errors without a kind are errInternal
+func kindOf(e error) errKind {
+	var k *kindError
+	if errors.As(e, &k) {
+		return k.kind
+	}
+	return errInternal
+}
+
+// kindCodes are the names of the kinds in the errors that the public RPCs send to the clients
+var kindCodes = map[errKind]string{
+	errInvalid:         "invalid",
+	errUnauthenticated: "unauthenticated",
+	errForbidden:       "forbidden",
+	errNotFound:        "not_found",
+	errConflict:        "conflict",
+	errQuota:           "quota",
+	errNotReady:        "not_ready",
+	errDisabled:        "disabled",
+}
+
+// encodeError prefixes the message of e with the code of its kind in brackets, e.g. "[not_ready] ...",
+// because net/rpc only sends the message. Errors without a kind are not changed.
+func encodeError(e error) error {
+	code := kindCodes[kindOf(e)]
+	if e == nil || code == "" {
+		return e
+	}
+	return errors.New("[" + code + "] " + e.Error())
+}
+
+// DecodeError restores the kind of an error that a public RPC returned, see encodeError,
+// other errors are returned as they are
+func DecodeError(e error) error {
+	se, ok := e.(rpc.ServerError)
+	if !ok {
+		return e
+	}
+	for kind, code := range kindCodes {
+		if prefix := "[" + code + "] "; strings.HasPrefix(string(se), prefix) {
+			return &kindError{kind, strings.TrimPrefix(string(se), prefix)}
+		}
+	}
+	return e
+}
+
+// IsNotReady checks whether e is the error of a GS that is not ready yet
+func IsNotReady(e error) bool {
+	return kindOf(e) == errNotReady
+}
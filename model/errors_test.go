@@ -0,0 +1,40 @@
+package model
+
+import (
+	"errors"
+	"net/rpc"
+	"testing"
+)
+
+func TestEncodeError(t *testing.T) {
+	tests := []struct {
+		name string
+		e    error
+		kind errKind
+		msg  string // after a round trip through an RPC
+	}{
+		{"not ready", errorf(errNotReady, "Can't add 2 jobs because I'm not ready"), errNotReady, "Can't add 2 jobs because I'm not ready"},
+		{"invalid", errorf(errInvalid, "Invalid count -1"), errInvalid, "Invalid count -1"},
+		{"not found", errorf(errNotFound, "Job 7 does not exist"), errNotFound, "Job 7 does not exist"},
+		// errors without a kind are sent as they are, even if they look like an encoded error
+		{"internal", errors.New("Can't reach the RMs because I'm not ready"), errInternal, "Can't reach the RMs because I'm not ready"},
+		{"unknown code", errors.New("[bad] x"), errInternal, "[bad] x"},
+	}
+	for _, test := range tests {
+		sent := rpc.ServerError(encodeError(test.e).Error())
+		got := DecodeError(sent)
+		if kindOf(got) != test.kind || got.Error() != test.msg {
+			t.Errorf("%v: decoded %v %q, expected %v %q", test.name, kindOf(got), got.Error(), test.kind, test.msg)
+		}
+		if IsNotReady(got) != (test.kind == errNotReady) {
+			t.Errorf("%v: IsNotReady is %v", test.name, IsNotReady(got))
+		}
+	}
+	if encodeError(nil) != nil || DecodeError(nil) != nil {
+		t.Error("nil errors must stay nil")
+	}
+	// only errors of the server are decoded
+	if e := errors.New("[not_ready] x"); DecodeError(e) != e {
+		t.Error("a local error was decoded")
+	}
+}
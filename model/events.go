@@ -0,0 +1,186 @@
+package model
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// event types that are not about jobs
+const (
+	EventLeader   = "leader"    // a new leader was elected, Node is its address
+	EventRMJoined = "rm_joined" // Node is the address of the RM
+	EventRMLost   = "rm_lost"
+)
+
+const (
+	eventLogSize   = 100000 // number of events that a GS remembers
+	eventBatchSize = 1000   // maximum number of events in one reply or replication call
+)
+
+// Event is one entry in the event stream, the sequence numbers are assigned by the leader
+// and are the same on every GS so clients can resume a stream on any GS
+type Event struct {
+	Seq  int64     `json:"seq"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Node string    `json:"node,omitempty"`
+	Job  *Job      `json:"job,omitempty"`
+}
+
+// WatchArgs is the RPC argument for WatchEvents, After is the sequence number of the last event
+// that the client has seen, 0 to start from the oldest event
+type WatchArgs struct {
+	Creds   Credentials
+	After   int64
+	Max     int           // at most eventBatchSize
+	Timeout time.Duration // at most maxWait
+}
+
+// WatchReply is the reply of WatchEvents, clients continue with After set to Last.
+// Truncated is true if events after the requested sequence number were evicted.
+type WatchReply struct {
+	Events    []Event
+	Last      int64
+	Truncated bool
+	TimedOut  bool
+}
+
+// eventLog keeps the most recent events ordered by their sequence number.
+// The leader adds new events and replicates them to the other GSs, see GridSdr.replicateEvents.
+type eventLog struct {
+	sync.Mutex
+	events  []Event
+	next    int64
+	changed chan struct{} // closed and replaced whenever events are added
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{next: 1, changed: make(chan struct{})}
+}
+
+// addJobs adds one event for every job with the next sequence numbers
+func (l *eventLog) addJobs(typ string, jobs []Job) {
+	now := time.Now()
+	events := make([]Event, len(jobs))
+	for i := range jobs {
+		job := jobs[i]
+		events[i] = Event{Type: typ, Time: now, Job: &job}
+	}
+	l.add(events)
+}
+
+// addNode adds an event about a GS or a RM with the next sequence number
+func (l *eventLog) addNode(typ string, node string) {
+	l.add([]Event{{Type: typ, Time: time.Now(), Node: node}})
+}
+
+func (l *eventLog) add(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	for i := range events {
+		events[i].Seq = l.next
+		l.next++
+	}
+	l.appendLocked(events)
+}
+
+// merge appends the replicated events that are newer than the last event in the log,
+// it returns the sequence number of the last event
+func (l *eventLog) merge(events []Event) int64 {
+	l.Lock()
+	defer l.Unlock()
+	var fresh []Event
+	for _, ev := range events {
+		if ev.Seq >= l.next {
+			fresh = append(fresh, ev)
+			l.next = ev.Seq + 1
+		}
+	}
+	if len(fresh) > 0 {
+		l.appendLocked(fresh)
+	}
+	return l.next - 1
+}
+
+func (l *eventLog) appendLocked(events []Event) {
+	l.events = append(l.events, events...)
+	if len(l.events) > eventLogSize {
+		l.events = append([]Event(nil), l.events[len(l.events)-eventLogSize:]...)
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// last returns the sequence number of the last event
+func (l *eventLog) last() int64 {
+	l.Lock()
+	defer l.Unlock()
+	return l.next - 1
+}
+
+// skipTo makes sure that new events get a sequence number after seq,
+// a new leader calls it so that it does not reuse the sequence numbers of the old leader
+func (l *eventLog) skipTo(seq int64) {
+	l.Lock()
+	defer l.Unlock()
+	if seq >= l.next {
+		l.next = seq + 1
+	}
+}
+
+// after returns at most n events with a sequence number after seq,
+// truncated is true if some of them were evicted
+func (l *eventLog) after(seq int64, n int) ([]Event, bool, <-chan struct{}) {
+	l.Lock()
+	defer l.Unlock()
+	i := sort.Search(len(l.events), func(i int) bool { return l.events[i].Seq > seq })
+	truncated := i == 0 && len(l.events) > 0 && l.events[0].Seq > seq+1
+	end := i + n
+	if end > len(l.events) {
+		end = len(l.events)
+	}
+	return append([]Event(nil), l.events[i:end]...), truncated, l.changed
+}
+
+// watch blocks until there are events after args.After that are accessible by the user or until the timeout
+func (l *eventLog) watch(args *WatchArgs, user User, reply *WatchReply) {
+	timeout := args.Timeout
+	if timeout <= 0 || timeout > maxWait {
+		timeout = maxWait
+	}
+	n := args.Max
+	if n <= 0 || n > eventBatchSize {
+		n = eventBatchSize
+	}
+	deadline := time.After(timeout)
+
+	reply.Last = args.After
+	for {
+		events, truncated, changed := l.after(reply.Last, n)
+		reply.Truncated = reply.Truncated || truncated
+		for _, ev := range events {
+			// events about nodes are visible to everybody
+			if ev.Job == nil || user.CanAccess(*ev.Job) {
+				reply.Events = append(reply.Events, ev)
+			}
+			reply.Last = ev.Seq
+		}
+		if len(reply.Events) > 0 {
+			return
+		}
+		if len(events) > 0 {
+			// all of them were filtered, look at the next ones straight away
+			continue
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			reply.TimedOut = true
+			return
+		}
+	}
+}
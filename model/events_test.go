@@ -0,0 +1,201 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// seqs returns the sequence numbers of events
+func seqs(events []Event) []int64 {
+	var res []int64
+	for _, ev := range events {
+		res = append(res, ev.Seq)
+	}
+	return res
+}
+
+func TestEventLogAfter(t *testing.T) {
+	l := newEventLog()
+	l.addJobs(EventQueued, []Job{{ID: 1}, {ID: 2}, {ID: 3}})
+	l.addNode(EventRMJoined, "localhost:5001")
+	l.addJobs(EventCompleted, []Job{{ID: 1}})
+
+	tests := []struct {
+		after     int64
+		n         int
+		want      []int64
+		truncated bool
+	}{
+		{0, 100, []int64{1, 2, 3, 4, 5}, false},
+		{0, 2, []int64{1, 2}, false},
+		{2, 100, []int64{3, 4, 5}, false},
+		{4, 1, []int64{5}, false},
+		{5, 100, nil, false},
+		{42, 100, nil, false},
+	}
+	for _, test := range tests {
+		events, truncated, _ := l.after(test.after, test.n)
+		if !reflect.DeepEqual(seqs(events), test.want) || truncated != test.truncated {
+			t.Errorf("after %v: events %v and truncated %v, expected %v and %v",
+				test.after, seqs(events), truncated, test.want, test.truncated)
+		}
+	}
+	if events, _, _ := l.after(3, 1); events[0].Type != EventRMJoined || events[0].Node != "localhost:5001" || events[0].Job != nil {
+		t.Errorf("unexpected event %+v", events[0])
+	}
+	// the events do not share the jobs
+	if events, _, _ := l.after(0, 3); events[0].Job.ID != 1 || events[1].Job.ID != 2 || events[2].Job.ID != 3 {
+		t.Errorf("the jobs of the events are %v, %v and %v", events[0].Job.ID, events[1].Job.ID, events[2].Job.ID)
+	}
+}
+
+func TestEventLogMerge(t *testing.T) {
+	// the events of two leaders one after the other, replicated in batches that overlap
+	leader1, leader2 := newEventLog(), newEventLog()
+	leader1.addJobs(EventQueued, []Job{{ID: 1}, {ID: 2}, {ID: 3}})
+	old, _, _ := leader1.after(0, 100)
+	leader2.merge(old)
+	// the new leader continues after the events that it may not have received
+	leader2.skipTo(10)
+	leader2.addNode(EventLeader, "localhost:4002")
+	leader2.addJobs(EventScheduled, []Job{{ID: 1}})
+	recent, _, _ := leader2.after(0, 100)
+
+	tests := []struct {
+		name    string
+		batches [][]Event
+		last    int64
+		want    []int64
+	}{
+		{"in order", [][]Event{old, recent[3:]}, 12, []int64{1, 2, 3, 11, 12}},
+		{"overlapping", [][]Event{old[:2], old, recent}, 12, []int64{1, 2, 3, 11, 12}},
+		{"duplicate", [][]Event{recent, recent}, 12, []int64{1, 2, 3, 11, 12}},
+		// older events that arrive late are dropped, the log stays ordered
+		{"late", [][]Event{recent[3:], old}, 12, []int64{11, 12}},
+		{"nothing", [][]Event{nil}, 0, nil},
+	}
+	for _, test := range tests {
+		l := newEventLog()
+		var last int64
+		for _, batch := range test.batches {
+			last = l.merge(batch)
+		}
+		events, _, _ := l.after(0, 100)
+		if last != test.last || !reflect.DeepEqual(seqs(events), test.want) {
+			t.Errorf("%v: last %v and events %v, expected %v and %v", test.name, last, seqs(events), test.last, test.want)
+		}
+		// the events that the GS adds once it is leader come after the merged ones
+		l.addNode(EventLeader, "localhost:4003")
+		if l.last() != test.last+1 {
+			t.Errorf("%v: the next event is %v, expected %v", test.name, l.last(), test.last+1)
+		}
+	}
+}
+
+func TestEventLogSkipTo(t *testing.T) {
+	tests := []struct {
+		added int
+		skip  int64
+		next  int64 // the sequence number of the next event
+	}{
+		{0, 0, 1},
+		{0, 10, 11},
+		{5, 3, 6}, // sequence numbers are never reused
+		{5, 5, 6},
+		{5, 6, 7},
+	}
+	for _, test := range tests {
+		l := newEventLog()
+		for i := 0; i < test.added; i++ {
+			l.addNode(EventRMJoined, "localhost:5001")
+		}
+		l.skipTo(test.skip)
+		l.addNode(EventRMLost, "localhost:5001")
+		if l.last() != test.next {
+			t.Errorf("skipTo(%v) after %v events: the next event is %v, expected %v", test.skip, test.added, l.last(), test.next)
+		}
+	}
+}
+
+func TestEventLogOverflow(t *testing.T) {
+	l := newEventLog()
+	jobs := make([]Job, eventLogSize)
+	l.addJobs(EventQueued, jobs)
+	l.addJobs(EventCompleted, jobs[:10])
+	if len(l.events) != eventLogSize || l.events[0].Seq != 11 || l.last() != eventLogSize+10 {
+		t.Fatalf("%v events from %v to %v", len(l.events), l.events[0].Seq, l.last())
+	}
+
+	tests := []struct {
+		after     int64
+		first     int64
+		truncated bool
+	}{
+		{0, 11, true},
+		{9, 11, true},
+		{10, 11, false}, // the client saw the last evicted event
+		{eventLogSize, eventLogSize + 1, false},
+	}
+	for _, test := range tests {
+		events, truncated, _ := l.after(test.after, 10)
+		if len(events) == 0 || events[0].Seq != test.first || truncated != test.truncated {
+			t.Errorf("after %v: events %v and truncated %v, expected the first %v and %v",
+				test.after, seqs(events), truncated, test.first, test.truncated)
+		}
+	}
+
+	// the truncation is reported to watchers
+	var reply WatchReply
+	l.watch(&WatchArgs{After: 0, Max: 5}, User{Name: "root", Admin: true}, &reply)
+	if !reply.Truncated || len(reply.Events) != 5 || reply.Last != 15 {
+		t.Errorf("watch: %v events, last %v and truncated %v", len(reply.Events), reply.Last, reply.Truncated)
+	}
+}
+
+func TestEventLogWatch(t *testing.T) {
+	l := newEventLog()
+	l.addJobs(EventQueued, []Job{{ID: 1, Owner: "alice"}, {ID: 2, Owner: "bob"}, {ID: 3, Owner: "alice"}})
+	l.addNode(EventRMJoined, "localhost:5001")
+
+	tests := []struct {
+		user  string
+		after int64
+		want  []int64
+		last  int64
+	}{
+		{"alice", 0, []int64{1, 3, 4}, 4},
+		{"bob", 0, []int64{2, 4}, 4},
+		{"bob", 2, []int64{4}, 4},
+		{"carol", 0, []int64{4}, 4},
+	}
+	for _, test := range tests {
+		var reply WatchReply
+		l.watch(&WatchArgs{After: test.after, Timeout: time.Second}, User{Name: test.user}, &reply)
+		if !reflect.DeepEqual(seqs(reply.Events), test.want) || reply.Last != test.last || reply.TimedOut {
+			t.Errorf("%v after %v: events %v, last %v and timed out %v, expected %v and %v",
+				test.user, test.after, seqs(reply.Events), reply.Last, reply.TimedOut, test.want, test.last)
+		}
+	}
+
+	// a watcher without new events waits for them
+	done := make(chan WatchReply)
+	go func() {
+		var reply WatchReply
+		l.watch(&WatchArgs{After: 4, Timeout: 5 * time.Second}, User{Name: "alice"}, &reply)
+		done <- reply
+	}()
+	time.Sleep(50 * time.Millisecond)
+	l.addJobs(EventCompleted, []Job{{ID: 2, Owner: "bob"}}) // not visible to alice
+	l.addJobs(EventCompleted, []Job{{ID: 1, Owner: "alice"}})
+	if reply := <-done; !reflect.DeepEqual(seqs(reply.Events), []int64{6}) || reply.Last != 6 {
+		t.Errorf("the watcher got %v, last %v", seqs(reply.Events), reply.Last)
+	}
+
+	// and times out otherwise
+	var reply WatchReply
+	l.watch(&WatchArgs{After: 6, Timeout: 50 * time.Millisecond}, User{Name: "alice"}, &reply)
+	if !reply.TimedOut || len(reply.Events) != 0 || reply.Last != 6 {
+		t.Errorf("the watcher without events got %+v", reply)
+	}
+}
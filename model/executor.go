@@ -0,0 +1,267 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the names of the executors that every RM has
+const (
+	SleepExecutor   = "sleep"   // sleeps for the duration of the job, the executor of jobs without a command or script
+	ProcessExecutor = "process" // runs the command of the job in the sandbox
+	ScriptExecutor  = "script"  // runs the script of the job in the sandbox
+)
+
+// scriptFile is the name of the script of a job in its scratch directory
+const scriptFile = "job.sh"
+
+// TaskSpec is everything an executor needs to run the task of a job
+type TaskSpec struct {
+	Job     Job
+	Dir     string            // the working directory of the task
+	Env     map[string]string // the environment of the task, it has the variables of the job and those set by the RM
+	Sandbox SandboxConfig
+	Log     func(format string, v ...interface{}) // appends a line to the log of the job
+}
+
+// Executor runs one task, a new executor is created for every task. Wait blocks until the task is finished and returns
+// nil if it succeeded and a LimitError if it exceeded a limit of its sandbox. Kill stops the task, Wait returns after it.
+// Stats is called by the RM at an interval while the task runs and once more when Wait returned, it is not called
+// concurrently and it must not block.
+type Executor interface {
+	Start(spec TaskSpec) error
+	Wait() error
+	Kill()
+	Stats() ExecStats
+}
+
+// Checkpointer is implemented by executors whose tasks can checkpoint, Checkpoint asks the task to write its state
+// to CHECKPOINT_DIR and returns how long the task may take to do so
+type Checkpointer interface {
+	Checkpoint() (time.Duration, error)
+}
+
+// ExecutorFactory creates an executor for a task
+type ExecutorFactory func() Executor
+
+// LimitError is returned by Executor.Wait if the task was stopped because it exceeded a limit
+type LimitError string
+
+func (e LimitError) Error() string {
+	return string(e)
+}
+
+var executors = struct {
+	sync.Mutex
+	m map[string]ExecutorFactory
+}{m: map[string]ExecutorFactory{
+	SleepExecutor:   func() Executor { return &sleepExecutor{} },
+	ProcessExecutor: func() Executor { return &processExecutor{} },
+	ScriptExecutor:  func() Executor { return &scriptExecutor{} },
+}}
+
+// RegisterExecutor adds an executor that jobs and RMs can select by name, it replaces an executor with the same name
+func RegisterExecutor(name string, f ExecutorFactory) {
+	executors.Lock()
+	defer executors.Unlock()
+	executors.m[name] = f
+}
+
+// Executors returns the names of the executors
+func Executors() []string {
+	executors.Lock()
+	defer executors.Unlock()
+	var names []string
+	for name := range executors.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasExecutor checks whether an executor is registered under name
+func HasExecutor(name string) bool {
+	executors.Lock()
+	defer executors.Unlock()
+	_, ok := executors.m[name]
+	return ok
+}
+
+func newExecutor(name string) (Executor, error) {
+	executors.Lock()
+	defer executors.Unlock()
+	f, ok := executors.m[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown executor %v", name)
+	}
+	return f(), nil
+}
+
+// executorName returns the executor of job: the one that the job selects, else the script or process executor
+// if the job has a script or a command, else the default of the RM and the sleep executor otherwise. The default
+// of the RM does not apply to jobs with a script or a command because it may not run them, e.g. sleep.
+func (rm *ResMan) executorName(job Job) string {
+	switch {
+	case job.Executor != "":
+		return job.Executor
+	case job.Script != "":
+		return ScriptExecutor
+	case len(job.Command) > 0:
+		return ProcessExecutor
+	case rm.executor != "":
+		return rm.executor
+	}
+	return SleepExecutor
+}
+
+// validateTask checks that the job does not have both a command and a script,
+// the executor is checked by the RM because a RM may have executors that the GSs don't know
+func (j Job) validateTask() error {
+	if j.Script != "" && len(j.Command) > 0 {
+		return errorf(errInvalid, "A job can't have both a command and a script")
+	}
+	return nil
+}
+
+// sleepExecutor sleeps for the part of the duration of the job that is left according to its checkpoint
+type sleepExecutor struct {
+	ckdir   string
+	slept   time.Duration // before the task was started
+	started time.Time
+	timer   *time.Timer
+	killed  chan struct{}
+	once    sync.Once
+}
+
+func (x *sleepExecutor) Start(spec TaskSpec) error {
+	x.ckdir = spec.Env[CheckpointDirEnv]
+	if x.ckdir != "" {
+		var e error
+		if x.slept, e = readProgress(x.ckdir); e != nil {
+			spec.Log("ignoring checkpoint: %v", e)
+			x.slept = 0
+		}
+	}
+	if x.slept > 0 {
+		spec.Log("resumed after %v, sleeping for %v", x.slept, spec.Job.Duration-x.slept)
+	} else {
+		spec.Log("started, sleeping for %v", spec.Job.Duration)
+	}
+	x.started = time.Now()
+	x.timer = time.NewTimer(spec.Job.Duration - x.slept)
+	x.killed = make(chan struct{})
+	return nil
+}
+
+func (x *sleepExecutor) Wait() error {
+	select {
+	case <-x.timer.C:
+		return nil
+	case <-x.killed:
+		x.timer.Stop()
+		return errors.New("killed")
+	}
+}
+
+func (x *sleepExecutor) Kill() {
+	x.once.Do(func() { close(x.killed) })
+}
+
+func (x *sleepExecutor) Stats() ExecStats {
+	return ExecStats{}
+}
+
+// Checkpoint writes how long the job slept, it is done when it returns
+func (x *sleepExecutor) Checkpoint() (time.Duration, error) {
+	if x.ckdir == "" {
+		return 0, errors.New("no checkpoint directory")
+	}
+	return 0, writeProgress(x.ckdir, x.slept+time.Since(x.started))
+}
+
+// processExecutor runs a command in the sandbox, the duration of the job is its wall time limit
+type processExecutor struct {
+	p        *process
+	timer    *time.Timer
+	timedOut atomic.Bool
+	limit    time.Duration
+}
+
+func (x *processExecutor) Start(spec TaskSpec) error {
+	return x.start(spec, spec.Job.Command)
+}
+
+func (x *processExecutor) start(spec TaskSpec, argv []string) error {
+	if len(argv) == 0 {
+		return errors.New("the job has no command")
+	}
+	p, e := startProcess(spec, argv)
+	if e != nil {
+		return e
+	}
+	x.p = p
+	spec.Log("started %v", strings.Join(argv, " "))
+	if x.limit = spec.Job.Duration; x.limit > 0 {
+		x.timer = time.AfterFunc(x.limit, func() {
+			x.timedOut.Store(true)
+			p.kill()
+		})
+	}
+	return nil
+}
+
+func (x *processExecutor) Wait() error {
+	e := x.p.wait()
+	if x.timer != nil {
+		x.timer.Stop()
+	}
+	x.p.cleanup()
+	if x.timedOut.Load() {
+		return LimitError(fmt.Sprintf("wall time limit of %v exceeded", x.limit))
+	}
+	return x.p.result(e)
+}
+
+func (x *processExecutor) Kill() {
+	x.p.kill()
+}
+
+func (x *processExecutor) Stats() ExecStats {
+	return x.p.stats()
+}
+
+// Checkpoint signals the command, it has checkpointGrace to write its checkpoint
+func (x *processExecutor) Checkpoint() (time.Duration, error) {
+	return checkpointGrace, x.p.checkpoint()
+}
+
+// scriptExecutor runs the script of the job in the sandbox, with the interpreter of its #! line or with /bin/sh
+type scriptExecutor struct {
+	processExecutor
+}
+
+func (x *scriptExecutor) Start(spec TaskSpec) error {
+	if spec.Job.Script == "" {
+		return errors.New("the job has no script")
+	}
+	scratch := spec.Env[ScratchDirEnv]
+	path := filepath.Join(scratch, scriptFile)
+	if e := ioutil.WriteFile(path, []byte(spec.Job.Script), 0700); e != nil {
+		return e
+	}
+	if e := spec.Sandbox.chown(path); e != nil {
+		return e
+	}
+	argv := []string{path}
+	if !strings.HasPrefix(spec.Job.Script, "#!") {
+		argv = []string{"/bin/sh", path}
+	}
+	return x.start(spec, argv)
+}
@@ -0,0 +1,27 @@
+package model
+
+import "testing"
+
+func TestExecutorName(t *testing.T) {
+	tests := []struct {
+		rm   string // default executor of the RM
+		job  Job
+		want string
+	}{
+		{"", Job{}, SleepExecutor},
+		{"", Job{Script: "echo"}, ScriptExecutor},
+		{"", Job{Command: []string{"true"}}, ProcessExecutor},
+		{"", Job{Executor: "custom", Command: []string{"true"}}, "custom"},
+		{"custom", Job{}, "custom"},
+		// the default of the RM only applies to jobs with neither a command nor a script
+		{SleepExecutor, Job{Script: "echo"}, ScriptExecutor},
+		{SleepExecutor, Job{Command: []string{"true"}}, ProcessExecutor},
+		{"custom", Job{Executor: ProcessExecutor, Command: []string{"true"}}, ProcessExecutor},
+	}
+	for i, test := range tests {
+		rm := ResMan{executor: test.rm}
+		if got := rm.executorName(test.job); got != test.want {
+			t.Errorf("test %v: executor is %v, expected %v", i, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SchedPolicy decides the order in which the leader schedules incomingJobs
+type SchedPolicy string
+
+const (
+	PolicyFIFO      SchedPolicy = "fifo"      // first come first served
+	PolicyFairShare SchedPolicy = "fairshare" // users with the lowest usage/share go first
+)
+
+// Usage is the decayed usage in worker-seconds, it is decayed to Updated
+type Usage struct {
+	Value   float64
+	Updated time.Time
+}
+
+// UsageTable is the historical usage of all the users and groups
+type UsageTable struct {
+	Users  map[string]Usage
+	Groups map[string]Usage
+}
+
+// FairShareConfig configures the fair-share policy
+type FairShareConfig struct {
+	HalfLife time.Duration      // usage is halved after every HalfLife
+	Users    map[string]float64 // shares of the users, the default is 1
+	Groups   map[string]float64 // shares of the groups, groups without a share are ignored
+}
+
+// fairShareFile is the on-disk format of FairShareConfig
+type fairShareFile struct {
+	HalfLife string             `json:"half_life"`
+	Users    map[string]float64 `json:"users"`
+	Groups   map[string]float64 `json:"groups"`
+}
+
+// fairShare tracks the usage, it's updated when jobs complete and read by the scheduler
+type fairShare struct {
+	sync.Mutex
+	conf  FairShareConfig
+	usage UsageTable
+}
+
+// LoadFairShareConfig reads the shares from a JSON file, an empty path gives the default config
+func LoadFairShareConfig(path string) (FairShareConfig, error) {
+	conf := FairShareConfig{24 * time.Hour, make(map[string]float64), make(map[string]float64)}
+	if path == "" {
+		return conf, nil
+	}
+
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return conf, e
+	}
+	var f fairShareFile
+	if e := json.Unmarshal(b, &f); e != nil {
+		return conf, fmt.Errorf("failed to parse share file %v: %v", path, e)
+	}
+	if f.HalfLife != "" {
+		if conf.HalfLife, e = time.ParseDuration(f.HalfLife); e != nil || conf.HalfLife <= 0 {
+			return conf, fmt.Errorf("invalid half_life %v in share file %v", f.HalfLife, path)
+		}
+	}
+	for k, v := range f.Users {
+		if v <= 0 {
+			return conf, fmt.Errorf("share of user %v must be positive", k)
+		}
+		conf.Users[k] = v
+	}
+	for k, v := range f.Groups {
+		if v <= 0 {
+			return conf, fmt.Errorf("share of group %v must be positive", k)
+		}
+		conf.Groups[k] = v
+	}
+	log.Printf("Loaded shares for %v users and %v groups from %v\n", len(conf.Users), len(conf.Groups), path)
+	return conf, nil
+}
+
+func newFairShare(conf FairShareConfig) *fairShare {
+	return &fairShare{conf: conf, usage: UsageTable{make(map[string]Usage), make(map[string]Usage)}}
+}
+
+// decay returns the value of u at time t
+func (u Usage) decay(t time.Time, halfLife time.Duration) float64 {
+	if !t.After(u.Updated) {
+		return u.Value
+	}
+	return u.Value * math.Pow(0.5, t.Sub(u.Updated).Seconds()/halfLife.Seconds())
+}
+
+func (fs *fairShare) addTo(m map[string]Usage, k string, secs float64, t time.Time) {
+	m[k] = Usage{m[k].decay(t, fs.conf.HalfLife) + secs, t}
+}
+
+// record charges the run time of a completed job to its owner and group
+func (fs *fairShare) record(job Job) {
+	if job.StartTime.IsZero() || job.FinishTime.Before(job.StartTime) {
+		return
+	}
+	secs := job.FinishTime.Sub(job.StartTime).Seconds()
+	now := time.Now()
+
+	fs.Lock()
+	defer fs.Unlock()
+	fs.addTo(fs.usage.Users, job.Owner, secs, now)
+	if job.Group != "" {
+		fs.addTo(fs.usage.Groups, job.Group, secs, now)
+	}
+}
+
+// get returns a copy of the usage table
+func (fs *fairShare) get() UsageTable {
+	fs.Lock()
+	defer fs.Unlock()
+	t := UsageTable{make(map[string]Usage), make(map[string]Usage)}
+	for k, v := range fs.usage.Users {
+		t.Users[k] = v
+	}
+	for k, v := range fs.usage.Groups {
+		t.Groups[k] = v
+	}
+	return t
+}
+
+// set replaces the usage table, e.g. when copying the state of another GS
+func (fs *fairShare) set(t UsageTable) {
+	fs.Lock()
+	defer fs.Unlock()
+	if t.Users == nil || t.Groups == nil {
+		return
+	}
+	fs.usage = t
+}
+
+// take returns at most n jobs from jobs where the jobs of the least served users come first,
+// jobs of the same user keep their FIFO order. If accept returns false for a job then the
+// remaining jobs of its owner are skipped as well.
+// The expected duration of the running jobs, and of a job as soon as it is taken, is charged
+// to its owner so that the jobs of different users are interleaved.
+func (fs *fairShare) take(jobs []Job, running []Job, n int, accept func(Job) bool) []Job {
+	now := time.Now()
+	fs.Lock()
+	score := make(map[string]float64)
+	groupScore := make(map[string]float64)
+	for _, job := range jobs {
+		if _, ok := score[job.Owner]; !ok {
+			score[job.Owner] = fs.usage.Users[job.Owner].decay(now, fs.conf.HalfLife)
+		}
+		if _, ok := groupScore[job.Group]; !ok && job.Group != "" {
+			groupScore[job.Group] = fs.usage.Groups[job.Group].decay(now, fs.conf.HalfLife)
+		}
+	}
+	conf := fs.conf
+	fs.Unlock()
+
+	for _, job := range running {
+		score[job.Owner] += job.Duration.Seconds()
+		if job.Group != "" {
+			groupScore[job.Group] += job.Duration.Seconds()
+		}
+	}
+
+	priority := func(job Job) float64 {
+		share, ok := conf.Users[job.Owner]
+		if !ok {
+			share = 1
+		}
+		p := score[job.Owner] / share
+		if gshare, ok := conf.Groups[job.Group]; ok {
+			p += groupScore[job.Group] / gshare
+		}
+		return p
+	}
+
+	// split the jobs into per-user FIFO queues
+	var owners []string
+	queues := make(map[string][]Job)
+	for _, job := range jobs {
+		if _, ok := queues[job.Owner]; !ok {
+			owners = append(owners, job.Owner)
+		}
+		queues[job.Owner] = append(queues[job.Owner], job)
+	}
+
+	// repeatedly take the first job of the user with the lowest priority value,
+	// the priority of the jobs themselves goes before the fair share
+	var res []Job
+	for len(res) < n && len(owners) > 0 {
+		sort.SliceStable(owners, func(i, j int) bool {
+			a, b := queues[owners[i]][0], queues[owners[j]][0]
+			if a.Priority != b.Priority {
+				return a.Priority > b.Priority
+			}
+			return priority(a) < priority(b)
+		})
+		owner := owners[0]
+		job := queues[owner][0]
+		queues[owner] = queues[owner][1:]
+		if !accept(job) {
+			owners = owners[1:]
+			continue
+		}
+		res = append(res, job)
+
+		score[job.Owner] += job.Duration.Seconds()
+		if job.Group != "" {
+			groupScore[job.Group] += job.Duration.Seconds()
+		}
+		if len(queues[owner]) == 0 {
+			owners = owners[1:]
+		}
+	}
+	return res
+}
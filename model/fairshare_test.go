@@ -0,0 +1,124 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFairShareTake(t *testing.T) {
+	job := func(id int64, owner string, group string, priority int) Job {
+		return Job{ID: id, Owner: owner, Group: group, Duration: 10 * time.Second, Priority: priority}
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		users   map[string]float64 // shares
+		groups  map[string]float64 // shares
+		usage   map[string]float64 // usage of the users
+		gusage  map[string]float64 // usage of the groups
+		jobs    []Job
+		running []Job
+		n       int
+		refuse  int64 // ID of a job that is not accepted
+		want    []int64
+	}{
+		{
+			name: "interleaved",
+			jobs: []Job{job(1, "a", "", 0), job(2, "a", "", 0), job(3, "b", "", 0)},
+			n:    3,
+			want: []int64{1, 3, 2},
+		},
+		{
+			name:  "least used first",
+			usage: map[string]float64{"a": 100},
+			jobs:  []Job{job(1, "a", "", 0), job(2, "a", "", 0), job(3, "b", "", 0), job(4, "b", "", 0)},
+			n:     4,
+			want:  []int64{3, 4, 1, 2},
+		},
+		{
+			name:  "usage divided by the share",
+			users: map[string]float64{"a": 4},
+			usage: map[string]float64{"a": 100, "b": 50},
+			jobs:  []Job{job(1, "b", "", 0), job(2, "a", "", 0)},
+			n:     2,
+			want:  []int64{2, 1},
+		},
+		{
+			name:   "group usage",
+			groups: map[string]float64{"g": 1},
+			gusage: map[string]float64{"g": 100},
+			jobs:   []Job{job(1, "a", "g", 0), job(2, "b", "h", 0)},
+			n:      2,
+			want:   []int64{2, 1},
+		},
+		{
+			name:  "priority before fair share",
+			usage: map[string]float64{"a": 100},
+			jobs:  []Job{job(1, "b", "", 0), job(2, "a", "", 1)},
+			n:     2,
+			want:  []int64{2, 1},
+		},
+		{
+			name:    "running jobs are charged",
+			jobs:    []Job{job(1, "a", "", 0), job(2, "b", "", 0)},
+			running: []Job{job(3, "a", "", 0)},
+			n:       2,
+			want:    []int64{2, 1},
+		},
+		{
+			name: "at most n",
+			jobs: []Job{job(1, "a", "", 0), job(2, "a", "", 0), job(3, "b", "", 0)},
+			n:    2,
+			want: []int64{1, 3},
+		},
+		{
+			name:   "refused job skips its owner",
+			jobs:   []Job{job(1, "a", "", 0), job(2, "a", "", 0), job(3, "b", "", 0)},
+			n:      3,
+			refuse: 1,
+			want:   []int64{3},
+		},
+	}
+	for _, test := range tests {
+		conf := FairShareConfig{time.Hour, test.users, test.groups}
+		if conf.Users == nil {
+			conf.Users = make(map[string]float64)
+		}
+		if conf.Groups == nil {
+			conf.Groups = make(map[string]float64)
+		}
+		fs := newFairShare(conf)
+		for k, v := range test.usage {
+			fs.usage.Users[k] = Usage{v, now}
+		}
+		for k, v := range test.gusage {
+			fs.usage.Groups[k] = Usage{v, now}
+		}
+
+		res := fs.take(test.jobs, test.running, test.n, func(j Job) bool { return j.ID != test.refuse })
+		if got := jobIDs(res); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: took %v, expected %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestUsageDecay(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		usage Usage
+		at    time.Time
+		want  float64
+	}{
+		{Usage{100, now}, now, 100},
+		{Usage{100, now}, now.Add(-time.Hour), 100},
+		{Usage{100, now}, now.Add(time.Hour), 50},
+		{Usage{100, now}, now.Add(2 * time.Hour), 25},
+	}
+	for _, test := range tests {
+		if got := test.usage.decay(test.at, time.Hour); got != test.want {
+			t.Errorf("decay of %v at %v: got %v, expected %v", test.usage.Value, test.at.Sub(now), got, test.want)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/rpc"
+	"sort"
 	"sync"
 	"time"
 )
@@ -17,15 +18,15 @@ import (
 // GridSdr describes the properties of one grid scheduler
 type GridSdr struct {
 	common.Node
-	gsNodes             *common.SyncedSet // other grid schedulers, not including myself
-	rmNodes             *common.SyncedSet // the resource managers
-	leader              string            // the lead grid scheduler
-	incomingJobAddChan  chan Job          // when user adds a job, it comes here
-	incomingJobRmChan   chan int
+	gsNodes             *common.Membership // other grid schedulers, not including myself
+	rmNodes             *common.Membership // the resource managers
+	leaderID            string             // the UUID of the lead grid scheduler
+	incomingJobAddChan  chan Job           // when user adds a job, it comes here
+	incomingJobRmChan   chan []int64
 	incomingJobReqChan  chan chan Job
 	incomingJobs        []Job            // only accessible in the incomingJobs select statement
 	scheduledJobAddChan chan Job         // channel for new scheduled jobs
-	scheduledJobRmChan  chan int64       // channel for removing jobs that are completed
+	scheduledJobRmChan  chan Job         // channel for removing jobs that are completed
 	scheduledJobReqChan chan chan Job    // channel inside a channel to sync with new GS when it's online
 	scheduledJobs       map[int64]Job    // only accessible in the scheduleJobs select statement
 	tasks               chan common.Task // these tasks require critical section (CS)
@@ -37,14 +38,85 @@ type GridSdr struct {
 	reqClock            int64
 	discosrvAddr        string
 	ready               *common.SyncedVal
+	users               *UserTable // nil if authentication is disabled
+	quotas              *syncedQuotas
+	policy              SchedPolicy
+	fairShare           *fairShare
+	jobIDs              *jobIDGen
+	history             *jobHistory // finished jobs
+	webhooks            *webhooks   // only the leader sends events
+	events              *eventLog   // replicated from the leader
+	timings             common.Timings
+	csRTT               *common.RTTEstimator // response times of the CS requests, used with AdaptiveCS
+	bindAddr            string               // the address that the RPC server listens on
+	crons               *cronTable           // replicated, fired by the leader
+	preempt             PreemptPolicy
+	accounting          *AccountingDB // the records of the finished jobs, nil if accounting is disabled
+	lease               *leaderLease  // the term of the leader and its lease
+}
+
+// GridSdrAPI is the RPCs of a GS that are served to every client, the other RPCs are only served to the nodes
+// that present the cluster secret, see common.LoadClusterSecret
+type GridSdrAPI interface {
+	AddJobsViaUser(args *UserJobsArgs, reply *SubmitReply) error
+	JobStatus(args *UserIDsArgs, reply *[]Job) error
+	ListJobs(creds *Credentials, reply *[]Job) error
+	GetQueue(creds *Credentials, reply *[]QueueEntry) error
+	GetLeader(x *int, reply *string) error
+	GetIdentity(x *int, reply *common.Node) error
+	GetNodes(x *int, reply *[]NodeInfo) error
+	CancelJobs(args *UserIDsArgs, reply *int) error
+	WaitJobs(args *WaitArgs, reply *WaitReply) error
+	JobLogs(args *UserIDsArgs, reply *map[int64][]string) error
+	SetQuota(args *QuotaArgs, reply *int) error
+	GetQuotas(creds *Credentials, reply *QuotaTable) error
+	GetDeliveries(creds *Credentials, reply *[]Delivery) error
+	WatchEvents(args *WatchArgs, reply *WatchReply) error
+	Report(args *ReportArgs, reply *[]ReportRow) error
+	CreateCron(args *CronArgs, reply *CronJob) error
+	ListCrons(creds *Credentials, reply *[]CronJob) error
+	PauseCron(args *CronNameArgs, reply *CronJob) error
+	DeleteCron(args *CronNameArgs, reply *int) error
 }
 
 // RPCArgs is the arguments for RPC calls between grid schedulers and/or resource maanagers
 type RPCArgs struct {
-	ID    int
-	Addr  string
-	Type  common.MsgType
-	Clock int64
+	ID     int
+	Addr   string
+	Type   common.MsgType
+	Clock  int64
+	Addrs  []string // all the advertised addresses of the sender
+	UUID   string
+	Term   int64             // the term of the leader in coordinator messages and lease requests
+	Labels map[string]string // the labels of the sender
+}
+
+// node returns the sender of the message, t is its type
+func (a *RPCArgs) node(t common.NodeType) common.Node {
+	addrs := a.Addrs
+	if len(addrs) == 0 {
+		addrs = []string{a.Addr}
+	}
+	return common.Node{ID: a.ID, Addr: a.Addr, Type: t, Addrs: addrs, UUID: a.UUID, Labels: a.Labels}
+}
+
+// QueueEntry is the number of jobs of one user in each state
+type QueueEntry struct {
+	User     string
+	Queued   int
+	Held     int
+	Deferred int
+	Running  int
+}
+
+// NodeInfo describes a GS or a RM, Capacity is the number of free workers, -1 for GSs or offline RMs
+type NodeInfo struct {
+	common.Node
+	Leader   bool
+	Capacity int
+	Workers  int       // 0 for GSs and offline RMs
+	Running  int       // number of running tasks on a RM
+	Usage    ExecStats // the resource usage of the running tasks on a RM
 }
 
 // GridSdrState is an RPC argument for synchronising states when GS first start up
@@ -52,26 +124,34 @@ type GridSdrState struct {
 	IncomingJobs  []Job
 	ScheduledJobs []Job
 	Clock         int64
+	Quotas        QuotaTable
+	Usage         UsageTable
+	JobSeq        int64
+	Submissions   map[string]Submission
+	History       []Job
+	Crons         []CronJob
 }
 
-// InitGridSdr creates a grid scheduler.
-func InitGridSdr(id int, addr string, dsAddr string) GridSdr {
+// InitGridSdr creates a grid scheduler, addrs are the advertised addresses and the first one is the main address,
+// labels are shown with the node and bindAddr is the address to listen on.
+func InitGridSdr(id int, uuid string, addrs []string, labels map[string]string, bindAddr string, dsAddr string, users *UserTable, quotas QuotaTable,
+	policy SchedPolicy, shares FairShareConfig, preempt PreemptPolicy, callbacks WebhookConfig, accounting *AccountingDB, timings common.Timings) GridSdr {
 	// NOTE: the following three values are initiated in `Run`
-	gsNodes := &common.SyncedSet{S: make(map[string]common.IntClient)}
-	rmNodes := &common.SyncedSet{S: make(map[string]common.IntClient)}
-	var leader string
+	gsNodes := common.NewMembership()
+	rmNodes := common.NewMembership()
+	var leaderID string
 
 	return GridSdr{
-		common.Node{id, addr, common.GSNode},
+		common.Node{ID: id, Addr: addrs[0], Type: common.GSNode, Addrs: addrs, UUID: uuid, Labels: labels},
 		gsNodes,
 		rmNodes,
-		leader,
+		leaderID,
 		make(chan Job, 1000000),
-		make(chan int),
+		make(chan []int64, 1000),
 		make(chan chan Job),
 		make([]Job, 0),
 		make(chan Job, 1000000),
-		make(chan int64),
+		make(chan Job),
 		make(chan chan Job),
 		make(map[int64]Job),
 		make(chan common.Task, 100),
@@ -83,27 +163,54 @@ func InitGridSdr(id int, addr string, dsAddr string) GridSdr {
 		0,
 		dsAddr,
 		&common.SyncedVal{V: false},
+		users,
+		&syncedQuotas{t: quotas},
+		policy,
+		newFairShare(shares),
+		newJobIDGen(id),
+		newJobHistory(),
+		newWebhooks(callbacks),
+		newEventLog(),
+		timings,
+		common.NewRTTEstimator(timings.CSTimeout, timings.MaxCSTimeout),
+		bindAddr,
+		newCronTable(),
+		preempt,
+		accounting,
+		&leaderLease{},
 	}
 }
 
 // Run is the main function for GridSdr, it starts all its services, do not run it more than once.
 func (gs *GridSdr) Run() {
 	// populate my list of GSs and RMs
-	reply, e := discosrv.ImAliveProbe(gs.Addr, gs.Type, gs.discosrvAddr)
-	if e != nil {
+	reply, e := discosrv.ImAliveProbe(gs.Node, gs.discosrvAddr)
+	if _, ok := e.(rpc.ServerError); ok {
+		log.Fatalf("Discosrv on %v refused me: %v\n", gs.discosrvAddr, e)
+	} else if e != nil {
 		log.Panicf("Discosrv on %v not online\n", gs.discosrvAddr)
 	}
-	gs.notifyAndPopulateGSs(reply.GSs)
-	gs.notifyAndPopulateRMs(reply.RMs)
+	if e := gs.notifyAndPopulateGSs(reply.GSNodes); e != nil {
+		log.Fatal(e)
+	}
+	if e := gs.notifyAndPopulateRMs(reply.RMNodes); e != nil {
+		log.Fatal(e)
+	}
 
 	// start all the go routines, order doesn't matter,
 	// note that some may not have an effect until the GS is ready
 	go gs.pollLeader()
+	go gs.renewLease()
 	go gs.runTasks()
-	go discosrv.ImAlivePoll(gs.Addr, gs.Type, gs.discosrvAddr)
+	go discosrv.ImAlivePoll(gs.Node, gs.discosrvAddr, gs.timings.Heartbeat)
 	go gs.updateScheduledJobs()
 	go gs.scheduleJobs()
-	go common.RunRPC(gs, gs.Addr)
+	go gs.webhooks.run()
+	go gs.replicateEvents()
+	go gs.watchRMs()
+	go gs.runCrons()
+	gs.serveREST()
+	go common.RunRPC("GridSdr", gs, &publicGridSdr{gs}, gs.bindAddr)
 
 	gs.updateState()
 	gs.ready.Set(true)
@@ -113,13 +220,28 @@ func (gs *GridSdr) Run() {
 	}
 }
 
+// recordFinished adds a finished job to the history and emits its event. When the last task of a job array
+// finished then the array is recorded too and it is dropped from incomingJobs.
+func (gs *GridSdr) recordFinished(job Job) {
+	if !gs.history.add(job) {
+		return
+	}
+	gs.accounting.record(job)
+	gs.emitEvent(eventFor(job.State), []Job{job})
+	if array, ok := gs.history.finishArray(job); ok {
+		gs.emitEvent(eventFor(array.State), []Job{array})
+		// NOTE: this runs in the updateScheduledJobs select statement which must not wait for scheduleJobs
+		go func() { gs.incomingJobRmChan <- []int64{array.ID} }()
+	}
+}
+
 func (gs *GridSdr) updateScheduledJobs() {
 	totalDuration := float64(0)
 	totalWaitingTime := float64(0)
 	totalCompletedJobs := 0
 	reportingTimeout := time.After(5 * time.Second)
 	for {
-		timeout := time.After(100 * time.Millisecond)
+		timeout := time.After(gs.timings.Tick)
 
 		select {
 		case <-timeout:
@@ -132,7 +254,7 @@ func (gs *GridSdr) updateScheduledJobs() {
 			rms := gs.getAliveRMs()
 			var toBeRescheduled []Job
 			for _, v := range gs.scheduledJobs {
-				if _, ok := rms[v.ResMan]; !ok {
+				if _, ok := rms[v.ResManID]; !ok {
 					toBeRescheduled = append(toBeRescheduled, v)
 				}
 			}
@@ -148,16 +270,43 @@ func (gs *GridSdr) updateScheduledJobs() {
 			reportingTimeout = time.After(5 * time.Second)
 
 		case job := <-gs.scheduledJobAddChan:
-			gs.scheduledJobs[job.ID] = job
+			gs.addScheduledJob(job)
 
-		case id := <-gs.scheduledJobRmChan:
-			dur := time.Now().Sub(gs.scheduledJobs[id].StartTime).Seconds()
+		case done := <-gs.scheduledJobRmChan:
+			// the job may have been added just before it finished
+			for _, job := range takeJobs(1000000, gs.scheduledJobAddChan) {
+				gs.addScheduledJob(job)
+			}
+			id := done.ID
+			job, ok := gs.scheduledJobs[id]
+			if !ok {
+				// already removed, e.g. cancelled by the user, or it was never scheduled
+				gs.recordFinished(done)
+				gs.accounting.amend(done)
+				break
+			}
+			if !done.FinishTime.IsZero() {
+				// the RM reports when the job actually ran
+				job.StartTime, job.FinishTime = done.StartTime, done.FinishTime
+				gs.fairShare.record(job)
+			}
+			// rescheduled and preempted jobs are not in a terminal state so they are not added
+			job.State, job.Error, job.Reason, job.Usage = done.State, done.Error, done.Reason, done.Usage
+			gs.recordFinished(job)
+			if done.Preempted {
+				gs.emitEvent(EventPreempted, []Job{job})
+			}
+			dur := time.Now().Sub(job.StartTime).Seconds()
 			totalDuration += dur
-			totalWaitingTime += (dur - gs.scheduledJobs[id].Duration.Seconds())
+			totalWaitingTime += (dur - job.Duration.Seconds())
 			totalCompletedJobs++
 			delete(gs.scheduledJobs, id)
 
 		case c := <-gs.scheduledJobReqChan:
+			// the copy has every job that was added before the request
+			for _, job := range takeJobs(1000000, gs.scheduledJobAddChan) {
+				gs.addScheduledJob(job)
+			}
 			for _, j := range gs.scheduledJobs {
 				c <- j
 			}
@@ -166,10 +315,23 @@ func (gs *GridSdr) updateScheduledJobs() {
 	}
 }
 
+// addScheduledJob puts job in scheduledJobs, a task may be scheduled while its job array is cancelled,
+// the leader stops it then.
+// NOTE: it must run in the updateScheduledJobs select statement.
+func (gs *GridSdr) addScheduledJob(job Job) {
+	gs.scheduledJobs[job.ID] = job
+	if job.ArrayID != 0 && gs.imLeader() {
+		if arrays, _ := gs.history.find([]int64{job.ArrayID}); len(arrays) > 0 && arrays[0].State == JobCancelled {
+			go rpcCancelJobsOnRM(gs.rmAddr(job), &[]int64{job.ID})
+		}
+	}
+}
+
 func (gs *GridSdr) scheduleJobs() {
+	preempting := make(map[int64]time.Time) // the jobs that the RMs were asked to preempt
 	for {
-		// schedule jobs if there are any, for every 100ms
-		timeout := time.After(100 * time.Millisecond)
+		// schedule jobs if there are any, for every tick
+		timeout := time.After(gs.timings.Tick)
 		select {
 		case <-timeout:
 			// try again later if I'm not leader
@@ -183,33 +345,36 @@ func (gs *GridSdr) scheduleJobs() {
 			}
 
 			// try again later if no free RMs
-			addr, cap := gs.getNextFreeRM()
-			if cap == -1 || addr == "" {
+			rm, cap := gs.getNextFreeRM()
+			addr, ok := gs.rmNodes.Addr(rm)
+			if cap == -1 || !ok {
+				// all the workers are busy, make room for the urgent jobs
+				gs.preemptJobs(preempting)
 				break
 			}
 
-			minCap := common.MinInt(cap, len(gs.incomingJobs))
-			jobs := gs.incomingJobs[0:minCap]
+			// try again later if all the jobs are held by quotas
+			jobs := gs.takeJobsWithinQuota(cap)
+			if len(jobs) == 0 {
+				break
+			}
 			for i := range jobs {
-				jobs[i].ResMan = addr
+				jobs[i].ResMan, jobs[i].ResManID = addr, rm
 			}
 			gs.runJobsAsTask(jobs, addr) // this function blocks util the task finishes executing
 
 		case job := <-gs.incomingJobAddChan:
-			// take all the jobs in the channel and put them in the slice
-			rest := takeJobs(1000000, gs.incomingJobAddChan)
 			gs.incomingJobs = append(gs.incomingJobs, job)
-			gs.incomingJobs = append(gs.incomingJobs, rest...)
-
-		case n := <-gs.incomingJobRmChan:
-			if n > len(gs.incomingJobs) {
-				log.Printf("Dropping %v jobs when there are only %v available.", n, len(gs.incomingJobs))
-				gs.incomingJobs = make([]Job, 0)
-			} else {
-				gs.incomingJobs = gs.incomingJobs[n:]
-			}
+			gs.addIncomingJobs()
+
+		case ids := <-gs.incomingJobRmChan:
+			// the jobs may have been added just before they are dropped
+			gs.addIncomingJobs()
+			gs.incomingJobs = dropJobs(gs.incomingJobs, ids)
 
 		case c := <-gs.incomingJobReqChan:
+			// the copy has every job that was added before the request, e.g. the jobs that a user just submitted
+			gs.addIncomingJobs()
 			for _, j := range gs.incomingJobs {
 				c <- j
 			}
@@ -218,12 +383,54 @@ func (gs *GridSdr) scheduleJobs() {
 	}
 }
 
-func (gs *GridSdr) getAliveRMs() map[string]common.IntClient {
-	res := make(map[string]common.IntClient)
-	for k, v := range gs.rmNodes.GetAll() {
-		remote, e := rpc.DialHTTP("tcp", k)
+// addIncomingJobs takes all the jobs in incomingJobAddChan and puts them in incomingJobs.
+// NOTE: it must run in the scheduleJobs select statement.
+func (gs *GridSdr) addIncomingJobs() {
+	gs.incomingJobs = append(gs.incomingJobs, takeJobs(1000000, gs.incomingJobAddChan)...)
+}
+
+// takeJobsWithinQuota returns a copy of at most n jobs from incomingJobs in the order of the scheduling policy,
+// jobs that would exceed the running quota of their owner or group and jobs before their NotBefore time are skipped.
+// NOTE: it must run in the scheduleJobs select statement.
+func (gs *GridSdr) takeJobsWithinQuota(n int) []Job {
+	quotas := gs.quotas.get()
+	scheduled := gs.getScheduledJobs()
+	running := countJobs(scheduled)
+	accept := func(job Job) bool {
+		if quotas.exceedsRunning(job, running) {
+			return false
+		}
+		running.add(job)
+		return true
+	}
+
+	// job arrays are replaced by the tasks that may be taken, the jobs before their NotBefore time stay in the queue
+	now := time.Now()
+	incoming := filterJobs(expandArrays(gs.incomingJobs, n), func(j Job) bool { return j.eligible(now) })
+	byPriority(incoming)
+	if gs.policy == PolicyFairShare {
+		return gs.fairShare.take(incoming, scheduled, n, accept)
+	}
+
+	var jobs []Job
+	for _, job := range incoming {
+		if len(jobs) >= n {
+			break
+		}
+		if accept(job) {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// getAliveRMs returns the addresses of the RMs that are online by their UUID
+func (gs *GridSdr) getAliveRMs() map[string]string {
+	res := make(map[string]string)
+	for _, m := range gs.rmNodes.Members() {
+		remote, e := common.DialRPC(m.Addr)
 		if e == nil {
-			res[k] = v
+			res[m.UUID] = m.Addr
 			remote.Close()
 		}
 	}
@@ -236,8 +443,25 @@ func (gs *GridSdr) getAliveRMs() map[string]common.IntClient {
 func (gs *GridSdr) runJobsAsTask(jobs []Job, rmAddr string) {
 	c := make(chan int)
 	gs.tasks <- func() (interface{}, error) {
+		// the lease may have ended while waiting for the CS, the jobs stay in incomingJobs
+		term, held := gs.lease.held()
+		if !held {
+			log.Printf("Not scheduling %v jobs, I don't hold the lease of term %v\n", len(jobs), term)
+			c <- 0
+			return 0, nil
+		}
+		for i := range jobs {
+			jobs[i].Term = term
+		}
+
 		// send the job to RM
 		reply, e := rpcAddJobsToRM(rmAddr, &jobs)
+		if _, ok := e.(rpc.ServerError); ok {
+			// the RM refused the jobs because a leader of a later term sent jobs to it
+			log.Printf("RM %v refused %v jobs: %v\n", rmAddr, len(jobs), e)
+			c <- 0
+			return 0, nil
+		}
 
 		// add jobs to the submitted list for all GSs to myself
 		var dummy int
@@ -247,15 +471,10 @@ func (gs *GridSdr) runJobsAsTask(jobs []Job, rmAddr string) {
 
 		// remove jobs from the incomingJobs list for myself
 		// note that we can't write to the incomingJobRmChan because this functions runs in the incomingJob* select statement
-		n := len(jobs)
-		if n > len(gs.incomingJobs) {
-			log.Printf("Dropping %v jobs when there are only %v available.", n, len(gs.incomingJobs))
-			gs.incomingJobs = make([]Job, 0)
-		} else {
-			gs.incomingJobs = gs.incomingJobs[n:]
-		}
-		// and for others
-		rpcIntGo(common.SliceFromMap(gs.gsNodes.GetAll()), n, rpcDropJobs)
+		ids := jobIDs(jobs)
+		gs.incomingJobs = dropJobs(gs.incomingJobs, ids)
+		// and for others, jobs are dropped by ID because users may cancel jobs in the middle of the queue
+		rpcInt64sGo(common.SliceFromMap(gs.gsNodes.GetAll()), &ids, rpcDropJobs)
 
 		c <- 0
 		return reply, e
@@ -269,19 +488,23 @@ func (gs *GridSdr) rescheduleJobsAsTask(jobs []Job) {
 	c := make(chan int)
 	gs.tasks <- func() (interface{}, error) {
 		// remove it from scheduled jobs for myself
-		ids := make([]int64, len(jobs))
-		for i, job := range jobs {
+		for _, job := range jobs {
 			delete(gs.scheduledJobs, job.ID)
-			ids[i] = job.ID
 		}
 		// and for others
-		rpcInt64sGo(common.SliceFromMap(gs.gsNodes.GetAll()), &ids, rpcRemoveCompletedJobs)
-
-		// add back to incoming list for myself
-		for _, job := range jobs {
-			job.ResMan = ""
-			gs.incomingJobAddChan <- job
+		rpcJobsGo(common.SliceFromMap(gs.gsNodes.GetAll()), &jobs, rpcRemoveCompletedJobs)
+
+		// add back to incoming list for myself, the jobs wait longer after every reschedule
+		now := time.Now()
+		for i := range jobs {
+			jobs[i].ResMan, jobs[i].ResManID = "", ""
+			jobs[i].Reschedules++
+			if t := now.Add(backoff(jobs[i].Reschedules)); t.After(jobs[i].NotBefore) {
+				jobs[i].NotBefore = t
+			}
+			gs.incomingJobAddChan <- jobs[i]
 		}
+		gs.emitEvent(EventRescheduled, jobs)
 		// and for others
 		rpcJobsGo(common.SliceFromMap(gs.gsNodes.GetAll()), &jobs, rpcSyncJobs)
 
@@ -293,27 +516,53 @@ func (gs *GridSdr) rescheduleJobsAsTask(jobs []Job) {
 
 // rpcArgsForGS sets default values for GS
 func (gs *GridSdr) rpcArgsForGS(msgType common.MsgType) RPCArgs {
-	return RPCArgs{gs.ID, gs.Addr, msgType, gs.clock.Geti64()}
+	return RPCArgs{ID: gs.ID, Addr: gs.Addr, Type: msgType, Clock: gs.clock.Geti64(), Addrs: gs.Addrs, UUID: gs.UUID, Labels: gs.Labels}
+}
+
+// peerAddr returns the address that is used to reach the GS that sent args
+func (gs *GridSdr) peerAddr(args *RPCArgs) string {
+	if addr, ok := gs.gsNodes.Addr(args.UUID); ok {
+		return addr
+	}
+	return args.Addr
+}
+
+// leaderAddr returns the address of the leader, it is empty if the leader is unknown
+func (gs *GridSdr) leaderAddr() string {
+	if gs.leaderID == gs.UUID {
+		return gs.Addr
+	}
+	addr, _ := gs.gsNodes.Addr(gs.leaderID)
+	return addr
+}
+
+// rmAddr returns the address of the RM that job is bound to
+func (gs *GridSdr) rmAddr(job Job) string {
+	if addr, ok := gs.rmNodes.Addr(job.ResManID); ok {
+		return addr
+	}
+	return job.ResMan
 }
 
+// getNextFreeRM returns the UUID of a RM with free workers
 // NOTE: there are various ways to improve this function, i.e. get the the RM with highest number of free workers
 func (gs *GridSdr) getNextFreeRM() (string, int) {
 	caps := gs.getRMCapacities()
 	for k, v := range caps {
-		if v > 0 {
-			return k, int(v)
+		if v.Free > 0 {
+			return k, v.Free
 		}
 	}
 	return "", -1
 }
 
-func (gs *GridSdr) getRMCapacities() map[string]int64 {
-	capacities := make(map[string]int64)
-	args := gs.rpcArgsForGS(common.GetCapacityMsg)
-	for k := range gs.rmNodes.GetAll() {
-		x, e := rpcSendMsgToRM(k, &args)
+// getRMCapacities returns the free capacity and the resource usage of the online RMs by their UUID
+func (gs *GridSdr) getRMCapacities() map[string]RMCapacity {
+	capacities := make(map[string]RMCapacity)
+	for _, m := range gs.rmNodes.Members() {
+		c, e := rpcGetCapacityFromRM(m.Addr)
 		if e == nil {
-			capacities[k] = int64(x)
+			capacities[m.UUID] = c
 		}
 	}
 	return capacities
@@ -341,36 +590,66 @@ func (gs *GridSdr) updateState() {
 	<-c
 }
 
-func (gs *GridSdr) notifyAndPopulateGSs(nodes []string) {
+// notifyAndPopulateGSs tells the GSs that I'm online, the first advertised address that works is used for each GS.
+// It returns an error if a GS refused me, e.g. because my ID is taken.
+func (gs *GridSdr) notifyAndPopulateGSs(nodes []common.Node) error {
 	args := gs.rpcArgsForGS(common.GSUpMsg)
 	wg := sync.WaitGroup{}
+	refused := &common.SyncedVal{}
 	for _, node := range nodes {
+		if node.UUID == gs.UUID {
+			continue
+		}
 		wg.Add(1)
-		go func(addr string) {
+		go func(node common.Node) {
 			defer wg.Done()
-			id, e := rpcSendMsgToGS(addr, &args)
-			if e == nil {
-				gs.gsNodes.SetInt(addr, int64(id))
+			addr, e := common.PickAddr(node.Addrs)
+			if e != nil {
+				log.Println(e)
+				return
+			}
+			_, e = rpcSendMsgToGS(addr, &args)
+			if _, ok := e.(rpc.ServerError); ok {
+				refused.Set(e)
+			} else if e == nil {
+				gs.gsNodes.Join(node, addr)
 			}
 		}(node)
 	}
 	wg.Wait()
+	if e, ok := refused.Get().(error); ok {
+		return e
+	}
+	return nil
 }
 
-func (gs *GridSdr) notifyAndPopulateRMs(nodes []string) {
+// notifyAndPopulateRMs is notifyAndPopulateGSs for RMs
+func (gs *GridSdr) notifyAndPopulateRMs(nodes []common.Node) error {
 	args := gs.rpcArgsForGS(common.RMUpMsg)
 	wg := sync.WaitGroup{}
+	refused := &common.SyncedVal{}
 	for _, node := range nodes {
 		wg.Add(1)
-		go func(addr string) {
+		go func(node common.Node) {
 			defer wg.Done()
-			id, e := rpcSendMsgToRM(addr, &args)
-			if e == nil {
-				gs.rmNodes.SetInt(addr, int64(id))
+			addr, e := common.PickAddr(node.Addrs)
+			if e != nil {
+				log.Println(e)
+				return
+			}
+			_, e = rpcSendMsgToRM(addr, &args)
+			if _, ok := e.(rpc.ServerError); ok {
+				refused.Set(e)
+			} else if e == nil {
+				gs.rmNodes.Join(node, addr)
 			}
 		}(node)
 	}
 	wg.Wait()
+	if e, ok := refused.Get().(error); ok {
+		return e
+	}
+	return nil
 }
 
 // obtainCritSection implements most of the Ricart-Agrawala algorithm, it sends the critical section request and then wait for responses until some timeout.
@@ -400,12 +679,14 @@ func (gs *GridSdr) obtainCritSection() {
 	gs.clock.Tick()
 	args := gs.rpcArgsForGS(common.MutexReq)
 	addrs := common.SliceFromMap(gs.gsNodes.GetAll())
+	start := time.Now()
 	successes := rpcGo(addrs, &args, rpcSendMsgToGS)
 	gs.reqClock = gs.clock.Geti64()
 
-	// wait until others has written to mutexRespChan or time out (5s)
+	// wait until others has written to mutexRespChan or time out
 	cnt := 0
-	timeout := time.After(5 * time.Second)
+	wait := gs.csTimeout()
+	timeout := time.After(wait)
 loop2:
 	for {
 		if cnt >= successes {
@@ -414,8 +695,11 @@ loop2:
 		select {
 		case <-gs.mutexRespChan:
 			cnt++
+			gs.csRTT.Observe(time.Since(start))
 		case <-timeout:
-			log.Printf("CS Timeout!")
+			log.Printf("CS Timeout after %v!", wait)
+			// back off, the responses are slower than expected
+			gs.csRTT.Observe(2 * wait)
 			break loop2
 		}
 	}
@@ -429,6 +713,17 @@ loop2:
 	log.Println("CS In!")
 }
 
+// csTimeout returns how long obtainCritSection waits for the responses. With AdaptiveCS it is derived from
+// the response times of the previous requests, which include the time that the other GSs held the CS because
+// they defer their response until they release it. It is never shorter than CSTimeout, because a GS that
+// times out enters the CS without all the responses, so a shorter timeout would only make that more likely.
+func (gs *GridSdr) csTimeout() time.Duration {
+	if !gs.timings.AdaptiveCS {
+		return gs.timings.CSTimeout
+	}
+	return gs.csRTT.Timeout()
+}
+
 // releaseCritSection sets the mutexState to StateReleased and then runs all the queued requests.
 func (gs *GridSdr) releaseCritSection() {
 	gs.mutexState.Set(common.StateReleased)
@@ -447,7 +742,90 @@ func (gs *GridSdr) releaseCritSection() {
 }
 
 func (gs *GridSdr) imLeader() bool {
-	return gs.leader == gs.Addr && !gs.inElection.Get().(bool)
+	_, held := gs.lease.held()
+	return gs.leaderID == gs.UUID && !gs.inElection.Get().(bool) && held
+}
+
+// emitEvent adds the event of the jobs to the event log and sends it to their callbacks if I'm the leader,
+// every change of the job lists is replicated to the leader so no event is missed.
+func (gs *GridSdr) emitEvent(typ string, jobs []Job) {
+	if !gs.imLeader() || len(jobs) == 0 {
+		return
+	}
+	state := JobQueued
+	if typ == EventScheduled {
+		state = JobScheduled
+	}
+	events := make([]Job, len(jobs))
+	for i, job := range jobs {
+		events[i] = job
+		if !job.State.terminal() {
+			events[i].State = state
+		}
+	}
+	gs.events.addJobs(typ, events)
+	gs.webhooks.emit(typ, gs.Addr, events)
+}
+
+// replicateEvents sends the new events to the other GSs every tick if I'm the leader
+func (gs *GridSdr) replicateEvents() {
+	acked := make(map[string]int64) // the last event of every GS
+	for {
+		time.Sleep(gs.timings.Tick)
+		if !gs.imLeader() {
+			acked = make(map[string]int64)
+			continue
+		}
+		for addr := range gs.gsNodes.GetAll() {
+			events, _, _ := gs.events.after(acked[addr], eventBatchSize)
+			if len(events) == 0 {
+				continue
+			}
+			last, e := rpcSyncEvents(addr, &events)
+			if e != nil {
+				continue
+			}
+			acked[addr] = int64(last)
+			// the GS may have events of the previous leader that I never received
+			gs.events.skipTo(int64(last))
+		}
+	}
+}
+
+// syncEventSeq is called by a new leader so that it continues after the last event of all the GSs
+func (gs *GridSdr) syncEventSeq() {
+	for addr := range gs.gsNodes.GetAll() {
+		if last, e := rpcLastEvent(addr); e == nil {
+			gs.events.skipTo(int64(last))
+		}
+	}
+}
+
+// watchRMs adds an event when a RM comes online or goes offline, only the leader does this.
+// The RMs are compared to the ones that were online when this GS became the leader.
+func (gs *GridSdr) watchRMs() {
+	var known map[string]string // nil if I'm not the leader
+	for {
+		time.Sleep(gs.timings.Poll)
+		if !gs.imLeader() {
+			known = nil
+			continue
+		}
+		alive := gs.getAliveRMs()
+		if known != nil {
+			for uuid, addr := range alive {
+				if _, ok := known[uuid]; !ok {
+					gs.events.addNode(EventRMJoined, addr)
+				}
+			}
+			for uuid, addr := range known {
+				if _, ok := alive[uuid]; !ok {
+					gs.events.addNode(EventRMLost, addr)
+				}
+			}
+		}
+		known = alive
+	}
 }
 
 // elect implements the Bully algorithm.
@@ -471,23 +849,40 @@ func (gs *GridSdr) elect() {
 	}
 
 	// if no responses, then set the node itself as leader, and tell the others
+	// I only schedule once a majority of the GSs granted me the lease of the new term, see renewLease
 	if oks == 0 {
 		gs.clock.Tick()
-		gs.leader = gs.Addr
-		log.Printf("I'm the leader (%v).\n", gs.leader)
+		term := gs.nextTerm()
+		gs.lease.lead(term)
+		gs.leaderID = gs.UUID
+		log.Printf("I'm the leader (%v) of term %v.\n", gs.Addr, term)
+		gs.syncEventSeq()
+		gs.events.addNode(EventLeader, gs.Addr)
 
 		args := gs.rpcArgsForGS(common.CoordinateMsg)
+		args.Term = term
 		addrs := common.SliceFromMap(gs.gsNodes.GetAll())
 		rpcGo(addrs, &args, rpcSendMsgToGS) // NOTE: ok to fail the send, because nodes might be done
 	}
 
 	// artificially make the election last longer so that multiple messages
 	// requests won't initialise multiple election runs
-	time.Sleep(time.Second)
+	time.Sleep(gs.timings.ElectionSleep)
 }
 
 func (gs *GridSdr) copyState(state GridSdrState) {
 	gs.clock.Set(state.Clock)
+	gs.quotas.set(state.Quotas)
+	gs.fairShare.set(state.Usage)
+	gs.jobIDs.set(state.JobSeq, state.Submissions)
+	for _, job := range state.History {
+		gs.history.add(job)
+		// the jobs that finished while I was offline
+		gs.accounting.record(job)
+	}
+	for _, cron := range state.Crons {
+		gs.crons.set(cron)
+	}
 	for _, job := range state.IncomingJobs {
 		gs.incomingJobAddChan <- job
 	}
@@ -499,15 +894,15 @@ func (gs *GridSdr) copyState(state GridSdrState) {
 // runTasks queries the tasks queue and if there are outstanding tasks it will request for critical and run the tasks.
 func (gs *GridSdr) runTasks() {
 	for {
-		// check whether there are tasks that needs running every 100ms
-		time.Sleep(100 * time.Millisecond)
+		// check whether there are tasks that needs running every tick
+		time.Sleep(gs.timings.Tick)
 		if len(gs.tasks) > 0 {
 			// start a timer and calculated the that GS is using the critical section
 			start := time.Now()
 
-			// acquire CS, run the tasks, run for 1ms at most, then release CS
+			// acquire CS, run the tasks, run for one tick at most, then release CS
 			gs.obtainCritSection()
-			timeout := time.After(100 * time.Millisecond)
+			timeout := time.After(gs.timings.Tick)
 		inner_loop:
 			for {
 				select {
@@ -540,7 +935,7 @@ func (gs *GridSdr) argsIsLater(args RPCArgs) bool {
 func (gs *GridSdr) respCritSection(args RPCArgs) {
 	resp := func() (interface{}, error) {
 		// NOTE: use gs.reqClock instead of the normal clock
-		rpcSendMsgToGS(args.Addr, &RPCArgs{gs.ID, gs.Addr, common.MutexResp, gs.reqClock})
+		rpcSendMsgToGS(gs.peerAddr(&args), &RPCArgs{ID: gs.ID, Addr: gs.Addr, Type: common.MutexResp, Clock: gs.reqClock, Addrs: gs.Addrs})
 		return 0, nil
 	}
 
@@ -555,16 +950,17 @@ func (gs *GridSdr) respCritSection(args RPCArgs) {
 // pollLeader polls the leader node and initiates the election algorithm is the leader goes offline.
 func (gs *GridSdr) pollLeader() {
 	for {
-		time.Sleep(time.Second)
+		time.Sleep(gs.timings.Poll)
 
 		// don't do anything if election is running or I'm leader
-		if gs.inElection.Get().(bool) || gs.imLeader() {
+		if gs.inElection.Get().(bool) || gs.leaderID == gs.UUID {
 			continue
 		}
 
-		remote, e := rpc.DialHTTP("tcp", gs.leader)
+		leader := gs.leaderAddr()
+		remote, e := common.DialRPC(leader)
 		if e != nil {
-			log.Printf("Leader %v not online (DialHTTP), initialising election.\n", gs.leader)
+			log.Printf("Leader %v not online (DialHTTP), initialising election.\n", leader)
 			gs.elect()
 		} else {
 			remote.Close()
@@ -583,14 +979,24 @@ func (gs *GridSdr) RecvMsg(args *RPCArgs, reply *int) error {
 	*reply = 1
 	gs.clock.Set(common.MaxInt64(gs.clock.Geti64(), args.Clock) + 1) // update Lamport clock
 	if args.Type == common.CoordinateMsg {
-		gs.leader = args.Addr
-		log.Printf("Leader set to %v\n", gs.leader)
+		// refuse a leader of an older term, e.g. one that was elected on the other side of a partition
+		if e := gs.lease.observe(args.Term); e != nil {
+			return e
+		}
+		if _, ok := gs.gsNodes.Addr(args.UUID); !ok {
+			gs.gsNodes.Join(args.node(common.GSNode), "")
+		}
+		gs.leaderID = args.UUID
+		log.Printf("Leader set to %v\n", gs.leaderAddr())
 
 	} else if args.Type == common.ElectionMsg {
 		// don't start a new election if one is already running
 		if !gs.inElection.Get().(bool) {
 			go gs.elect()
 		}
+	} else if args.Type == common.TermMsg {
+		*reply = int(gs.lease.currentTerm())
+
 	} else if args.Type == common.MutexReq {
 		go gs.respCritSection(*args)
 
@@ -598,12 +1004,23 @@ func (gs *GridSdr) RecvMsg(args *RPCArgs, reply *int) error {
 		gs.mutexRespChan <- 0
 
 	} else if args.Type == common.GSUpMsg {
+		node := args.node(common.GSNode)
+		if node.ID == gs.ID || node.UUID == gs.UUID {
+			return fmt.Errorf("GS %v on %v has the same ID %v or UUID as me (%v)", node.UUID, node.Addrs, node.ID, gs.Addr)
+		}
+		if e := gs.gsNodes.CheckJoin(node); e != nil {
+			return e
+		}
 		*reply = gs.ID
-		gs.gsNodes.SetInt(args.Addr, int64(args.ID))
+		gs.gsNodes.Join(node, "")
 
 	} else if args.Type == common.RMUpMsg {
+		node := args.node(common.RMNode)
+		if e := gs.rmNodes.CheckJoin(node); e != nil {
+			return e
+		}
 		*reply = gs.ID
-		gs.rmNodes.SetInt(args.Addr, int64(args.ID))
+		gs.rmNodes.Join(node, "")
 
 	} else {
 		log.Panic("Invalid message!", args)
@@ -617,13 +1034,16 @@ func (gs *GridSdr) AddJobs(jobs *[]Job, reply *int) error {
 	if !gs.ready.Get().(bool) {
 		str := fmt.Sprintf("Not adding %v incoming jobs because I'm not ready\n", len(*jobs))
 		log.Print(str)
-		return errors.New(str)
+		return errorf(errNotReady, "%v", str)
 	}
 
 	log.Printf("%v new incoming jobs.\n", len(*jobs))
+	gs.jobIDs.observe(*jobs)
 	for _, job := range *jobs {
 		gs.incomingJobAddChan <- job
 	}
+	// rescheduled and preempted jobs are added again, their events are sent when they leave scheduledJobs
+	gs.emitEvent(EventQueued, filterJobs(*jobs, func(j Job) bool { return j.Reschedules == 0 && j.Preemptions == 0 }))
 	*reply = 0
 	return nil
 }
@@ -638,49 +1058,89 @@ func (gs *GridSdr) RecvScheduledJobs(jobs *[]Job, reply *int) error {
 	}
 
 	log.Printf("Adding %v scheduled jobs.\n", len(*jobs))
+	gs.jobIDs.observe(*jobs)
 	for _, job := range *jobs {
 		gs.scheduledJobAddChan <- job
 	}
+	gs.emitEvent(EventScheduled, *jobs)
 	*reply = 0
 	return nil
 }
 
 // RecvScheduledJobsFromRM RPC is for appending jobs to the scheduledJobs list but called by the RM
-// it needs to use the CS to sync the new jobs with the GS cluster
-func (gs *GridSdr) RecvScheduledJobsFromRM(jobs *[]Job, reply *int) error {
+// it needs to use the CS to sync the new jobs with the GS cluster, the job IDs are assigned here.
+// The jobs are refused if they would exceed the running quota of their owners, the RM should forward them instead.
+// If the submission is a duplicate then the IDs of the original jobs are returned and the RM must not run them.
+func (gs *GridSdr) RecvScheduledJobsFromRM(jobs *[]Job, reply *SubmitReply) error {
+	quotas := gs.quotas.get()
+	running := countJobs(gs.getScheduledJobs())
+	for _, job := range *jobs {
+		if quotas.exceedsRunning(job, running) {
+			return errorf(errQuota, "The jobs exceed the running quota of %v", job.Owner)
+		}
+		running.add(job)
+	}
+
 	c := make(chan int)
 	gs.tasks <- func() (interface{}, error) {
+		if gs.findDuplicate(*jobs, reply) {
+			c <- 0
+			return 0, nil
+		}
+		gs.jobIDs.assign(*jobs)
+
 		// add jobs to the submitted list for all GSs to myself
+		gs.jobIDs.observe(*jobs)
 		for _, job := range *jobs {
 			gs.scheduledJobAddChan <- job
 		}
+		gs.emitEvent(EventScheduled, *jobs)
 		// and for others
 		r := rpcJobsGo(common.SliceFromMap(gs.gsNodes.GetAll()), jobs, rpcSyncScheduledJobs)
 		c <- 0
 		return r, nil
 	}
 	<-c
-	*reply = 0
+	if !reply.Duplicate {
+		reply.IDs = jobIDs(*jobs)
+	}
 	return nil
 }
 
-// DropJobs deletes the first n jobs from incomingJobs
-func (gs *GridSdr) DropJobs(n *int, reply *int) error {
+// findDuplicate checks whether the jobs were submitted before with the same idempotency key,
+// if so reply is filled with the IDs of the original jobs.
+// NOTE: it should run in the CS so that no other GS adds the same submission at the same time.
+func (gs *GridSdr) findDuplicate(jobs []Job, reply *SubmitReply) bool {
+	if len(jobs) == 0 || jobs[0].SubmitKey == "" {
+		return false
+	}
+	ids, ok := gs.jobIDs.lookup(jobs[0].Owner, jobs[0].SubmitKey)
+	if ok {
+		log.Printf("Submission %v of %v is a duplicate\n", jobs[0].SubmitKey, jobs[0].Owner)
+		reply.IDs = ids
+		reply.Duplicate = true
+	}
+	return ok
+}
+
+// DropJobs deletes the jobs with the given IDs from incomingJobs
+func (gs *GridSdr) DropJobs(ids *[]int64, reply *int) error {
 	if !gs.ready.Get().(bool) {
-		str := fmt.Sprintf("Not dropping %v jobs because I'm not ready\n", *n)
+		str := fmt.Sprintf("Not dropping %v jobs because I'm not ready\n", len(*ids))
 		log.Print(str)
 		return errors.New(str)
 	}
 
-	log.Printf("Dropping %v jobs\n", *n)
-	gs.incomingJobRmChan <- *n
+	log.Printf("Dropping %v jobs\n", len(*ids))
+	gs.incomingJobRmChan <- *ids
 	*reply = 0
 	return nil
 }
 
-// SyncCompletedJobs is called by the RM when job(s) are completed.
+// SyncCompletedJobs is called by the RM when job(s) are completed,
+// the RM sets StartTime and FinishTime to the time the jobs actually ran.
 // NOTE: it acquire a critical section and propagate the change to everybody.
-func (gs *GridSdr) SyncCompletedJobs(jobs *[]int64, reply *int) error {
+func (gs *GridSdr) SyncCompletedJobs(jobs *[]Job, reply *int) error {
 	if !gs.ready.Get().(bool) {
 		str := fmt.Sprintf("Can't sync %v completed jobs because I'm not ready\n", len(*jobs))
 		log.Print(str)
@@ -695,7 +1155,10 @@ func (gs *GridSdr) SyncCompletedJobs(jobs *[]int64, reply *int) error {
 		go gs.RemoveCompletedJobs(jobs, &dummy)
 
 		// remove it from everybody else
-		rpcInt64sGo(common.SliceFromMap(gs.gsNodes.GetAll()), jobs, rpcRemoveCompletedJobs)
+		rpcJobsGo(common.SliceFromMap(gs.gsNodes.GetAll()), jobs, rpcRemoveCompletedJobs)
+
+		// the preempted jobs go back to the queue
+		gs.requeuePreempted(filterJobs(*jobs, func(j Job) bool { return j.Preempted }))
 
 		c <- 0
 		return 0, nil
@@ -706,8 +1169,10 @@ func (gs *GridSdr) SyncCompletedJobs(jobs *[]int64, reply *int) error {
 	return nil
 }
 
-// RemoveCompletedJobs is called by another GS to remove job(s) from the scheduledJobAddChan
-func (gs *GridSdr) RemoveCompletedJobs(jobs *[]int64, reply *int) error {
+// RemoveCompletedJobs is called by another GS to remove job(s) from the scheduledJobAddChan,
+// the run time of the jobs that have a FinishTime is charged to their owners.
+// Jobs in a terminal state are added to the history.
+func (gs *GridSdr) RemoveCompletedJobs(jobs *[]Job, reply *int) error {
 	if !gs.ready.Get().(bool) {
 		str := fmt.Sprintf("Can't remove %v completed jobs because I'm not ready\n", len(*jobs))
 		log.Print(str)
@@ -723,15 +1188,72 @@ func (gs *GridSdr) RemoveCompletedJobs(jobs *[]int64, reply *int) error {
 }
 
 // AddJobsViaUser is called by the client to add job(s) to the tasks queue, it returns when the job is synchronised.
-func (gs *GridSdr) AddJobsViaUser(jobs *[]Job, reply *int) error {
+// Every job is stamped with the authenticated user as its owner and gets a new ID, the IDs are returned in the reply.
+// A retried submission with the same idempotency key does not create new jobs.
+func (gs *GridSdr) AddJobsViaUser(args *UserJobsArgs, reply *SubmitReply) error {
+	jobs := &args.Jobs
 	if !gs.ready.Get().(bool) {
 		str := fmt.Sprintf("Can't add %v jobs because I'm not ready\n", len(*jobs))
 		log.Print(str)
-		return errors.New(str)
+		return errorf(errNotReady, "%v", str)
 	}
 
-	c := make(chan int)
+	user, e := gs.users.Authenticate(args.Creds)
+	if e != nil {
+		return e
+	}
+	if len(*jobs) > MaxJobsPerRequest {
+		return errorf(errInvalid, "More than %v jobs in one request", MaxJobsPerRequest)
+	}
+	now := time.Now()
+	for i := range *jobs {
+		(*jobs)[i].clearServerFields()
+		(*jobs)[i].Owner = user.Name
+		(*jobs)[i].Group = user.Group
+		(*jobs)[i].SubmitKey = args.IdempotencyKey
+		if (*jobs)[i].StartTime.IsZero() {
+			(*jobs)[i].StartTime = now
+		}
+		if e := gs.preempt.checkPriority(user, (*jobs)[i]); e != nil {
+			return e
+		}
+		if e := (*jobs)[i].validate(); e != nil {
+			return e
+		}
+		if e := gs.webhooks.checkHosts((*jobs)[i]); e != nil {
+			return e
+		}
+		if a := (*jobs)[i].Array; a != nil {
+			if e := a.Validate(); e != nil {
+				return e
+			}
+		}
+	}
+
+	// a retried submission should not be refused because of its own jobs
+	if gs.findDuplicate(*jobs, reply) {
+		return nil
+	}
+
+	// reject the whole request if it does not fit in the queued quota
+	quotas := gs.quotas.get()
+	queued := countJobs(gs.getIncomingJobs())
+	for _, job := range *jobs {
+		if quotas.exceedsQueued(job, queued) {
+			return errorf(errQuota, "The jobs exceed the queued quota of %v", job.Owner)
+		}
+		queued.add(job)
+	}
+
+	c := make(chan error)
 	gs.tasks <- func() (interface{}, error) {
+		// check again in the CS, the same submission may have arrived on another GS
+		if gs.findDuplicate(*jobs, reply) {
+			c <- nil
+			return 0, nil
+		}
+		gs.jobIDs.assign(*jobs)
+
 		// add jobs to myself
 		// TODO more elegant if RPC call on myself
 		r := -1
@@ -740,16 +1262,435 @@ func (gs *GridSdr) AddJobsViaUser(jobs *[]Job, reply *int) error {
 		// add jobs to the others
 		rpcJobsGo(common.SliceFromMap(gs.gsNodes.GetAll()), jobs, rpcSyncJobs)
 
-		c <- 0
+		c <- e
 		return r, e
 	}
+	if e := <-c; e != nil {
+		return e
+	}
+	if !reply.Duplicate {
+		reply.IDs = jobIDs(*jobs)
+	}
+	return nil
+}
+
+// JobStatus is called by the client to query jobs, only jobs that are accessible by the user are returned.
+// Jobs that are not scheduled yet have an empty ResMan.
+func (gs *GridSdr) JobStatus(args *UserIDsArgs, reply *[]Job) error {
+	jobs, e := gs.findUserJobs(args)
+	if e != nil {
+		return e
+	}
+
+	incoming, scheduled := gs.getJobs()
+	gs.setStates(jobs, scheduled)
+	gs.summarizeArrays(jobs, incoming, scheduled)
+	gs.setUsage(jobs)
+	*reply = jobs
+	return nil
+}
+
+// ListJobs is called by the client to list all the queued and scheduled jobs that are accessible by the user.
+func (gs *GridSdr) ListJobs(creds *Credentials, reply *[]Job) error {
+	if !gs.ready.Get().(bool) {
+		return errorf(errNotReady, "Can't list jobs because I'm not ready")
+	}
+	user, e := gs.users.Authenticate(*creds)
+	if e != nil {
+		return e
+	}
+
+	incoming, scheduled := gs.getJobs()
+	jobs := filterJobs(append(incoming, scheduled...), user.CanAccess)
+	gs.setStates(jobs, scheduled)
+	gs.summarizeArrays(jobs, incoming, scheduled)
+	*reply = jobs
+	return nil
+}
+
+// GetQueue is called by the client to get the number of queued, held, deferred and running jobs of every user.
+// It does not reveal the jobs themselves so every user may call it.
+func (gs *GridSdr) GetQueue(creds *Credentials, reply *[]QueueEntry) error {
+	if !gs.ready.Get().(bool) {
+		return errorf(errNotReady, "Can't get the queue because I'm not ready")
+	}
+	if _, e := gs.users.Authenticate(*creds); e != nil {
+		return e
+	}
+
+	incoming, scheduled := gs.getJobs()
+	jobs := append(incoming, scheduled...)
+	gs.setStates(jobs, scheduled)
+
+	entries := make(map[string]*QueueEntry)
+	var owners []string
+	for _, job := range jobs {
+		entry, ok := entries[job.Owner]
+		if !ok {
+			entry = &QueueEntry{User: job.Owner}
+			entries[job.Owner] = entry
+			owners = append(owners, job.Owner)
+		}
+		switch job.State {
+		case JobQueued:
+			entry.Queued += job.taskCount()
+		case JobHeld:
+			entry.Held += job.taskCount()
+		case JobDeferred:
+			entry.Deferred += job.taskCount()
+		case JobScheduled:
+			entry.Running++
+		}
+	}
+	sort.Strings(owners)
+	res := make([]QueueEntry, len(owners))
+	for i, owner := range owners {
+		res[i] = *entries[owner]
+	}
+	*reply = res
+	return nil
+}
+
+// GetLeader returns the address of the leader that this GS knows about
+func (gs *GridSdr) GetLeader(x *int, reply *string) error {
+	// doesn't matter what x is
+	*reply = gs.leaderAddr()
+	return nil
+}
+
+// GetIdentity returns who I am, it is used to detect nodes with the same ID or UUID
+func (gs *GridSdr) GetIdentity(x *int, reply *common.Node) error {
+	// doesn't matter what x is
+	*reply = gs.Node
+	return nil
+}
+
+// GetNodes returns all the GSs (including myself) and RMs that this GS knows about, the free capacity of the RMs is included.
+func (gs *GridSdr) GetNodes(x *int, reply *[]NodeInfo) error {
+	// doesn't matter what x is
+	nodes := []NodeInfo{{Node: gs.Node, Leader: gs.leaderID == gs.UUID, Capacity: -1}}
+	for _, n := range gs.gsNodes.Nodes(common.GSNode) {
+		nodes = append(nodes, NodeInfo{Node: n, Leader: gs.leaderID == n.UUID, Capacity: -1})
+	}
+	caps := gs.getRMCapacities()
+	for _, n := range gs.rmNodes.Nodes(common.RMNode) {
+		c, ok := caps[n.UUID]
+		if !ok {
+			nodes = append(nodes, NodeInfo{Node: n, Capacity: -1})
+			continue
+		}
+		nodes = append(nodes, NodeInfo{n, false, c.Free, c.Workers, len(c.Jobs), c.Usage})
+	}
+	*reply = nodes
+	return nil
+}
+
+// setStates sets the State of every job that is not finished, scheduled should be the current scheduledJobs
+func (gs *GridSdr) setStates(jobs []Job, scheduled []Job) {
+	quotas := gs.quotas.get()
+	running := countJobs(scheduled)
+	now := time.Now()
+	for i, job := range jobs {
+		if job.State.terminal() {
+			continue
+		} else if job.ResMan != "" {
+			jobs[i].State = JobScheduled
+		} else if !job.eligible(now) {
+			jobs[i].State = JobDeferred
+		} else if quotas.exceedsRunning(job, running) {
+			jobs[i].State = JobHeld
+		} else {
+			jobs[i].State = JobQueued
+		}
+	}
+}
+
+// CancelJobs is called by the client to remove jobs from the queues and stop them on the RMs.
+// The request fails if any of the jobs is not accessible by the user.
+func (gs *GridSdr) CancelJobs(args *UserIDsArgs, reply *int) error {
+	jobs, e := gs.findUserJobs(args)
+	if e != nil {
+		return e
+	}
+	for _, job := range jobs {
+		if job.State.terminal() {
+			return errJobFinished(job)
+		}
+	}
+	*reply = gs.cancelJobs(jobs)
+	return nil
+}
+
+// cancelJobs removes jobs that are not finished from the queues and stops them on the RMs,
+// it returns the number of cancelled jobs.
+func (gs *GridSdr) cancelJobs(jobs []Job) int {
+	// cancelling a job array cancels all its tasks that left the queue as well
+	incoming, scheduled := gs.getJobs()
+	for _, job := range jobs {
+		if job.isArray() {
+			tasks := arrayTasks(append(incoming, scheduled...), job.ID)
+			jobs = append(jobs, filterJobsByIDs(tasks, jobIDs(jobs), false)...)
+		}
+	}
+	for i := range jobs {
+		jobs[i].State = JobCancelled
+	}
+
+	c := make(chan int)
+	gs.tasks <- func() (interface{}, error) {
+		ids := jobIDs(jobs)
+
+		// stop the jobs that are already running on RMs
+		rms := make(map[string][]int64)
+		for _, job := range jobs {
+			if job.ResMan != "" {
+				rms[gs.rmAddr(job)] = append(rms[gs.rmAddr(job)], job.ID)
+			}
+		}
+		for addr, rmIDs := range rms {
+			rpcCancelJobsOnRM(addr, &rmIDs)
+		}
+
+		// remove them from both job lists for myself
+		var dummy int
+		gs.incomingJobRmChan <- ids
+		go gs.RemoveCompletedJobs(&jobs, &dummy)
+		// and for others
+		addrs := common.SliceFromMap(gs.gsNodes.GetAll())
+		rpcInt64sGo(addrs, &ids, rpcDropJobs)
+		rpcJobsGo(addrs, &jobs, rpcRemoveCompletedJobs)
+
+		c <- 0
+		return 0, nil
+	}
 	<-c
+	log.Printf("Cancelled %v jobs\n", len(jobs))
+	return len(jobs)
+}
+
+// WaitJobs is called by the client to wait until all (or any) of the jobs are completed, failed or cancelled.
+// It returns early if the timeout expires, the timeout is at most 5 minutes so clients should call it in a loop.
+func (gs *GridSdr) WaitJobs(args *WaitArgs, reply *WaitReply) error {
+	// every job is waited for and returned once, however often its ID is repeated
+	args.IDs = uniqueIDs(args.IDs)
+	// make sure that the jobs exist and are accessible
+	if _, e := gs.findUserJobs(&UserIDsArgs{args.Creds, args.IDs}); e != nil {
+		return e
+	}
+	gs.history.waitJobs(args, reply)
+	return nil
+}
+
+// JobLogs is called by the client to fetch the logs of jobs from the RMs that ran them.
+func (gs *GridSdr) JobLogs(args *UserIDsArgs, reply *map[int64][]string) error {
+	if _, e := gs.users.Authenticate(args.Creds); e != nil {
+		return e
+	}
+
+	// the RMs check the ownership because completed jobs are not known by the GSs
+	logs := make(map[int64][]string)
+	for addr := range gs.rmNodes.GetAll() {
+		r, e := rpcGetLogsFromRM(addr, args)
+		if se, ok := e.(rpc.ServerError); ok {
+			// the RM refused the request, e.g. the user is not the owner
+			return se
+		} else if e != nil {
+			continue
+		}
+		for k, v := range r {
+			logs[k] = v
+		}
+	}
+	*reply = logs
+	return nil
+}
+
+// findJobs finds the jobs with the given IDs in the queues and in the history
+func (gs *GridSdr) findJobs(ids []int64) []Job {
+	incoming, scheduled := gs.getJobs()
+	// a job is in both queues for a short time when it is scheduled, the scheduled copy is the latest
+	queued := filterJobsByIDs(incoming, jobIDs(scheduled), false)
+	// a job may be in the history and still be in a queue for a short time, e.g. after it is cancelled
+	finished, _ := gs.history.find(ids)
+	found := filterJobsByIDs(append(queued, scheduled...), ids, true)
+	return append(filterJobsByIDs(found, jobIDs(finished), false), finished...)
+}
+
+// findUserJobs authenticates the user and finds the requested jobs in both job lists.
+// An error is returned if a job is not found or the user may not access it.
+func (gs *GridSdr) findUserJobs(args *UserIDsArgs) ([]Job, error) {
+	if !gs.ready.Get().(bool) {
+		str := fmt.Sprintf("Can't find %v jobs because I'm not ready\n", len(args.IDs))
+		log.Print(str)
+		return nil, errorf(errNotReady, "%v", str)
+	}
+
+	user, e := gs.users.Authenticate(args.Creds)
+	if e != nil {
+		return nil, e
+	}
+
+	ids := uniqueIDs(args.IDs)
+	found := gs.findJobs(ids)
+	if len(found) != len(ids) {
+		// a job that moves between the queues, e.g. when it is scheduled, may be missed by the copies of
+		// both queues, it is in one of them once the move is finished
+		found = gs.findJobs(ids)
+	}
+	if len(found) != len(ids) {
+		return nil, errorf(errNotFound, "Found only %v out of %v jobs", len(found), len(ids))
+	}
+	for _, job := range found {
+		if !user.CanAccess(job) {
+			return nil, errorf(errForbidden, "User %v may not access job %v", user.Name, job.ID)
+		}
+	}
+	return found, nil
+}
+
+// getJobs returns a copy of incomingJobs and scheduledJobs.
+func (gs *GridSdr) getJobs() ([]Job, []Job) {
+	return gs.getIncomingJobs(), gs.getScheduledJobs()
+}
+
+// getIncomingJobs sends a request to the scheduleJobs select loop and retrieves a copy of incomingJobs.
+// NOTE: it must not be called in the scheduleJobs select statement.
+func (gs *GridSdr) getIncomingJobs() []Job {
+	return collectJobs(gs.incomingJobReqChan)
+}
+
+// getScheduledJobs sends a request to the updateScheduledJobs select loop and retrieves a copy of scheduledJobs.
+// NOTE: it must not be called in the updateScheduledJobs select statement.
+func (gs *GridSdr) getScheduledJobs() []Job {
+	return collectJobs(gs.scheduledJobReqChan)
+}
+
+func collectJobs(reqChan chan<- chan Job) []Job {
+	var jobs []Job
+	c := make(chan Job)
+	reqChan <- c
+	for job := range c {
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// SetQuota is called by an admin to change the quota of a user or a group, a zero quota removes the limit.
+// The new quota is replicated to all the GSs.
+func (gs *GridSdr) SetQuota(args *QuotaArgs, reply *int) error {
+	user, e := gs.users.Authenticate(args.Creds)
+	if e != nil {
+		return e
+	}
+	if !user.Admin {
+		return errorf(errForbidden, "User %v is not an admin", user.Name)
+	}
+
+	c := make(chan int)
+	gs.tasks <- func() (interface{}, error) {
+		// set the quota for myself
+		gs.quotas.setOne(*args)
+		// and for others, without the token of the admin
+		sync := *args
+		sync.Creds = Credentials{}
+		rpcQuotaGo(common.SliceFromMap(gs.gsNodes.GetAll()), &sync)
+		c <- 0
+		return 0, nil
+	}
+	<-c
+	log.Printf("Quota of %v set to %v\n", args.Name, args.Quota)
 	*reply = 0
 	return nil
 }
 
+// SyncQuota is called by another GS to replicate a quota change.
+// NOTE: this function should not be called directly by the client, it requires CS.
+// It is not in GridSdrAPI, so only the nodes that present the cluster secret can call it.
+func (gs *GridSdr) SyncQuota(args *QuotaArgs, reply *int) error {
+	gs.quotas.setOne(*args)
+	*reply = 0
+	return nil
+}
+
+// GetQuotas is called by the client to view the quota table.
+func (gs *GridSdr) GetQuotas(creds *Credentials, reply *QuotaTable) error {
+	if _, e := gs.users.Authenticate(*creds); e != nil {
+		return e
+	}
+	*reply = gs.quotas.get()
+	return nil
+}
+
+// GetDeliveries is called by the client to view the status of the webhook deliveries of the jobs that are accessible by the user.
+// The deliveries are recorded by the GS that sent them, i.e. the leader at that time.
+func (gs *GridSdr) GetDeliveries(creds *Credentials, reply *[]Delivery) error {
+	user, e := gs.users.Authenticate(*creds)
+	if e != nil {
+		return e
+	}
+	*reply = gs.webhooks.getRecords(user)
+	return nil
+}
+
+// WatchEvents is called by the client to read the event stream, it blocks until there are events after args.After
+// or until the timeout. Job events are only returned for the jobs that are accessible by the user.
+// The sequence numbers are the same on every GS so the client may continue on another GS.
+func (gs *GridSdr) WatchEvents(args *WatchArgs, reply *WatchReply) error {
+	if !gs.ready.Get().(bool) {
+		return errorf(errNotReady, "Can't watch events because I'm not ready")
+	}
+	user, e := gs.users.Authenticate(args.Creds)
+	if e != nil {
+		return e
+	}
+	gs.events.watch(args, user, reply)
+	return nil
+}
+
+// SyncEvents is called by the leader to replicate its events, the reply is the sequence number of my last event
+func (gs *GridSdr) SyncEvents(events *[]Event, reply *int) error {
+	*reply = int(gs.events.merge(*events))
+	return nil
+}
+
+// LastEvent returns the sequence number of my last event
+func (gs *GridSdr) LastEvent(x *int, reply *int) error {
+	// doesn't matter what x is
+	*reply = int(gs.events.last())
+	return nil
+}
+
+// GetStatus is called by the discovery server to show the queues and the RMs on its dashboard
+func (gs *GridSdr) GetStatus(x *int, reply *discosrv.GSStatus) error {
+	// doesn't matter what x is
+	if !gs.ready.Get().(bool) {
+		return errorf(errNotReady, "Can't get the status because I'm not ready")
+	}
+
+	incoming, scheduled := gs.getJobs()
+	running := make(map[string]int)
+	for _, job := range scheduled {
+		running[job.ResManID]++
+	}
+	caps := gs.getRMCapacities()
+
+	reply.Addr, reply.Leader = gs.Addr, gs.leaderAddr()
+	reply.Queued, reply.Running = 0, len(scheduled)
+	for _, job := range incoming {
+		reply.Queued += job.taskCount()
+	}
+	reply.RMs = nil
+	for _, m := range gs.rmNodes.Members() {
+		cap := -1
+		if c, ok := caps[m.UUID]; ok {
+			cap = c.Free
+		}
+		reply.RMs = append(reply.RMs, discosrv.RMStatus{Addr: m.Addr, Capacity: cap, Running: running[m.UUID]})
+	}
+	return nil
+}
+
 // GetState RPC used by a GS when it first starts up to copy the job lists
-// TODO some repeated code in this function
 func (gs *GridSdr) GetState(x *int, state *GridSdrState) error {
 	// doesn't matter what x is
 	if !gs.ready.Get().(bool) {
@@ -758,35 +1699,12 @@ func (gs *GridSdr) GetState(x *int, state *GridSdrState) error {
 		return errors.New(str)
 	}
 
-	wg := sync.WaitGroup{}
 	state.Clock = gs.clock.Geti64()
-
-	// send request to the incoming jobs select loop and retrieve the result
-	incomingChan := make(chan Job)
-	gs.incomingJobReqChan <- incomingChan
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		var jobs []Job
-		for job := range incomingChan {
-			jobs = append(jobs, job)
-		}
-		state.IncomingJobs = jobs
-	}()
-
-	scheduledChan := make(chan Job)
-	gs.scheduledJobReqChan <- scheduledChan
-	wg.Add(1)
-	go func() {
-		var jobs []Job
-		defer wg.Done()
-		for job := range scheduledChan {
-			jobs = append(jobs, job)
-		}
-		state.ScheduledJobs = jobs
-	}()
-
-	wg.Wait()
-
+	state.Quotas = gs.quotas.get()
+	state.Usage = gs.fairShare.get()
+	state.JobSeq, state.Submissions = gs.jobIDs.get()
+	state.History = gs.history.getAll()
+	state.Crons = gs.crons.getAll()
+	state.IncomingJobs, state.ScheduledJobs = gs.getJobs()
 	return nil
 }
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kc1212/virtual-grid/common"
+)
+
+func TestPublicRPCs(t *testing.T) {
+	tests := []struct {
+		public interface{}
+		method string
+		served bool
+	}{
+		{&publicGridSdr{}, "AddJobsViaUser", true},
+		{&publicGridSdr{}, "SetQuota", true},
+		{&publicGridSdr{}, "GetQuotas", true},
+		{&publicGridSdr{}, "SyncQuota", false},
+		{&publicGridSdr{}, "SyncCron", false},
+		{&publicGridSdr{}, "SyncEvents", false},
+		{&publicGridSdr{}, "AddJobs", false},
+		{&publicGridSdr{}, "RecvMsg", false},
+		{&publicGridSdr{}, "RenewLease", false},
+		{&publicResMan{}, "AddJobsViaUser", true},
+		{&publicResMan{}, "AddJob", false},
+		{&publicResMan{}, "CancelJobs", false},
+		{&publicResMan{}, "PreemptJobs", false},
+	}
+	for _, test := range tests {
+		typ := reflect.TypeOf(test.public)
+		if _, served := typ.MethodByName(test.method); served != test.served {
+			t.Errorf("%v.%v: served is %v, expected %v", typ.Elem().Name(), test.method, served, test.served)
+		}
+	}
+}
+
+func TestQueueCopiesHaveAddedJobs(t *testing.T) {
+	shares, _ := LoadFairShareConfig("")
+	gs := InitGridSdr(1, "uuid", []string{"localhost:4001"}, nil, "localhost:4001", "localhost:3333", nil, QuotaTable{},
+		PolicyFIFO, shares, PreemptPolicy{}, WebhookConfig{}, nil, common.DefaultTimings())
+	go gs.scheduleJobs()
+	go gs.updateScheduledJobs()
+
+	// the jobs are in the copies of the queues as soon as they were sent to the channels, like after AddJobs
+	// and RecvScheduledJobs, the select loops may otherwise serve the requests first
+	tests := []struct {
+		incoming  int
+		scheduled int
+	}{
+		{1, 0}, {0, 1}, {3, 2}, {100, 100},
+	}
+	var id int64
+	queued, running := 0, 0
+	for i, test := range tests {
+		for k := 0; k < 20; k++ {
+			for n := 0; n < test.incoming; n++ {
+				id++
+				gs.incomingJobAddChan <- Job{ID: id}
+			}
+			for n := 0; n < test.scheduled; n++ {
+				id++
+				gs.scheduledJobAddChan <- Job{ID: id}
+			}
+			queued, running = queued+test.incoming, running+test.scheduled
+			incoming, scheduled := gs.getJobs()
+			if len(incoming) != queued || len(scheduled) != running {
+				t.Fatalf("test %v: %v incoming and %v scheduled jobs, expected %v and %v", i, len(incoming), len(scheduled), queued, running)
+			}
+		}
+	}
+}
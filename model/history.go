@@ -0,0 +1,150 @@
+package model
+
+import (
+	"sync"
+	"time"
+)
+
+// historySize is the maximum number of finished jobs that a GS remembers
+const historySize = 100000
+
+// maxWait is the longest time that WaitJobs blocks, clients should call it again
+const maxWait = 5 * time.Minute
+
+// WaitArgs is the RPC argument for WaitJobs
+type WaitArgs struct {
+	Creds   Credentials
+	IDs     []int64
+	Any     bool          // return as soon as one job finished instead of all of them
+	Timeout time.Duration // at most maxWait
+}
+
+// WaitReply is the reply of WaitJobs, Finished holds the jobs that reached a terminal state
+type WaitReply struct {
+	Finished []Job
+	Pending  []int64
+	TimedOut bool
+}
+
+// jobHistory keeps the jobs that are completed, failed or cancelled.
+// Every GS records them when they are removed from the queues, so the history is replicated
+// together with the job queues.
+type jobHistory struct {
+	sync.Mutex
+	jobs    map[int64]Job
+	order   []int64       // oldest first, for eviction
+	changed chan struct{} // closed and replaced whenever a job is added
+	arrays  map[int64]*arrayTally
+}
+
+func newJobHistory() *jobHistory {
+	return &jobHistory{jobs: make(map[int64]Job), changed: make(chan struct{}), arrays: make(map[int64]*arrayTally)}
+}
+
+// terminal checks whether the job will not change state anymore
+func (s JobState) terminal() bool {
+	return s == JobCompleted || s == JobFailed || s == JobCancelled
+}
+
+// add records a finished job, the first terminal state of a job wins.
+// It returns true if the job was not in the history before.
+func (h *jobHistory) add(job Job) bool {
+	if !job.State.terminal() {
+		return false
+	}
+	h.Lock()
+	defer h.Unlock()
+	if _, ok := h.jobs[job.ID]; ok {
+		return false
+	}
+	if job.FinishTime.IsZero() {
+		job.FinishTime = time.Now()
+	}
+	h.jobs[job.ID] = job
+	h.order = append(h.order, job.ID)
+	if _, finished := h.jobs[job.ArrayID]; job.ArrayID != 0 && !finished {
+		h.tally(job)
+	} else if job.isArray() {
+		delete(h.arrays, job.ID)
+	}
+	if len(h.order) > historySize {
+		delete(h.jobs, h.order[0])
+		h.order = h.order[1:]
+	}
+
+	// wake up everybody that is waiting
+	close(h.changed)
+	h.changed = make(chan struct{})
+	return true
+}
+
+// find returns the finished jobs out of ids and a channel that is closed when the history changes
+func (h *jobHistory) find(ids []int64) ([]Job, <-chan struct{}) {
+	h.Lock()
+	defer h.Unlock()
+	var jobs []Job
+	for _, id := range ids {
+		if job, ok := h.jobs[id]; ok {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, h.changed
+}
+
+// getAll returns the history, oldest first
+func (h *jobHistory) getAll() []Job {
+	h.Lock()
+	defer h.Unlock()
+	jobs := make([]Job, len(h.order))
+	for i, id := range h.order {
+		jobs[i] = h.jobs[id]
+	}
+	return jobs
+}
+
+// waitJobs blocks until all (or any) of the jobs in args are in the history or until the timeout.
+// The caller must have checked that the jobs exist and are accessible.
+func (h *jobHistory) waitJobs(args *WaitArgs, reply *WaitReply) {
+	timeout := args.Timeout
+	if timeout <= 0 || timeout > maxWait {
+		timeout = maxWait
+	}
+	deadline := time.After(timeout)
+
+	for {
+		finished, changed := h.find(args.IDs)
+		done := len(finished) == len(args.IDs) || (args.Any && len(finished) > 0)
+		if !done {
+			select {
+			case <-changed:
+				continue
+			case <-deadline:
+				reply.TimedOut = true
+			}
+		}
+
+		reply.Finished = finished
+		reply.Pending = nil
+		for _, id := range args.IDs {
+			if _, ok := findJob(finished, id); !ok {
+				reply.Pending = append(reply.Pending, id)
+			}
+		}
+		return
+	}
+}
+
+// findJob finds the job with the given id in jobs
+func findJob(jobs []Job, id int64) (Job, bool) {
+	for _, job := range jobs {
+		if job.ID == id {
+			return job, true
+		}
+	}
+	return Job{}, false
+}
+
+// errJobFinished is returned when an operation needs a job that is still queued or running
+func errJobFinished(job Job) error {
+	return errorf(errConflict, "Job %v is already finished (%v)", job.ID, job.State)
+}
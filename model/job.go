@@ -1,14 +1,122 @@
 package model
 
-import "time"
+//go:generate stringer -type=JobState
+
+import (
+	"sort"
+	"time"
+)
+
+// JobState is the state of a job as reported to the user, it is derived when the job is queried
+type JobState int
+
+const (
+	JobQueued    JobState = iota // waiting in incomingJobs
+	JobHeld                      // waiting in incomingJobs but held back by a quota
+	JobDeferred                  // waiting in incomingJobs until its NotBefore time
+	JobScheduled                 // sent to a RM
+	JobCompleted                 // finished successfully
+	JobFailed                    // finished with an error, see Job.Error
+	JobCancelled                 // cancelled by the user
+)
 
 // Job are entities can be executed by worker nodes
 type Job struct {
-	ID         int64 // must be unique
-	Duration   time.Duration
-	ResMan     string
-	StartTime  time.Time
-	FinishTime time.Time
+	ID          int64         // must be unique
+	Owner       string        // name of the user that submitted the job
+	Group       string        // group of the owner
+	Duration    time.Duration // how long the job sleeps, or the time limit of its command (0 is unlimited)
+	ResMan      string        // address of the RM when the job was scheduled
+	ResManID    string        // UUID of the RM that runs the job
+	StartTime   time.Time
+	FinishTime  time.Time
+	State       JobState // only set in replies to the user and for finished jobs
+	Error       string   // why the job failed
+	SubmitKey   string   // idempotency key of the submission, unique per owner
+	Callbacks   []string // URLs that receive a JobEvent on every state transition
+	Reschedules int      // number of times the job was put back into the queue
+	Env         map[string]string
+	Array       *ArraySpec       // set on a job array and on its tasks
+	ArrayID     int64            // the job array of a task, 0 if the job is not an array task
+	ArrayIndex  int              // the index of an array task, it is in the environment as ARRAY_INDEX
+	ArrayNext   int              // number of tasks of a job array that left incomingJobs
+	ArrayTasks  map[JobState]int // number of tasks of a job array in each state, only set in replies to the user
+	Cron        string           // the cron job that created the job
+	NotBefore   time.Time        // the job is not scheduled before this time, it is set by the user or as a backoff
+	Priority    int              // jobs with a higher priority are scheduled first and may preempt running jobs
+	Preemptions int              // number of times the job was stopped for a job with a higher priority
+	Preempted   bool             // only set when a RM reports that it stopped the job, the job goes back to the queue
+	Checkpoint  time.Duration    // interval between checkpoints, 0 if the job does not checkpoint
+	Inputs      []StageFile      // files that are staged into the working directory of the job before it runs
+	Outputs     []StageFile      // files that are staged out of the working directory of the job when it completes
+	Reason      FailReason       // what failed if the job is JobFailed for another reason than itself, empty otherwise
+	Command     []string         // the program and its arguments, the job sleeps for its duration if empty
+	Script      string           // a script that the job runs instead of a command
+	Executor    string           // the executor that runs the job, see RegisterExecutor, the RM chooses if empty
+	Usage       ExecStats        // the resource usage of the task, only set in replies to the user and for finished jobs
+	Term        int64            // the term of the leader that scheduled the job, RMs refuse jobs of an older term
+}
+
+// FailReason tells what failed when a job is JobFailed and it was not the job itself, the details are in Job.Error
+type FailReason string
+
+const (
+	FailStageIn  FailReason = "stage_in"  // an input could not be staged in, the job did not run
+	FailStageOut FailReason = "stage_out" // the job ran but an output could not be staged out
+	FailLimit    FailReason = "limit"     // the job was stopped because it exceeded a resource limit of its sandbox
+)
+
+const (
+	rescheduleBackoff    = time.Second     // the delay before a rescheduled job runs again
+	maxRescheduleBackoff = 5 * time.Minute // the backoff doubles with every reschedule up to this
+)
+
+// eligible checks whether the job may be scheduled at now
+func (j Job) eligible(now time.Time) bool {
+	return !j.NotBefore.After(now)
+}
+
+// backoff returns the delay before a job that was rescheduled n times may run again
+func backoff(n int) time.Duration {
+	d := rescheduleBackoff
+	for i := 1; i < n && d < maxRescheduleBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRescheduleBackoff {
+		return maxRescheduleBackoff
+	}
+	return d
+}
+
+// envList returns the environment as sorted KEY=value strings
+func envList(env map[string]string) []string {
+	var res []string
+	for k, v := range env {
+		res = append(res, k+"="+v)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// validate checks the parts of a job that the user sets and that are not checked elsewhere
+func (j Job) validate() error {
+	if e := j.validateFiles(); e != nil {
+		return e
+	}
+	if e := j.validateCallbacks(); e != nil {
+		return e
+	}
+	return j.validateTask()
+}
+
+// clearServerFields resets the fields that only the grid sets, so that a user can't submit a job that looks
+// like it was already scheduled, rescheduled, preempted or finished
+func (j *Job) clearServerFields() {
+	j.ID, j.ResMan, j.ResManID, j.Term = 0, "", "", 0
+	j.State, j.FinishTime, j.Error, j.Reason, j.Usage = JobQueued, time.Time{}, "", "", ExecStats{}
+	j.Reschedules, j.Preemptions, j.Preempted = 0, 0, false
+	j.ArrayID, j.ArrayIndex, j.ArrayNext, j.ArrayTasks = 0, 0, 0, nil
+	j.Cron = ""
 }
 
 func filterJobs(s []Job, fn func(Job) bool) []Job {
@@ -21,6 +129,37 @@ func filterJobs(s []Job, fn func(Job) bool) []Job {
 	return p
 }
 
+// filterJobsByIDs returns the jobs in s that have (or do not have, if keep is false) an ID in ids
+func filterJobsByIDs(s []Job, ids []int64, keep bool) []Job {
+	set := make(map[int64]bool)
+	for _, id := range ids {
+		set[id] = true
+	}
+	return filterJobs(s, func(j Job) bool { return set[j.ID] == keep })
+}
+
+// jobIDs returns the IDs of all the jobs in s
+func jobIDs(s []Job) []int64 {
+	ids := make([]int64, len(s))
+	for i, job := range s {
+		ids[i] = job.ID
+	}
+	return ids
+}
+
+// uniqueIDs returns ids without the repeated IDs, in the order of their first occurrence
+func uniqueIDs(ids []int64) []int64 {
+	seen := make(map[int64]bool)
+	var res []int64
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
 // takeJobs will take at most n jobs from channel `c`
 func takeJobs(n int, c <-chan Job) []Job {
 	var jobs []Job
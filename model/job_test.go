@@ -0,0 +1,62 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUniqueIDs(t *testing.T) {
+	tests := []struct {
+		ids  []int64
+		want []int64
+	}{
+		{nil, nil},
+		{[]int64{1, 2, 3}, []int64{1, 2, 3}},
+		{[]int64{1, 1}, []int64{1}},
+		{[]int64{3, 1, 3, 2, 1}, []int64{3, 1, 2}},
+	}
+	for _, test := range tests {
+		if got := uniqueIDs(test.ids); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("uniqueIDs(%v) = %v, expected %v", test.ids, got, test.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		n    int
+		want time.Duration
+	}{
+		{0, rescheduleBackoff},
+		{1, rescheduleBackoff},
+		{2, 2 * rescheduleBackoff},
+		{3, 4 * rescheduleBackoff},
+		{9, 256 * rescheduleBackoff},
+		{10, maxRescheduleBackoff},
+		{1000, maxRescheduleBackoff},
+	}
+	for _, test := range tests {
+		if got := backoff(test.n); got != test.want {
+			t.Errorf("backoff(%v) = %v, expected %v", test.n, got, test.want)
+		}
+	}
+}
+
+func TestEligible(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		notBefore time.Time
+		want      bool
+	}{
+		{time.Time{}, true},
+		{now.Add(-time.Second), true},
+		{now, true},
+		{now.Add(time.Second), false},
+	}
+	for _, test := range tests {
+		if got := (Job{NotBefore: test.notBefore}).eligible(now); got != test.want {
+			t.Errorf("job not before %v is eligible %v at %v, expected %v", test.notBefore, got, now, test.want)
+		}
+	}
+}
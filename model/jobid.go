@@ -0,0 +1,121 @@
+package model
+
+import (
+	"sync"
+	"time"
+)
+
+// nodeIDBits is the number of low bits of a job ID that hold the ID of the GS that assigned it
+const nodeIDBits = 16
+
+// keyExpiry is how long an idempotency key is remembered
+const keyExpiry = 24 * time.Hour
+
+// Submission records the job IDs that were assigned to a submission with an idempotency key
+type Submission struct {
+	IDs  []int64
+	Time time.Time
+}
+
+// SubmitReply is the reply of AddJobsViaUser, Duplicate is true if the idempotency key was seen before
+// and no new jobs were created
+type SubmitReply struct {
+	IDs       []int64
+	Duplicate bool
+}
+
+// jobIDGen assigns job IDs, an ID is the sequence number followed by the ID of the GS.
+// The IDs are only assigned in the critical section and the sequence number is updated
+// whenever jobs are replicated, so the IDs are unique and ordered by submission.
+// The GS ID makes the IDs unique even if the critical section times out.
+type jobIDGen struct {
+	sync.Mutex
+	nodeID int64
+	seq    int64
+	keys   map[string]Submission // owner and idempotency key to job IDs
+}
+
+func newJobIDGen(nodeID int) *jobIDGen {
+	return &jobIDGen{nodeID: int64(nodeID) & (1<<nodeIDBits - 1), seq: 1, keys: make(map[string]Submission)}
+}
+
+func submissionKey(owner string, key string) string {
+	return owner + "\x00" + key
+}
+
+// assign sets a new ID on every job, the IDs of the tasks of a job array are reserved after the ID of the array
+func (g *jobIDGen) assign(jobs []Job) {
+	g.Lock()
+	defer g.Unlock()
+	for i := range jobs {
+		jobs[i].ID = g.seq<<nodeIDBits | g.nodeID
+		g.seq++
+		if jobs[i].isArray() {
+			g.seq += int64(jobs[i].Array.Count())
+		}
+	}
+}
+
+// lookup finds the IDs of an earlier submission with the same idempotency key
+func (g *jobIDGen) lookup(owner string, key string) ([]int64, bool) {
+	g.Lock()
+	defer g.Unlock()
+	s, ok := g.keys[submissionKey(owner, key)]
+	return s.IDs, ok
+}
+
+// observe updates the sequence number and remembers the idempotency keys of jobs that are added to the queue
+func (g *jobIDGen) observe(jobs []Job) {
+	g.Lock()
+	defer g.Unlock()
+	now := time.Now()
+	fresh := make(map[string][]int64)
+	for _, job := range jobs {
+		seq := job.ID >> nodeIDBits
+		if job.isArray() {
+			seq += int64(job.Array.Count())
+		}
+		if seq >= g.seq {
+			g.seq = seq + 1
+		}
+		if job.SubmitKey == "" {
+			continue
+		}
+		k := submissionKey(job.Owner, job.SubmitKey)
+		if _, ok := g.keys[k]; !ok {
+			fresh[k] = append(fresh[k], job.ID)
+		}
+	}
+	// rescheduled jobs are added again, they must not replace the original submission
+	for k, ids := range fresh {
+		g.keys[k] = Submission{ids, now}
+	}
+	for k, s := range g.keys {
+		if now.Sub(s.Time) > keyExpiry {
+			delete(g.keys, k)
+		}
+	}
+}
+
+// get returns the sequence number and a copy of the idempotency keys
+func (g *jobIDGen) get() (int64, map[string]Submission) {
+	g.Lock()
+	defer g.Unlock()
+	keys := make(map[string]Submission)
+	for k, v := range g.keys {
+		keys[k] = v
+	}
+	return g.seq, keys
+}
+
+// set copies the sequence number and the idempotency keys from another GS
+func (g *jobIDGen) set(seq int64, keys map[string]Submission) {
+	g.Lock()
+	defer g.Unlock()
+	if seq > g.seq {
+		g.seq = seq
+	}
+	for k, v := range keys {
+		g.keys[k] = v
+	}
+}
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJobIDGenAssign(t *testing.T) {
+	array := &ArraySpec{1, 3, 1}
+	tests := []struct {
+		nodeID int
+		seq    int64 // sequence number of the generator before assign
+		jobs   []Job
+		want   []int64
+		next   int64 // sequence number after assign
+	}{
+		{1, 1, []Job{{}, {}}, []int64{1<<nodeIDBits | 1, 2<<nodeIDBits | 1}, 3},
+		{2, 5, []Job{{}}, []int64{5<<nodeIDBits | 2}, 6},
+		// the IDs of the 3 tasks are reserved after the array
+		{1, 1, []Job{{Array: array}, {}}, []int64{1<<nodeIDBits | 1, 5<<nodeIDBits | 1}, 6},
+		// an array task is not an array, it does not reserve IDs
+		{1, 1, []Job{{Array: array, ArrayID: 7}}, []int64{1<<nodeIDBits | 1}, 2},
+		// the node ID is cut to nodeIDBits
+		{1<<nodeIDBits + 3, 1, []Job{{}}, []int64{1<<nodeIDBits | 3}, 2},
+	}
+	for i, test := range tests {
+		g := newJobIDGen(test.nodeID)
+		g.seq = test.seq
+		g.assign(test.jobs)
+		if got := jobIDs(test.jobs); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("test %v: assigned %v, expected %v", i, got, test.want)
+		}
+		if g.seq != test.next {
+			t.Errorf("test %v: next sequence number is %v, expected %v", i, g.seq, test.next)
+		}
+	}
+}
+
+func TestJobIDGenObserve(t *testing.T) {
+	array := &ArraySpec{0, 9, 1}
+	tests := []struct {
+		jobs []Job
+		seq  int64  // sequence number after observe
+		key  string // idempotency key of alice that is looked up
+		ids  []int64
+		ok   bool
+	}{
+		{nil, 1, "k", nil, false},
+		{[]Job{{ID: 4<<nodeIDBits | 2}}, 5, "k", nil, false},
+		{[]Job{{ID: 4<<nodeIDBits | 2, Array: array}}, 15, "k", nil, false},
+		{[]Job{{ID: 2<<nodeIDBits | 2, Owner: "alice", SubmitKey: "k"}, {ID: 3<<nodeIDBits | 2, Owner: "alice", SubmitKey: "k"}},
+			4, "k", []int64{2<<nodeIDBits | 2, 3<<nodeIDBits | 2}, true},
+		// keys are per owner
+		{[]Job{{ID: 2<<nodeIDBits | 2, Owner: "bob", SubmitKey: "k"}}, 3, "k", nil, false},
+	}
+	for i, test := range tests {
+		g := newJobIDGen(1)
+		g.observe(test.jobs)
+		if g.seq != test.seq {
+			t.Errorf("test %v: sequence number is %v, expected %v", i, g.seq, test.seq)
+		}
+		ids, ok := g.lookup("alice", test.key)
+		if ok != test.ok || !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("test %v: lookup gave %v %v, expected %v %v", i, ids, ok, test.ids, test.ok)
+		}
+	}
+}
+
+func TestJobIDGenRescheduled(t *testing.T) {
+	g := newJobIDGen(1)
+	first := []Job{{ID: 2<<nodeIDBits | 1, Owner: "alice", SubmitKey: "k"}, {ID: 3<<nodeIDBits | 1, Owner: "alice", SubmitKey: "k"}}
+	g.observe(first)
+	// a rescheduled job is added again, the submission still has both IDs
+	g.observe(first[1:])
+	ids, _ := g.lookup("alice", "k")
+	if want := jobIDs(first); !reflect.DeepEqual(ids, want) {
+		t.Errorf("lookup gave %v after a reschedule, expected %v", ids, want)
+	}
+}
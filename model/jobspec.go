@@ -0,0 +1,98 @@
+package model
+
+import (
+	"strconv"
+	"time"
+)
+
+// MaxJobsPerRequest is the largest number of jobs that one submission may add, a job array counts as one job
+const MaxJobsPerRequest = 10000
+
+// JobSpec describes jobs in the job files of the CLI and in the body of POST /v1/jobs
+type JobSpec struct {
+	Duration   string      `json:"duration"`
+	Count      int         `json:"count"` // number of copies, default is 1
+	Callbacks  []string    `json:"callbacks"`
+	Array      string      `json:"array"`      // e.g. "1-10000:2", see ParseArraySpec
+	NotBefore  time.Time   `json:"not_before"` // RFC 3339
+	Delay      string      `json:"delay"`      // e.g. "10m", relative to the submission
+	Priority   int         `json:"priority"`
+	Checkpoint string      `json:"checkpoint"` // interval between checkpoints, e.g. "5m"
+	Command    []string    `json:"command"`    // the program and its arguments, the duration is its time limit
+	Script     string      `json:"script"`     // a script that the jobs run instead of a command, a file in job files
+	Executor   string      `json:"executor"`   // e.g. "process", the RM chooses if empty
+	Inputs     []StageFile `json:"inputs"`
+	Outputs    []StageFile `json:"outputs"`
+}
+
+// JobsFromSpecs creates the jobs that specs describe, the delays are relative to now. The number of jobs is
+// checked before they are created, so that a large count is refused instead of filling the memory.
+func JobsFromSpecs(specs []JobSpec, now time.Time) ([]Job, error) {
+	total := 0
+	for _, spec := range specs {
+		if spec.Count < 0 {
+			return nil, errorf(errInvalid, "Invalid count %v", spec.Count)
+		}
+		total += spec.count()
+		if total > MaxJobsPerRequest {
+			return nil, errorf(errInvalid, "More than %v jobs in one request", MaxJobsPerRequest)
+		}
+	}
+
+	jobs := make([]Job, 0, total)
+	for _, spec := range specs {
+		job, e := spec.job(now)
+		if e != nil {
+			return nil, e
+		}
+		for i := 0; i < spec.count(); i++ {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (spec JobSpec) count() int {
+	if spec.Count == 0 {
+		return 1
+	}
+	return spec.Count
+}
+
+// job creates one of the jobs that spec describes
+func (spec JobSpec) job(now time.Time) (Job, error) {
+	job := Job{Callbacks: spec.Callbacks, NotBefore: spec.NotBefore, Priority: spec.Priority, Command: spec.Command,
+		Script: spec.Script, Executor: spec.Executor, Inputs: spec.Inputs, Outputs: spec.Outputs}
+
+	// the duration is the time limit of a command or a script, so it is optional for them
+	task := len(spec.Command) > 0 || spec.Script != ""
+	if spec.Duration != "" || !task {
+		d, e := time.ParseDuration(spec.Duration)
+		if e != nil || d < 0 || (d == 0 && !task) {
+			return job, errorf(errInvalid, "Invalid duration %v", strconv.Quote(spec.Duration))
+		}
+		job.Duration = d
+	}
+	if spec.Array != "" {
+		a, e := ParseArraySpec(spec.Array)
+		if e != nil {
+			return job, e
+		}
+		job.Array = &a
+	}
+	if spec.Delay != "" {
+		delay, e := time.ParseDuration(spec.Delay)
+		if e != nil || delay < 0 {
+			return job, errorf(errInvalid, "Invalid delay %v", strconv.Quote(spec.Delay))
+		}
+		job.NotBefore = now.Add(delay)
+	}
+	if spec.Checkpoint != "" {
+		c, e := time.ParseDuration(spec.Checkpoint)
+		if e != nil || c <= 0 {
+			return job, errorf(errInvalid, "Invalid checkpoint interval %v", strconv.Quote(spec.Checkpoint))
+		}
+		job.Checkpoint = c
+	}
+	return job, nil
+}
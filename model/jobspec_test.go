@@ -0,0 +1,55 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobsFromSpecs(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		specs []JobSpec
+		n     int // number of jobs, -1 if the specs are invalid
+		check func(Job) bool
+	}{
+		{"default count", []JobSpec{{Duration: "5s"}}, 1, func(j Job) bool { return j.Duration == 5*time.Second }},
+		{"count", []JobSpec{{Duration: "5s", Count: 3}, {Duration: "1s"}}, 4, nil},
+		{"negative count", []JobSpec{{Duration: "5s", Count: -1}}, -1, nil},
+		{"count at the limit", []JobSpec{{Duration: "5s", Count: MaxJobsPerRequest}}, MaxJobsPerRequest, nil},
+		{"count above the limit", []JobSpec{{Duration: "5s", Count: MaxJobsPerRequest + 1}}, -1, nil},
+		{"counts add up above the limit", []JobSpec{{Duration: "5s", Count: MaxJobsPerRequest}, {Duration: "5s"}}, -1, nil},
+		{"huge count", []JobSpec{{Duration: "5s", Count: 1 << 62}}, -1, nil},
+		{"missing duration", []JobSpec{{}}, -1, nil},
+		{"zero duration", []JobSpec{{Duration: "0s"}}, -1, nil},
+		{"negative duration", []JobSpec{{Duration: "-1s"}}, -1, nil},
+		{"command without a duration", []JobSpec{{Command: []string{"true"}}}, 1, func(j Job) bool { return j.Duration == 0 }},
+		{"script with a time limit", []JobSpec{{Script: "true", Duration: "1m"}}, 1, func(j Job) bool { return j.Duration == time.Minute }},
+		{"array", []JobSpec{{Duration: "5s", Array: "1-10:2"}}, 1, func(j Job) bool { return *j.Array == ArraySpec{1, 10, 2} }},
+		{"invalid array", []JobSpec{{Duration: "5s", Array: "10-1"}}, -1, nil},
+		{"delay", []JobSpec{{Duration: "5s", Delay: "10m"}}, 1, func(j Job) bool { return j.NotBefore.Equal(now.Add(10 * time.Minute)) }},
+		{"negative delay", []JobSpec{{Duration: "5s", Delay: "-10m"}}, -1, nil},
+		{"checkpoint", []JobSpec{{Duration: "5s", Checkpoint: "5m"}}, 1, func(j Job) bool { return j.Checkpoint == 5*time.Minute }},
+		{"zero checkpoint", []JobSpec{{Duration: "5s", Checkpoint: "0s"}}, -1, nil},
+		{"fields", []JobSpec{{Duration: "5s", Priority: 2, Executor: "process", Command: []string{"echo"}, Callbacks: []string{"http://h/"}}}, 1,
+			func(j Job) bool {
+				return j.Priority == 2 && j.Executor == "process" && j.Command[0] == "echo" && j.Callbacks[0] == "http://h/"
+			}},
+	}
+	for _, test := range tests {
+		jobs, e := JobsFromSpecs(test.specs, now)
+		if test.n < 0 {
+			if e == nil || kindOf(e) != errInvalid {
+				t.Errorf("%v: returned %v jobs and %v, expected a validation error", test.name, len(jobs), e)
+			}
+			continue
+		}
+		if e != nil || len(jobs) != test.n {
+			t.Errorf("%v: returned %v jobs and %v, expected %v jobs", test.name, len(jobs), e, test.n)
+			continue
+		}
+		if test.check != nil && !test.check(jobs[0]) {
+			t.Errorf("%v: unexpected job %+v", test.name, jobs[0])
+		}
+	}
+}
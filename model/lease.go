@@ -0,0 +1,217 @@
+package model
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kc1212/virtual-grid/common"
+)
+
+// leaderLease is the term and the lease of the leader on a GS. Every GS grants the lease to at most one leader
+// at a time, and a leader only schedules while a majority of the GSs (including itself) granted it the lease
+// for its term, so two leaders on either side of a network partition can't both schedule.
+type leaderLease struct {
+	sync.Mutex
+	term       int64     // the highest term of a leader that this GS has seen
+	holder     string    // UUID of the leader that this GS granted the lease to
+	holderTerm int64     // the term that holder was granted the lease for
+	granted    time.Time // the grant of holder ends at this time
+	leading    int64     // the term that I'm the leader of, 0 if I'm not the leader
+	expiry     time.Time // I may schedule until this time as the leader of term leading
+}
+
+// LeaseReply is the reply of a GS to a lease request
+type LeaseReply struct {
+	Granted bool
+	Term    int64  // the highest term that the GS has seen
+	Rival   string // UUID of another leader that holds the lease of the same term, if it was refused because of it
+}
+
+// currentTerm returns the highest term that this GS has seen
+func (l *leaderLease) currentTerm() int64 {
+	l.Lock()
+	defer l.Unlock()
+	return l.term
+}
+
+// observe raises the current term to term, a term that is older than the current one is an error
+func (l *leaderLease) observe(term int64) error {
+	l.Lock()
+	defer l.Unlock()
+	if term < l.term {
+		return fmt.Errorf("term %v is older than the current term %v", term, l.term)
+	}
+	l.term = term
+	return nil
+}
+
+// lead makes me the leader of term, the lease is held once a majority granted it
+func (l *leaderLease) lead(term int64) {
+	l.Lock()
+	defer l.Unlock()
+	l.term, l.leading, l.expiry = term, term, time.Time{}
+}
+
+// grant grants the lease for term to the leader uuid until d from now. The lease is refused if the term is older
+// than the current term, or if another leader holds it, its grant has to end first. The reply names the other
+// leader if it holds the lease of the same term, because two leaders on either side of a partition may be
+// elected with the same term.
+func (l *leaderLease) grant(uuid string, term int64, d time.Duration) LeaseReply {
+	l.Lock()
+	defer l.Unlock()
+	if term < l.term {
+		return LeaseReply{false, l.term, ""}
+	}
+	l.term = term
+	now := time.Now()
+	if l.holder != uuid && now.Before(l.granted) {
+		reply := LeaseReply{false, l.term, ""}
+		if l.holderTerm == term {
+			reply.Rival = l.holder
+		}
+		return reply
+	}
+	l.holder, l.holderTerm, l.granted = uuid, term, now.Add(d)
+	return LeaseReply{true, l.term, ""}
+}
+
+// extend sets the end of my lease if I'm still the leader of term
+func (l *leaderLease) extend(term int64, expiry time.Time) {
+	l.Lock()
+	defer l.Unlock()
+	if l.leading == term && l.term == term {
+		l.expiry = expiry
+	}
+}
+
+// held returns the term that I'm the leader of and whether I hold its lease
+func (l *leaderLease) held() (int64, bool) {
+	l.Lock()
+	defer l.Unlock()
+	return l.leading, l.leading != 0 && l.leading == l.term && time.Now().Before(l.expiry)
+}
+
+// quorum is the majority of all the GSs that joined, GSs are never removed so that a partition can't shrink it
+func (gs *GridSdr) quorum() int {
+	return (len(gs.gsNodes.GetAll())+1)/2 + 1
+}
+
+// nextTerm returns a term that is later than the terms of all the GSs that can be reached
+func (gs *GridSdr) nextTerm() int64 {
+	term := gs.lease.currentTerm()
+	args := gs.rpcArgsForGS(common.TermMsg)
+	for addr := range gs.gsNodes.GetAll() {
+		if t, e := rpcSendMsgToGS(addr, &args); e == nil && int64(t) > term {
+			term = int64(t)
+		}
+	}
+	return term + 1
+}
+
+// requestLease asks all the GSs to grant me the lease for term, it returns the number of grants, the highest term
+// that was seen and whether another leader holds the lease of term on a GS. It stops waiting once a majority
+// granted it or the lease would have ended.
+func (gs *GridSdr) requestLease(term int64) (int, int64, bool) {
+	deadline := time.After(gs.timings.Lease)
+	// a lease request repeats the coordinator message of the term
+	args := gs.rpcArgsForGS(common.CoordinateMsg)
+	args.Term = term
+	addrs := common.SliceFromMap(gs.gsNodes.GetAll())
+	ch := make(chan LeaseReply, len(addrs))
+	for _, addr := range addrs {
+		go func(addr string) {
+			reply, e := rpcRenewLease(addr, &args)
+			if e != nil {
+				reply = LeaseReply{}
+			}
+			ch <- reply
+		}(addr)
+	}
+
+	mine := gs.lease.grant(gs.UUID, term, gs.timings.Lease)
+	grants, seen, rival := 0, mine.Term, mine.Rival != ""
+	if mine.Granted {
+		grants++
+	}
+	for range addrs {
+		if grants >= gs.quorum() {
+			break
+		}
+		select {
+		case reply := <-ch:
+			if reply.Granted {
+				grants++
+			}
+			seen = common.MaxInt64(seen, reply.Term)
+			rival = rival || reply.Rival != ""
+		case <-deadline:
+			return grants, seen, rival
+		}
+	}
+	return grants, seen, rival
+}
+
+// renewLease renews my lease every poll interval while I'm the leader. If a majority did not renew it then
+// I stop scheduling when it ends, and I start a new election if a GS has seen a later term than mine or another
+// leader holds the lease of my term. The latter happens after a partition healed if both sides elected a leader
+// with the same term, the new election ends with a single leader of a later term.
+func (gs *GridSdr) renewLease() {
+	for {
+		time.Sleep(gs.timings.Poll)
+		if gs.leaderID != gs.UUID || gs.inElection.Get().(bool) {
+			continue
+		}
+
+		term, held := gs.lease.held()
+		if term == 0 {
+			continue
+		}
+		start := time.Now()
+		grants, seen, rival := gs.requestLease(term)
+		if grants >= gs.quorum() {
+			// the GSs count their grants from when they received the request, which is after start
+			gs.lease.extend(term, start.Add(gs.timings.Lease))
+			if !held {
+				log.Printf("Lease of term %v granted by %v GSs\n", term, grants)
+			}
+			continue
+		}
+		if held {
+			log.Printf("Lease of term %v renewed by %v GSs, %v are needed\n", term, grants, gs.quorum())
+		}
+		if seen > term {
+			log.Printf("A GS has seen term %v which is later than my term %v, initialising election.\n", seen, term)
+			gs.elect()
+		} else if rival {
+			log.Printf("Another leader holds the lease of my term %v, initialising election.\n", term)
+			gs.elect()
+		}
+	}
+}
+
+// RenewLease RPC grants the lease to the leader in args if its term is current and no other leader holds it
+func (gs *GridSdr) RenewLease(args *RPCArgs, reply *LeaseReply) error {
+	// the leader is only changed by the Bully election, the lease is separate from it
+	*reply = gs.lease.grant(args.UUID, args.Term, gs.timings.Lease)
+	return nil
+}
+
+// checkTerm refuses the jobs if one was scheduled by a leader of an older term than the leaders that sent jobs before,
+// otherwise the term of the jobs becomes the current term of this RM
+func (rm *ResMan) checkTerm(jobs []Job) error {
+	rm.term.Lock()
+	defer rm.term.Unlock()
+	term := rm.term.V.(int64)
+	for _, job := range jobs {
+		if job.Term < term {
+			return fmt.Errorf("job %v was scheduled in term %v but the current term is %v", job.ID, job.Term, term)
+		}
+	}
+	for _, job := range jobs {
+		term = common.MaxInt64(term, job.Term)
+	}
+	rm.term.V = term
+	return nil
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaderLeaseGrant(t *testing.T) {
+	tests := []struct {
+		name       string
+		term       int64  // current term of the GS
+		holder     string // the leader that holds the lease
+		holderTerm int64
+		expired    bool // whether the grant of holder ended
+		uuid       string
+		reqTerm    int64
+		want       LeaseReply
+	}{
+		{"first grant", 0, "", 0, true, "a", 1, LeaseReply{true, 1, ""}},
+		{"older term", 3, "", 0, true, "a", 2, LeaseReply{false, 3, ""}},
+		{"renewed by the holder", 2, "a", 2, false, "a", 2, LeaseReply{true, 2, ""}},
+		{"holder of a later term", 2, "a", 2, false, "a", 3, LeaseReply{true, 3, ""}},
+		{"held by a leader of an older term", 2, "a", 2, false, "b", 3, LeaseReply{false, 3, ""}},
+		{"held by a leader of the same term", 2, "a", 2, false, "b", 2, LeaseReply{false, 2, "a"}},
+		{"grant of the holder ended", 2, "a", 2, true, "b", 2, LeaseReply{true, 2, ""}},
+		{"older term held by another leader", 3, "a", 3, false, "b", 2, LeaseReply{false, 3, ""}},
+	}
+	for _, test := range tests {
+		l := &leaderLease{term: test.term, holder: test.holder, holderTerm: test.holderTerm, granted: time.Now().Add(time.Hour)}
+		if test.expired {
+			l.granted = time.Now().Add(-time.Second)
+		}
+		if got := l.grant(test.uuid, test.reqTerm, time.Minute); got != test.want {
+			t.Errorf("%v: got %+v, expected %+v", test.name, got, test.want)
+		}
+		if l.term < test.reqTerm {
+			t.Errorf("%v: the term is %v after a request of term %v", test.name, l.term, test.reqTerm)
+		}
+		if got := l.grant(test.uuid, test.reqTerm, time.Minute); test.want.Granted && (!got.Granted || l.holder != test.uuid || l.holderTerm != test.reqTerm) {
+			t.Errorf("%v: the lease is not held by %v after it was granted", test.name, test.uuid)
+		}
+	}
+}
+
+func TestLeaderLeaseObserve(t *testing.T) {
+	tests := []struct {
+		term int64
+		seen int64
+		ok   bool
+		want int64 // the term after observe
+	}{
+		{0, 1, true, 1},
+		{2, 2, true, 2},
+		{2, 5, true, 5},
+		{3, 2, false, 3},
+	}
+	for i, test := range tests {
+		l := &leaderLease{term: test.term}
+		if e := l.observe(test.seen); (e == nil) != test.ok {
+			t.Errorf("test %v: observe returned %v, expected ok to be %v", i, e, test.ok)
+		}
+		if got := l.currentTerm(); got != test.want {
+			t.Errorf("test %v: the term is %v, expected %v", i, got, test.want)
+		}
+	}
+}
+
+func TestLeaderLeaseHeld(t *testing.T) {
+	tests := []struct {
+		name   string
+		lead   int64 // the term that I lead
+		extend int64 // the term whose lease is extended
+		expiry time.Duration
+		seen   int64 // a term that is observed afterwards, if not 0
+		want   bool
+	}{
+		{"not extended", 2, 0, 0, 0, false},
+		{"extended", 2, 2, time.Minute, 0, true},
+		{"expired", 2, 2, -time.Second, 0, false},
+		{"extended for another term", 2, 1, time.Minute, 0, false},
+		{"later term seen", 2, 2, time.Minute, 3, false},
+	}
+	for _, test := range tests {
+		l := &leaderLease{}
+		l.lead(test.lead)
+		if test.extend != 0 {
+			l.extend(test.extend, time.Now().Add(test.expiry))
+		}
+		if test.seen != 0 {
+			l.observe(test.seen)
+		}
+		term, held := l.held()
+		if term != test.lead || held != test.want {
+			t.Errorf("%v: held returned %v %v, expected %v %v", test.name, term, held, test.lead, test.want)
+		}
+	}
+}
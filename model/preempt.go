@@ -0,0 +1,173 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"time"
+)
+
+import "github.com/kc1212/virtual-grid/common"
+
+// preemptTimeout is how long the leader waits for a RM to report a preempted job before it asks again
+const preemptTimeout = 10 * time.Second
+
+// maxPreemptBatch is the largest number of jobs that are preempted at once
+const maxPreemptBatch = 100
+
+// PreemptPolicy decides which running jobs may be stopped for queued jobs with a higher priority
+type PreemptPolicy struct {
+	Enabled         bool
+	MinPriorityGap  int             // a job only preempts jobs whose priority is lower by at least this much
+	MaxPreemptions  int             // a job that was preempted this many times is not preempted again, 0 is unlimited
+	MaxUserPriority int             // the highest priority that users who are not admins may set
+	ProtectedUsers  map[string]bool // the jobs of these users are never preempted
+	ProtectedGroups map[string]bool
+}
+
+// preemptFile is the on-disk format of PreemptPolicy
+type preemptFile struct {
+	MinPriorityGap  int      `json:"min_priority_gap"`
+	MaxPreemptions  int      `json:"max_preemptions"`
+	MaxUserPriority int      `json:"max_user_priority"`
+	ProtectedUsers  []string `json:"protected_users"`
+	ProtectedGroups []string `json:"protected_groups"`
+}
+
+// LoadPreemptPolicy reads the preemption policy from a JSON file, an empty path disables preemption
+func LoadPreemptPolicy(path string) (PreemptPolicy, error) {
+	p := PreemptPolicy{MinPriorityGap: 1, ProtectedUsers: make(map[string]bool), ProtectedGroups: make(map[string]bool)}
+	if path == "" {
+		return p, nil
+	}
+
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return p, e
+	}
+	f := preemptFile{MinPriorityGap: 1}
+	if e := json.Unmarshal(b, &f); e != nil {
+		return p, fmt.Errorf("failed to parse preemption file %v: %v", path, e)
+	}
+	if f.MinPriorityGap <= 0 {
+		return p, fmt.Errorf("min_priority_gap in preemption file %v must be positive", path)
+	}
+	if f.MaxPreemptions < 0 {
+		return p, fmt.Errorf("max_preemptions in preemption file %v must not be negative", path)
+	}
+	p.Enabled, p.MinPriorityGap, p.MaxPreemptions, p.MaxUserPriority = true, f.MinPriorityGap, f.MaxPreemptions, f.MaxUserPriority
+	for _, u := range f.ProtectedUsers {
+		p.ProtectedUsers[u] = true
+	}
+	for _, g := range f.ProtectedGroups {
+		p.ProtectedGroups[g] = true
+	}
+	log.Printf("Loaded preemption policy from %v, %v protected users and %v protected groups\n",
+		path, len(p.ProtectedUsers), len(p.ProtectedGroups))
+	return p, nil
+}
+
+// preemptible checks whether job may be stopped at all
+func (p PreemptPolicy) preemptible(job Job) bool {
+	if p.ProtectedUsers[job.Owner] || (job.Group != "" && p.ProtectedGroups[job.Group]) {
+		return false
+	}
+	return p.MaxPreemptions == 0 || job.Preemptions < p.MaxPreemptions
+}
+
+// checkPriority returns an error if the user may not submit job with its priority
+func (p PreemptPolicy) checkPriority(user User, job Job) error {
+	if !user.Admin && job.Priority > p.MaxUserPriority {
+		return errorf(errForbidden, "User %v may not set a priority above %v", user.Name, p.MaxUserPriority)
+	}
+	return nil
+}
+
+// victims returns the scheduled jobs that may be preempted in the order that they should be stopped, the ones
+// with the lowest priority go first, and of those the ones that were scheduled last
+func (p PreemptPolicy) victims(scheduled []Job, pending map[int64]time.Time) []Job {
+	victims := filterJobs(scheduled, func(j Job) bool {
+		_, ok := pending[j.ID]
+		return !ok && p.preemptible(j)
+	})
+	sort.SliceStable(victims, func(i, j int) bool {
+		if victims[i].Priority != victims[j].Priority {
+			return victims[i].Priority < victims[j].Priority
+		}
+		return victims[i].ID > victims[j].ID
+	})
+	return victims
+}
+
+// byPriority sorts jobs by their priority, the highest first, otherwise the order is kept
+func byPriority(jobs []Job) {
+	if len(filterJobs(jobs, func(j Job) bool { return j.Priority != 0 })) == 0 {
+		return
+	}
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].Priority > jobs[j].Priority })
+}
+
+// preemptJobs asks the RMs to stop running jobs with a lower priority for the most urgent queued jobs,
+// the leader calls it when no RM has free workers. pending holds the jobs that were asked to stop but are
+// not reported yet, their workers are counted as free for the most urgent jobs.
+// NOTE: it must run in the scheduleJobs select statement.
+func (gs *GridSdr) preemptJobs(pending map[int64]time.Time) {
+	if !gs.preempt.Enabled || len(gs.incomingJobs) == 0 {
+		return
+	}
+	scheduled := gs.getScheduledJobs()
+	now := time.Now()
+	running := make(map[int64]bool)
+	for _, job := range scheduled {
+		running[job.ID] = true
+	}
+	for id, t := range pending {
+		if !running[id] || now.Sub(t) > preemptTimeout {
+			delete(pending, id)
+		}
+	}
+
+	urgent := gs.takeJobsWithinQuota(maxPreemptBatch)
+	if len(urgent) <= len(pending) {
+		return
+	}
+	urgent = urgent[len(pending):]
+
+	victims := gs.preempt.victims(scheduled, pending)
+	rms := make(map[string][]int64)
+	for _, job := range urgent {
+		if len(victims) == 0 || victims[0].Priority > job.Priority-gs.preempt.MinPriorityGap {
+			break
+		}
+		victim := victims[0]
+		victims = victims[1:]
+		log.Printf("Preempting job %v (priority %v) for job %v (priority %v)\n", victim.ID, victim.Priority, job.ID, job.Priority)
+		rms[gs.rmAddr(victim)] = append(rms[gs.rmAddr(victim)], victim.ID)
+	}
+	for addr, ids := range rms {
+		if _, e := rpcPreemptJobsOnRM(addr, &ids); e != nil {
+			continue
+		}
+		for _, id := range ids {
+			pending[id] = now
+		}
+	}
+}
+
+// requeuePreempted adds the jobs that were preempted by a RM back to incomingJobs for myself and the others
+// NOTE: it must run in the CS.
+func (gs *GridSdr) requeuePreempted(jobs []Job) {
+	if len(jobs) == 0 {
+		return
+	}
+	for i := range jobs {
+		jobs[i].ResMan, jobs[i].ResManID, jobs[i].Preempted = "", "", false
+		jobs[i].FinishTime, jobs[i].State = time.Time{}, JobQueued
+		jobs[i].Preemptions++
+	}
+	r := -1
+	gs.AddJobs(&jobs, &r)
+	rpcJobsGo(common.SliceFromMap(gs.gsNodes.GetAll()), &jobs, rpcSyncJobs)
+}
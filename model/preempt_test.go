@@ -0,0 +1,104 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kc1212/virtual-grid/common"
+)
+
+func TestCheckPriority(t *testing.T) {
+	p := PreemptPolicy{Enabled: true, MinPriorityGap: 1, MaxUserPriority: 10}
+	tests := []struct {
+		user     User
+		priority int
+		allowed  bool
+	}{
+		{User{Name: "alice"}, 0, true},
+		{User{Name: "alice"}, 10, true},
+		{User{Name: "alice"}, -5, true},
+		{User{Name: "alice"}, 11, false},
+		{User{Name: "root", Admin: true}, 11, true},
+		{User{Name: "root", Admin: true}, 1000, true},
+	}
+	for _, test := range tests {
+		e := p.checkPriority(test.user, Job{Priority: test.priority})
+		if (e == nil) != test.allowed || (e != nil && kindOf(e) != errForbidden) {
+			t.Errorf("%v with priority %v: checkPriority returned %v, expected allowed to be %v",
+				test.user.Name, test.priority, e, test.allowed)
+		}
+	}
+}
+
+func TestPreemptVictims(t *testing.T) {
+	p := PreemptPolicy{Enabled: true, MinPriorityGap: 1, MaxPreemptions: 2,
+		ProtectedUsers: map[string]bool{"root": true}, ProtectedGroups: map[string]bool{"ops": true}}
+	tests := []struct {
+		name      string
+		scheduled []Job
+		pending   map[int64]time.Time
+		want      []int64
+	}{
+		{"lowest priority first", []Job{{ID: 1, Priority: 5}, {ID: 2, Priority: -1}, {ID: 3, Priority: 0}}, nil,
+			[]int64{2, 3, 1}},
+		{"scheduled last first", []Job{{ID: 1}, {ID: 3}, {ID: 2}, {ID: 4, Priority: 1}}, nil,
+			[]int64{3, 2, 1, 4}},
+		{"protected user", []Job{{ID: 1, Owner: "root"}, {ID: 2, Owner: "alice"}}, nil,
+			[]int64{2}},
+		{"protected group", []Job{{ID: 1, Owner: "alice", Group: "ops"}, {ID: 2, Owner: "alice", Group: "dev"}}, nil,
+			[]int64{2}},
+		{"preempted too often", []Job{{ID: 1, Preemptions: 2}, {ID: 2, Preemptions: 1}, {ID: 3, Preemptions: 3}}, nil,
+			[]int64{2}},
+		{"already asked to stop", []Job{{ID: 1}, {ID: 2}, {ID: 3}}, map[int64]time.Time{2: time.Now()},
+			[]int64{3, 1}},
+		{"nothing running", nil, nil, nil},
+	}
+	for _, test := range tests {
+		var got []int64
+		for _, job := range p.victims(test.scheduled, test.pending) {
+			got = append(got, job.ID)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: victims are %v, expected %v", test.name, got, test.want)
+		}
+	}
+
+	// without a limit jobs may be preempted any number of times
+	p.MaxPreemptions = 0
+	if !p.preemptible(Job{Preemptions: 100}) {
+		t.Error("a job was protected by MaxPreemptions 0")
+	}
+}
+
+func TestRequeuePreempted(t *testing.T) {
+	shares, _ := LoadFairShareConfig("")
+	gs := InitGridSdr(1, "uuid", []string{"localhost:4001"}, nil, "localhost:4001", "localhost:3333", nil, QuotaTable{},
+		PolicyFIFO, shares, PreemptPolicy{Enabled: true, MinPriorityGap: 1}, WebhookConfig{}, nil, common.DefaultTimings())
+	gs.ready.Set(true)
+	go gs.scheduleJobs()
+	go gs.updateScheduledJobs()
+
+	start := time.Now().Add(-time.Minute)
+	jobs := []Job{
+		{ID: 1, Owner: "alice", Priority: 1, ResMan: "localhost:5001", ResManID: "rm-uuid", Preempted: true,
+			StartTime: start, FinishTime: time.Now(), State: JobScheduled},
+		{ID: 2, Owner: "bob", Preemptions: 1, ResMan: "localhost:5002", ResManID: "rm-uuid-2", Preempted: true,
+			StartTime: start, FinishTime: time.Now(), State: JobScheduled},
+	}
+	gs.requeuePreempted(jobs)
+
+	want := []Job{
+		{ID: 1, Owner: "alice", Priority: 1, Preemptions: 1, StartTime: start, State: JobQueued},
+		{ID: 2, Owner: "bob", Preemptions: 2, StartTime: start, State: JobQueued},
+	}
+	incoming, _ := gs.getJobs()
+	if !reflect.DeepEqual(incoming, want) {
+		t.Errorf("the requeued jobs are %+v, expected %+v", incoming, want)
+	}
+	// nothing happens without jobs
+	gs.requeuePreempted(nil)
+	if incoming, _ := gs.getJobs(); len(incoming) != len(want) {
+		t.Errorf("%v incoming jobs after requeueing no jobs, expected %v", len(incoming), len(want))
+	}
+}
@@ -0,0 +1,130 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, the unit of the CPU times in /proc, it is 100 on every architecture that Linux supports
+const clockTicks = 100
+
+// procStat is what the RM reads about one process from /proc
+type procStat struct {
+	pgrp    int
+	cpuTime time.Duration // user and system time of the process and of its children that it waited for
+	rss     int64         // bytes
+}
+
+// readProcStat reads /proc/<pid>/stat
+func readProcStat(pid int) (procStat, error) {
+	b, e := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if e != nil {
+		return procStat{}, e
+	}
+	return parseProcStat(string(b))
+}
+
+// parseProcStat parses the content of /proc/<pid>/stat
+func parseProcStat(s string) (procStat, error) {
+	// the command name is in parentheses and may contain spaces, the fields after it start at the state
+	f := strings.Fields(s[strings.LastIndexByte(s, ')')+1:])
+	if len(f) < 22 {
+		return procStat{}, os.ErrInvalid
+	}
+	field := func(i int) int64 {
+		n, _ := strconv.ParseInt(f[i-3], 10, 64)
+		return n
+	}
+	ticks := field(14) + field(15) + field(16) + field(17)
+	return procStat{
+		pgrp:    int(field(5)),
+		cpuTime: time.Duration(ticks) * time.Second / clockTicks,
+		rss:     field(24) * int64(os.Getpagesize()),
+	}, nil
+}
+
+// readProcIO returns the bytes that a process read from and wrote to storage according to /proc/<pid>/io
+func readProcIO(pid int) (int64, int64, error) {
+	b, e := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "io"))
+	if e != nil {
+		return 0, 0, e
+	}
+	read, write := parseProcIO(string(b))
+	return read, write, nil
+}
+
+// parseProcIO returns read_bytes and write_bytes from the content of /proc/<pid>/io
+func parseProcIO(s string) (int64, int64) {
+	var read, write int64
+	for _, line := range strings.Split(s, "\n") {
+		f := strings.Fields(line)
+		if len(f) != 2 {
+			continue
+		}
+		n, _ := strconv.ParseInt(f[1], 10, 64)
+		switch f[0] {
+		case "read_bytes:":
+			read = n
+		case "write_bytes:":
+			write = n
+		}
+	}
+	return read, write
+}
+
+// groupPids returns the processes in the process group pgid
+func groupPids(pgid int) []int {
+	entries, e := ioutil.ReadDir("/proc")
+	if e != nil {
+		return nil
+	}
+	var pids []int
+	for _, entry := range entries {
+		pid, e := strconv.Atoi(entry.Name())
+		if e != nil {
+			continue
+		}
+		if s, e := readProcStat(pid); e == nil && s.pgrp == pgid {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// cgroupPids returns the processes in a cgroup v2 directory
+func cgroupPids(dir string) []int {
+	b, e := ioutil.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if e != nil {
+		return nil
+	}
+	var pids []int
+	for _, f := range strings.Fields(string(b)) {
+		if pid, e := strconv.Atoi(f); e == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// sampleProcs adds up the usage of the processes, processes that exited in the meantime are skipped
+func sampleProcs(pids []int) ExecStats {
+	var s ExecStats
+	for _, pid := range pids {
+		st, e := readProcStat(pid)
+		if e != nil {
+			continue
+		}
+		s.CPUTime += st.cpuTime
+		s.RSS += st.rss
+		// the io file is only readable by the owner of the process and by root
+		if read, write, e := readProcIO(pid); e == nil {
+			s.ReadBytes += read
+			s.WriteBytes += write
+		}
+	}
+	return s
+}
@@ -0,0 +1,58 @@
+package model
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseProcStat(t *testing.T) {
+	page := int64(os.Getpagesize())
+	tests := []struct {
+		name  string
+		stat  string
+		want  procStat
+		valid bool
+	}{
+		{"sleep", "1234 (sleep) S 1 1230 1230 0 -1 4194560 100 0 0 0 250 50 30 20 20 0 1 0 100 10000000 512 " +
+			"18446744073709551615 1 1 0 0 0 0 0 0 0 0 0 0 17 0 0 0 0 0 0\n",
+			procStat{pgrp: 1230, cpuTime: 3500 * time.Millisecond, rss: 512 * page}, true},
+		// the command name may contain spaces and parentheses
+		{"odd name", "1234 (my (odd) cmd) R 1 77 77 0 -1 0 0 0 0 0 1 2 0 0 20 0 1 0 100 0 3 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0\n",
+			procStat{pgrp: 77, cpuTime: 30 * time.Millisecond, rss: 3 * page}, true},
+		{"zombie", "1234 (sh) Z 1 1230 1230 0 -1 4227084 0 0 0 0 0 0 0 0 20 0 1 0 100 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0\n",
+			procStat{pgrp: 1230}, true},
+		{"truncated", "1234 (sleep) S 1 1230 1230 0 -1", procStat{}, false},
+		{"empty", "", procStat{}, false},
+	}
+	for _, test := range tests {
+		got, e := parseProcStat(test.stat)
+		if (e == nil) != test.valid || got != test.want {
+			t.Errorf("%v: parsed %+v, %v, expected %+v", test.name, got, e, test.want)
+		}
+	}
+
+	// the stat of this process is parsed like the fixtures
+	if s, e := readProcStat(os.Getpid()); e != nil || s.pgrp != syscall.Getpgrp() || s.rss <= 0 {
+		t.Errorf("the stat of this process is %+v, %v", s, e)
+	}
+}
+
+func TestParseProcIO(t *testing.T) {
+	tests := []struct {
+		io    string
+		read  int64
+		write int64
+	}{
+		{"rchar: 3980\nwchar: 10\nsyscr: 9\nsyscw: 1\nread_bytes: 4096\nwrite_bytes: 8192\ncancelled_write_bytes: 0\n", 4096, 8192},
+		{"read_bytes: 512\n", 512, 0},
+		{"", 0, 0},
+		{"read_bytes 1\nwrite_bytes: x\n", 0, 0},
+	}
+	for _, test := range tests {
+		if read, write := parseProcIO(test.io); read != test.read || write != test.write {
+			t.Errorf("%q: read %v and wrote %v, expected %v and %v", test.io, read, write, test.read, test.write)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package model
+
+import "github.com/kc1212/virtual-grid/common"
+
+// publicGridSdr only has the methods of GridSdrAPI, the kinds of their errors are sent to the clients
+type publicGridSdr struct {
+	gs GridSdrAPI
+}
+
+// the methods call the GS and encode their errors with encodeError
+
+func (p *publicGridSdr) AddJobsViaUser(args *UserJobsArgs, reply *SubmitReply) error {
+	return encodeError(p.gs.AddJobsViaUser(args, reply))
+}
+
+func (p *publicGridSdr) JobStatus(args *UserIDsArgs, reply *[]Job) error {
+	return encodeError(p.gs.JobStatus(args, reply))
+}
+
+func (p *publicGridSdr) ListJobs(creds *Credentials, reply *[]Job) error {
+	return encodeError(p.gs.ListJobs(creds, reply))
+}
+
+func (p *publicGridSdr) GetQueue(creds *Credentials, reply *[]QueueEntry) error {
+	return encodeError(p.gs.GetQueue(creds, reply))
+}
+
+func (p *publicGridSdr) GetLeader(x *int, reply *string) error {
+	return encodeError(p.gs.GetLeader(x, reply))
+}
+
+func (p *publicGridSdr) GetIdentity(x *int, reply *common.Node) error {
+	return encodeError(p.gs.GetIdentity(x, reply))
+}
+
+func (p *publicGridSdr) GetNodes(x *int, reply *[]NodeInfo) error {
+	return encodeError(p.gs.GetNodes(x, reply))
+}
+
+func (p *publicGridSdr) CancelJobs(args *UserIDsArgs, reply *int) error {
+	return encodeError(p.gs.CancelJobs(args, reply))
+}
+
+func (p *publicGridSdr) WaitJobs(args *WaitArgs, reply *WaitReply) error {
+	return encodeError(p.gs.WaitJobs(args, reply))
+}
+
+func (p *publicGridSdr) JobLogs(args *UserIDsArgs, reply *map[int64][]string) error {
+	return encodeError(p.gs.JobLogs(args, reply))
+}
+
+func (p *publicGridSdr) SetQuota(args *QuotaArgs, reply *int) error {
+	return encodeError(p.gs.SetQuota(args, reply))
+}
+
+func (p *publicGridSdr) GetQuotas(creds *Credentials, reply *QuotaTable) error {
+	return encodeError(p.gs.GetQuotas(creds, reply))
+}
+
+func (p *publicGridSdr) GetDeliveries(creds *Credentials, reply *[]Delivery) error {
+	return encodeError(p.gs.GetDeliveries(creds, reply))
+}
+
+func (p *publicGridSdr) WatchEvents(args *WatchArgs, reply *WatchReply) error {
+	return encodeError(p.gs.WatchEvents(args, reply))
+}
+
+func (p *publicGridSdr) Report(args *ReportArgs, reply *[]ReportRow) error {
+	return encodeError(p.gs.Report(args, reply))
+}
+
+func (p *publicGridSdr) CreateCron(args *CronArgs, reply *CronJob) error {
+	return encodeError(p.gs.CreateCron(args, reply))
+}
+
+func (p *publicGridSdr) ListCrons(creds *Credentials, reply *[]CronJob) error {
+	return encodeError(p.gs.ListCrons(creds, reply))
+}
+
+func (p *publicGridSdr) PauseCron(args *CronNameArgs, reply *CronJob) error {
+	return encodeError(p.gs.PauseCron(args, reply))
+}
+
+func (p *publicGridSdr) DeleteCron(args *CronNameArgs, reply *int) error {
+	return encodeError(p.gs.DeleteCron(args, reply))
+}
+
+// publicResMan only has the methods of ResManAPI, the kinds of their errors are sent to the clients
+type publicResMan struct {
+	rm ResManAPI
+}
+
+// the methods call the RM and encode their errors with encodeError
+
+func (p *publicResMan) AddJobsViaUser(args *UserJobsArgs, reply *SubmitReply) error {
+	return encodeError(p.rm.AddJobsViaUser(args, reply))
+}
+
+func (p *publicResMan) GetLogs(args *UserIDsArgs, reply *map[int64][]string) error {
+	return encodeError(p.rm.GetLogs(args, reply))
+}
+
+func (p *publicResMan) GetIdentity(x *int, reply *common.Node) error {
+	return encodeError(p.rm.GetIdentity(x, reply))
+}
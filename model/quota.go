@@ -0,0 +1,146 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+)
+
+// Quota limits the number of jobs of a user or a group, zero means unlimited
+type Quota struct {
+	MaxRunning int `json:"max_running"` // jobs that are scheduled on RMs
+	MaxQueued  int `json:"max_queued"`  // jobs that are in incomingJobs
+}
+
+// QuotaTable holds the quotas of users and groups, it is replicated between the GSs
+type QuotaTable struct {
+	Users  map[string]Quota `json:"users"`
+	Groups map[string]Quota `json:"groups"`
+}
+
+// QuotaArgs is the RPC argument for changing the quota of a user or a group
+type QuotaArgs struct {
+	Creds   Credentials
+	Name    string
+	IsGroup bool
+	Quota   Quota
+}
+
+// jobCounts counts jobs per user and per group
+type jobCounts struct {
+	users  map[string]int
+	groups map[string]int
+}
+
+// syncedQuotas is a QuotaTable with RWMutex, the maps are never modified in place
+type syncedQuotas struct {
+	sync.RWMutex
+	t QuotaTable
+}
+
+// LoadQuotaTable reads the initial quotas from a JSON file, an empty path gives an empty table
+func LoadQuotaTable(path string) (QuotaTable, error) {
+	t := QuotaTable{make(map[string]Quota), make(map[string]Quota)}
+	if path == "" {
+		return t, nil
+	}
+
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return t, e
+	}
+	if e := json.Unmarshal(b, &t); e != nil {
+		return t, fmt.Errorf("failed to parse quota file %v: %v", path, e)
+	}
+	if t.Users == nil {
+		t.Users = make(map[string]Quota)
+	}
+	if t.Groups == nil {
+		t.Groups = make(map[string]Quota)
+	}
+	log.Printf("Loaded quotas for %v users and %v groups from %v\n", len(t.Users), len(t.Groups), path)
+	return t, nil
+}
+
+func countJobs(jobs []Job) jobCounts {
+	c := jobCounts{make(map[string]int), make(map[string]int)}
+	for _, job := range jobs {
+		c.add(job)
+	}
+	return c
+}
+
+func (c jobCounts) add(job Job) {
+	c.users[job.Owner] += job.taskCount()
+	if job.Group != "" {
+		c.groups[job.Group] += job.taskCount()
+	}
+}
+
+// get returns a copy of the table
+func (q *syncedQuotas) get() QuotaTable {
+	q.RLock()
+	defer q.RUnlock()
+	return q.t
+}
+
+// set replaces the whole table
+func (q *syncedQuotas) set(t QuotaTable) {
+	q.Lock()
+	defer q.Unlock()
+	q.t = t
+}
+
+// setOne changes the quota of one user or group, a zero quota removes the entry
+func (q *syncedQuotas) setOne(args QuotaArgs) {
+	q.Lock()
+	defer q.Unlock()
+	old := q.t.Users
+	if args.IsGroup {
+		old = q.t.Groups
+	}
+
+	// copy on write so that readers of the old table are not affected
+	m := make(map[string]Quota)
+	for k, v := range old {
+		m[k] = v
+	}
+	if args.Quota == (Quota{}) {
+		delete(m, args.Name)
+	} else {
+		m[args.Name] = args.Quota
+	}
+
+	if args.IsGroup {
+		q.t.Groups = m
+	} else {
+		q.t.Users = m
+	}
+}
+
+// exceeds checks whether adding n jobs of the owner and group of job to counts would go over the quota selected by sel
+func (t QuotaTable) exceeds(job Job, counts jobCounts, n int, sel func(Quota) int) bool {
+	if max := sel(t.Users[job.Owner]); max > 0 && counts.users[job.Owner]+n > max {
+		return true
+	}
+	if job.Group == "" {
+		return false
+	}
+	if max := sel(t.Groups[job.Group]); max > 0 && counts.groups[job.Group]+n > max {
+		return true
+	}
+	return false
+}
+
+// exceedsRunning checks whether the job has to be held because its owner or group runs too many jobs
+func (t QuotaTable) exceedsRunning(job Job, running jobCounts) bool {
+	// a job array is held if none of its tasks can run
+	return t.exceeds(job, running, 1, func(q Quota) int { return q.MaxRunning })
+}
+
+// exceedsQueued checks whether the job can't be accepted because its owner or group has too many queued jobs
+func (t QuotaTable) exceedsQueued(job Job, queued jobCounts) bool {
+	return t.exceeds(job, queued, job.taskCount(), func(q Quota) int { return q.MaxQueued })
+}
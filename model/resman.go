@@ -1,8 +1,14 @@
 package model
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/rpc"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -14,51 +20,151 @@ import "github.com/kc1212/virtual-grid/discosrv"
 type ResMan struct {
 	common.Node
 	n             int // number of workers
-	gsNodes       *common.SyncedSet
+	gsNodes       *common.Membership
 	tasksChan     chan WorkerTask
 	completedChan chan int64
 	capReq        chan int
 	capResp       chan int
 	tallyChan     chan int
 	discosrvAddr  string
+	users         *UserTable // nil if authentication is disabled
+	jobs          *jobRecords
+	timings       common.Timings
+	bindAddr      string // the address that the RPC server listens on
+	checkpoints   checkpointStore
+	workDir       string                    // local directory for the files of the running jobs
+	storage       map[string]StorageBackend // the backends that files are staged with, by URI scheme
+	sandbox       SandboxConfig
+	executor      string            // the executor of the jobs that don't select one, it depends on the job if empty
+	term          *common.SyncedVal // the latest term of a leader that scheduled jobs here, see checkTerm
 }
 
-// InitResMan initialises and returns a ResMan
-func InitResMan(n int, id int, addr string, dsAddr string) ResMan {
+// ResManAPI is the RPCs of a RM that are served to every client, the other RPCs are only served to the nodes
+// that present the cluster secret
+type ResManAPI interface {
+	AddJobsViaUser(args *UserJobsArgs, reply *SubmitReply) error
+	GetLogs(args *UserIDsArgs, reply *map[int64][]string) error
+	GetIdentity(x *int, reply *common.Node) error
+}
+
+// jobRecord keeps the owner and the log of a job that is (or was) on this RM,
+// cancel is closed to stop the job
+type jobRecord struct {
+	owner     string
+	log       []string
+	cancel    chan struct{}
+	start     time.Time // when a worker started the job
+	finish    time.Time
+	state     JobState // the terminal state once the job is finished
+	err       string
+	reason    FailReason
+	usage     ExecStats // the last sample of the resource usage of the task, the accounting record once it is finished
+	job       Job       // the job as it was scheduled
+	preempted bool      // the job was stopped for a job with a higher priority, it is reported in full so it can be queued again
+}
+
+// jobRecords is a concurrent map of job records
+type jobRecords struct {
+	sync.Mutex
+	m map[int64]*jobRecord
+}
+
+// update runs fn on the record of job id while holding the lock
+func (r *jobRecords) update(id int64, fn func(*jobRecord)) {
+	r.Lock()
+	defer r.Unlock()
+	if rec, ok := r.m[id]; ok {
+		fn(rec)
+	}
+}
+
+// completed returns the jobs with their run times as they should be reported to the GS,
+// preempted jobs are returned in full because the GS queues them again
+func (r *jobRecords) completed(ids []int64) []Job {
+	r.Lock()
+	defer r.Unlock()
+	jobs := make([]Job, len(ids))
+	for i, id := range ids {
+		jobs[i].ID = id
+		if rec, ok := r.m[id]; ok {
+			if rec.preempted {
+				jobs[i] = rec.job
+				jobs[i].Preempted = true
+			}
+			jobs[i].Owner = rec.owner
+			jobs[i].StartTime = rec.start
+			jobs[i].FinishTime = rec.finish
+			jobs[i].State = rec.state
+			jobs[i].Error = rec.err
+			jobs[i].Reason = rec.reason
+			jobs[i].Usage = rec.usage
+		}
+	}
+	return jobs
+}
+
+// logf appends a timestamped line to the log of job id
+func (r *jobRecords) logf(id int64, format string, v ...interface{}) {
+	r.Lock()
+	defer r.Unlock()
+	if rec, ok := r.m[id]; ok {
+		line := time.Now().Format(time.RFC3339) + " " + fmt.Sprintf(format, v...)
+		rec.log = append(rec.log, line)
+	}
+}
+
+// InitResMan initialises and returns a ResMan, addrs are the advertised addresses and the first one is the main address,
+// labels are shown with the node and bindAddr is the address to listen on.
+func InitResMan(n int, id int, uuid string, addrs []string, labels map[string]string, bindAddr string, dsAddr string, users *UserTable, ckpt CheckpointConfig, storage map[string]StorageBackend, sandbox SandboxConfig, executor string, timings common.Timings) ResMan {
 	return ResMan{
-		common.Node{ID: id, Addr: addr, Type: common.RMNode},
+		common.Node{ID: id, Addr: addrs[0], Type: common.RMNode, Addrs: addrs, UUID: uuid, Labels: labels},
 		n,
-		&common.SyncedSet{S: make(map[string]common.IntClient)},
+		common.NewMembership(),
 		make(chan WorkerTask, 1000),
 		make(chan int64),
 		make(chan int),
 		make(chan int),
 		make(chan int),
-		dsAddr}
+		dsAddr,
+		users,
+		&jobRecords{m: make(map[int64]*jobRecord)},
+		timings,
+		bindAddr,
+		checkpointStore{ckpt.Store},
+		ckpt.WorkDir,
+		storage,
+		sandbox,
+		executor,
+		&common.SyncedVal{V: int64(0)}}
 }
 
 // Run starts the ResMan
 func (rm *ResMan) Run() {
-	reply, e := discosrv.ImAliveProbe(rm.Addr, common.RMNode, rm.discosrvAddr)
-	if e != nil {
+	reply, e := discosrv.ImAliveProbe(rm.Node, rm.discosrvAddr)
+	if _, ok := e.(rpc.ServerError); ok {
+		log.Fatalf("Discosrv on %v refused me: %v\n", rm.discosrvAddr, e)
+	} else if e != nil {
 		log.Panicf("Discosrv on %v not online: %v\n", rm.discosrvAddr, e.Error())
 	}
-	rm.notifyAndPopulateGSs(reply.GSs)
+	if e := rm.notifyAndPopulateGSs(reply.GSNodes); e != nil {
+		log.Fatal(e)
+	}
 
-	go discosrv.ImAlivePoll(rm.Addr, common.RMNode, rm.discosrvAddr)
-	go common.RunRPC(rm, rm.Addr)
+	go discosrv.ImAlivePoll(rm.Node, rm.discosrvAddr, rm.timings.Heartbeat)
+	go common.RunRPC("ResMan", rm, &publicResMan{rm}, rm.bindAddr)
 	go runWorkers(rm.n, rm.tasksChan, rm.capReq, rm.capResp, rm.completedChan)
 	go rm.reporting()
 	rm.handleCompletionMsg()
 }
 
 // TODO generalise this pattern of trying all GS until one works
-func (rm *ResMan) updateScheduledJobs(jobs *[]Job) int {
+// updateScheduledJobs returns an error if the GS refused the jobs, e.g. because of quotas
+func (rm *ResMan) updateScheduledJobs(jobs *[]Job) (SubmitReply, error) {
 	log.Printf("Updating %v scheduled jobs to GS\n", len(*jobs))
 	// range over map is random
-	reply := -1
+	var reply SubmitReply
 	for k := range rm.gsNodes.GetAll() {
-		remote, e := rpc.DialHTTP("tcp", k)
+		remote, e := common.DialRPC(k)
 		if e != nil {
 			log.Printf("Node %v is not online, make sure to use the correct address?\n", k)
 			continue
@@ -67,60 +173,111 @@ func (rm *ResMan) updateScheduledJobs(jobs *[]Job) int {
 
 		if e := remote.Call("GridSdr.RecvScheduledJobsFromRM", jobs, &reply); e != nil {
 			log.Printf("Remote call GridSdr.RecvScheduledJobsFromRM failed on %v, %v\n", k, e.Error())
+			if _, ok := e.(rpc.ServerError); ok {
+				return reply, e
+			}
 		} else {
-			return reply
+			return reply, nil
 		}
 	}
 	// unreachable
 	log.Panic("At least one GS should be online!")
-	return -1
+	return reply, nil
 }
 
-func (rm *ResMan) forwardJobs(jobs *[]Job) int {
-	log.Printf("Forwarding %v jobs to GS\n", len(*jobs))
+// forwardJobs returns an error if the GS refused the jobs, e.g. because of quotas
+func (rm *ResMan) forwardJobs(args *UserJobsArgs) (SubmitReply, error) {
+	log.Printf("Forwarding %v jobs to GS\n", len(args.Jobs))
 	// range over map is random
-	reply := -1
+	var reply SubmitReply
 	for k := range rm.gsNodes.GetAll() {
-		remote, e := rpc.DialHTTP("tcp", k)
+		remote, e := common.DialRPC(k)
 		if e != nil {
 			log.Printf("Node %v is not online, make sure to use the correct address?\n", k)
 			continue
 		}
 		defer remote.Close()
 
-		if e := remote.Call("GridSdr.AddJobsViaUser", jobs, &reply); e != nil {
+		if e := remote.Call("GridSdr.AddJobsViaUser", args, &reply); e != nil {
 			log.Printf("Remote call GridSdr.AddJobsViaUser failed on %v, %v\n", k, e.Error())
+			if _, ok := e.(rpc.ServerError); ok {
+				return reply, e
+			}
 		} else {
-			return reply
+			return reply, nil
 		}
 	}
 	// unreachable
 	log.Panic("At least one GS should be online!")
-	return -1
+	return reply, nil
 }
 
-// AddJobsViaUser PRC, only used by CLI
-func (rm *ResMan) AddJobsViaUser(jobs *[]Job, reply *int) error {
+// AddJobsViaUser PRC, only used by CLI, the IDs of the jobs are assigned by a GS and returned in the reply
+func (rm *ResMan) AddJobsViaUser(args *UserJobsArgs, reply *SubmitReply) error {
+	jobs := &args.Jobs
 	log.Printf("%v jobs received from user \n", len(*jobs))
 
-	// forward the jobs to a random GS if I don't have enough capacity, otherwise schedule them
-	if rm.computeCapacity() < len(*jobs) {
-		rm.forwardJobs(jobs)
-	} else {
-		// update address so GridSdr does not re-schedule it
+	user, e := rm.users.Authenticate(args.Creds)
+	if e != nil {
+		return e
+	}
+	now := time.Now()
+	for i := range *jobs {
+		(*jobs)[i].clearServerFields()
+		(*jobs)[i].Owner = user.Name
+		(*jobs)[i].Group = user.Group
+		(*jobs)[i].SubmitKey = args.IdempotencyKey
+		if (*jobs)[i].StartTime.IsZero() {
+			(*jobs)[i].StartTime = now
+		}
+		if e := (*jobs)[i].validate(); e != nil {
+			return e
+		}
+	}
+
+	// forward the jobs to a random GS if I don't have enough capacity, otherwise schedule them,
+	// job arrays are always forwarded because they are split into tasks by the GS,
+	// and so are jobs that may not run yet because they wait in the queue of the GS
+	// and jobs with a priority because the GS checks it
+	deferred := filterJobs(*jobs, func(j Job) bool { return !j.eligible(now) || j.Priority != 0 })
+	if rm.computeCapacity() < len(*jobs) || len(filterJobs(*jobs, Job.isArray)) > 0 || len(deferred) > 0 {
+		r, e := rm.forwardJobs(args)
+		*reply = r
+		return e
+	}
+
+	// bind the jobs to me so GridSdr does not re-schedule them
+	for i := range *jobs {
+		(*jobs)[i].ResMan, (*jobs)[i].ResManID = rm.Addr, rm.UUID
+	}
+	r, e := rm.updateScheduledJobs(jobs)
+	if e != nil {
+		// the GS refused to run them here, so it has to queue them
+		for i := range *jobs {
+			(*jobs)[i].ResMan, (*jobs)[i].ResManID = "", ""
+		}
+		r, e = rm.forwardJobs(args)
+		*reply = r
+		return e
+	}
+
+	*reply = r
+	if !r.Duplicate {
 		for i := range *jobs {
-			(*jobs)[i].ResMan = rm.Addr
+			(*jobs)[i].ID = r.IDs[i]
 		}
-		rm.updateScheduledJobs(jobs)
 		rm.scheduleJobs(jobs)
 	}
-	*reply = 0
 	return nil
 }
 
 // AddJob RPC, only used by GridSdr
 func (rm *ResMan) AddJob(jobs *[]Job, reply *int) error {
 	log.Printf("%v jobs received \n", len(*jobs))
+	if e := rm.checkTerm(*jobs); e != nil {
+		log.Printf("Refusing %v jobs, %v\n", len(*jobs), e)
+		return e
+	}
 
 	rm.scheduleJobs(jobs)
 	*reply = 0
@@ -130,22 +287,256 @@ func (rm *ResMan) AddJob(jobs *[]Job, reply *int) error {
 func (rm *ResMan) scheduleJobs(jobs *[]Job) {
 	// make a channel of jobs, and then schedule them
 	for _, j := range *jobs {
-		// in theory the task can be arbitrary, here we just run Sleep
+		job := j
+		cancel := make(chan struct{})
+		rm.jobs.Lock()
+		rec := &jobRecord{owner: job.Owner, cancel: cancel, job: job}
+		if old, ok := rm.jobs.m[job.ID]; ok {
+			// the job ran here before it was preempted
+			rec.log = old.log
+		}
+		rm.jobs.m[job.ID] = rec
+		rm.jobs.Unlock()
+		rm.jobs.logf(job.ID, "queued on %v", rm.Addr)
+
 		task := func() (interface{}, error) {
-			time.Sleep(j.Duration)
+			rm.jobs.update(job.ID, func(rec *jobRecord) { rec.start = time.Now() })
+			state, reason, e := rm.runJob(job, cancel)
+			rm.jobs.update(job.ID, func(rec *jobRecord) {
+				rec.finish = time.Now()
+				rec.state = state
+				if e != nil {
+					rec.reason, rec.err = reason, e.Error()
+				}
+			})
 			return 0, nil
 		}
-		rm.tasksChan <- WorkerTask{task, j.ID}
+		rm.tasksChan <- WorkerTask{task, job.ID}
 	}
 }
 
+// runJob stages in the inputs of job, runs it until it finishes or cancel is closed, and stages out its outputs,
+// a job that could not be staged fails with the reason of the failure
+func (rm *ResMan) runJob(job Job, cancel chan struct{}) (JobState, FailReason, error) {
+	dir, e := rm.stageIn(job)
+	if e != nil {
+		rm.jobs.logf(job.ID, "stage in failed: %v", e)
+		return JobFailed, FailStageIn, e
+	}
+	if dir != "" {
+		defer os.RemoveAll(dir)
+	}
+	scratch := filepath.Join(rm.workDir, "scratch", strconv.FormatInt(job.ID, 10))
+	os.RemoveAll(scratch)
+	// only the scratch directory itself is private, the user of the sandbox must reach it
+	if e := os.MkdirAll(filepath.Dir(scratch), 0755); e != nil {
+		return JobFailed, "", e
+	}
+	if e := os.Mkdir(scratch, 0700); e != nil {
+		return JobFailed, "", e
+	}
+	defer os.RemoveAll(scratch)
+
+	ck, e := rm.restoreCheckpoint(job)
+	if e != nil {
+		rm.jobs.logf(job.ID, "failed to restore checkpoint, starting from scratch: %v", e)
+	}
+	env := make(map[string]string)
+	for k, v := range job.Env {
+		env[k] = v
+	}
+	env[ScratchDirEnv] = scratch
+	if dir != "" {
+		env[JobDirEnv] = dir
+	}
+	if ck != nil {
+		env[CheckpointDirEnv] = ck.dir
+	}
+	rm.jobs.logf(job.ID, "environment %v", strings.Join(envList(env), " "))
+
+	// the task runs in the job directory if it stages files
+	cwd, dirs := scratch, []string{scratch}
+	if dir != "" {
+		cwd, dirs = dir, append(dirs, dir)
+	}
+	if ck != nil {
+		dirs = append(dirs, ck.dir)
+	}
+	if e := rm.sandbox.chown(dirs...); e != nil {
+		return JobFailed, "", e
+	}
+	spec := TaskSpec{job, cwd, env, rm.sandbox, func(format string, v ...interface{}) { rm.jobs.logf(job.ID, format, v...) }}
+	state, reason, e := rm.runTask(spec, cancel, ck)
+	if ck != nil {
+		ck.discard(state != JobQueued)
+	}
+	if state != JobCompleted || dir == "" {
+		return state, reason, e
+	}
+	if e := rm.stageOut(job, dir); e != nil {
+		rm.jobs.logf(job.ID, "stage out failed: %v", e)
+		return JobFailed, FailStageOut, e
+	}
+	return state, "", nil
+}
+
+// runTask runs the task with its executor until it finishes or cancel is closed. A task that checkpoints is asked
+// to write a checkpoint at every interval and once more before it is preempted, the checkpoint is uploaded when the
+// task had time to write it.
+func (rm *ResMan) runTask(spec TaskSpec, cancel chan struct{}, ck *jobCheckpoint) (JobState, FailReason, error) {
+	job := spec.Job
+	name := rm.executorName(job)
+	ex, e := newExecutor(name)
+	if e == nil {
+		e = ex.Start(spec)
+	}
+	if e != nil {
+		rm.jobs.logf(job.ID, "failed to start with the %v executor: %v", name, e)
+		return JobFailed, "", e
+	}
+	done := make(chan error, 1)
+	go func() { done <- ex.Wait() }()
+
+	sample := time.NewTicker(usageInterval)
+	defer sample.Stop()
+	cp, _ := ex.(Checkpointer)
+	var tick, upload <-chan time.Time
+	if ck != nil && cp == nil {
+		rm.jobs.logf(job.ID, "the %v executor does not checkpoint", name)
+	} else if ck != nil {
+		ticker := time.NewTicker(job.Checkpoint)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case e := <-done:
+			rm.logUsage(job.ID, rm.sampleUsage(job.ID, ex))
+			if e == nil {
+				rm.jobs.logf(job.ID, "finished")
+				return JobCompleted, "", nil
+			}
+			rm.jobs.logf(job.ID, "failed: %v", e)
+			var limit LimitError
+			if errors.As(e, &limit) {
+				return JobFailed, FailLimit, e
+			}
+			return JobFailed, "", e
+		case <-sample.C:
+			rm.sampleUsage(job.ID, ex)
+		case <-tick:
+			if upload == nil {
+				d, e := cp.Checkpoint()
+				if e != nil {
+					rm.jobs.logf(job.ID, "checkpoint failed: %v", e)
+					break
+				}
+				upload = time.After(d)
+			}
+		case <-upload:
+			upload = nil
+			rm.uploadCheckpoint(ck)
+		case <-cancel:
+			state := rm.stopped(job.ID)
+			// the job continues from here when it runs again
+			if state == JobQueued && ck != nil && cp != nil {
+				if d, e := cp.Checkpoint(); e == nil {
+					time.Sleep(d)
+					rm.uploadCheckpoint(ck)
+				}
+			}
+			ex.Kill()
+			<-done
+			rm.logUsage(job.ID, rm.sampleUsage(job.ID, ex))
+			if state == JobQueued {
+				rm.jobs.logf(job.ID, "preempted")
+			} else {
+				rm.jobs.logf(job.ID, "cancelled")
+			}
+			return state, "", nil
+		}
+	}
+}
+
+// stopped returns the state of a job that was stopped, JobQueued if it was preempted and JobCancelled otherwise
+func (rm *ResMan) stopped(id int64) JobState {
+	state := JobCancelled
+	rm.jobs.update(id, func(rec *jobRecord) {
+		if rec.preempted {
+			state = JobQueued
+		}
+	})
+	return state
+}
+
+// CancelJobs RPC, only used by GridSdr, it stops the given jobs if they are on this RM
+func (rm *ResMan) CancelJobs(ids *[]int64, reply *int) error {
+	*reply = rm.stopJobs(*ids, false)
+	log.Printf("Cancelled %v jobs\n", *reply)
+	return nil
+}
+
+// PreemptJobs RPC, only used by the leader, it stops the given jobs if they are on this RM
+// and reports them as preempted so that they are queued again
+func (rm *ResMan) PreemptJobs(ids *[]int64, reply *int) error {
+	*reply = rm.stopJobs(*ids, true)
+	log.Printf("Preempted %v jobs\n", *reply)
+	return nil
+}
+
+// stopJobs stops the jobs that are not finished yet and returns how many were stopped
+func (rm *ResMan) stopJobs(ids []int64, preempt bool) int {
+	rm.jobs.Lock()
+	defer rm.jobs.Unlock()
+	cnt := 0
+	for _, id := range ids {
+		rec, ok := rm.jobs.m[id]
+		if !ok || rec.cancel == nil {
+			continue
+		}
+		rec.preempted = preempt
+		close(rec.cancel)
+		rec.cancel = nil
+		cnt++
+	}
+	return cnt
+}
+
+// GetLogs RPC, returns the logs of the given jobs that ran on this RM and are accessible by the user
+func (rm *ResMan) GetLogs(args *UserIDsArgs, reply *map[int64][]string) error {
+	user, e := rm.users.Authenticate(args.Creds)
+	if e != nil {
+		return e
+	}
+
+	rm.jobs.Lock()
+	defer rm.jobs.Unlock()
+	logs := make(map[int64][]string)
+	for _, id := range args.IDs {
+		rec, ok := rm.jobs.m[id]
+		if !ok {
+			continue
+		}
+		if !user.CanAccess(Job{ID: id, Owner: rec.owner}) {
+			return errorf(errForbidden, "User %v may not access job %v", user.Name, id)
+		}
+		logs[id] = append([]string(nil), rec.log...)
+	}
+	*reply = logs
+	return nil
+}
+
 // RecvMsg PRC call
 func (rm *ResMan) RecvMsg(args *RPCArgs, reply *int) error {
 	// log.Printf("Msg received %v\n", *args)
 	*reply = -1
 	if args.Type == common.RMUpMsg {
+		node := args.node(common.GSNode)
+		if e := rm.gsNodes.CheckJoin(node); e != nil {
+			return e
+		}
 		*reply = rm.ID
-		rm.gsNodes.SetInt(args.Addr, int64(args.ID))
+		rm.gsNodes.Join(node, "")
 
 	} else if args.Type == common.GetCapacityMsg {
 		*reply = rm.computeCapacity()
@@ -162,21 +553,41 @@ func (rm *ResMan) computeCapacity() int {
 	return cap
 }
 
-func (rm *ResMan) notifyAndPopulateGSs(nodes []string) {
+// notifyAndPopulateGSs tells the GSs that I'm online, it returns an error if a GS refused me, e.g. because my ID is taken
+func (rm *ResMan) notifyAndPopulateGSs(nodes []common.Node) error {
 	// NOTE: does RM doesn't use a clock, hence the zero
-	arg := RPCArgs{rm.ID, rm.Addr, common.RMUpMsg, 0}
+	arg := RPCArgs{ID: rm.ID, Addr: rm.Addr, Type: common.RMUpMsg, Clock: 0, Addrs: rm.Addrs, UUID: rm.UUID, Labels: rm.Labels}
 	wg := sync.WaitGroup{}
+	refused := &common.SyncedVal{}
 	for _, node := range nodes {
 		wg.Add(1)
-		go func(addr string) {
+		go func(node common.Node) {
 			defer wg.Done()
-			id, e := rpcSendMsgToGS(addr, &arg)
-			if e == nil {
-				rm.gsNodes.SetInt(addr, int64(id))
+			addr, e := common.PickAddr(node.Addrs)
+			if e != nil {
+				log.Println(e)
+				return
+			}
+			_, e = rpcSendMsgToGS(addr, &arg)
+			if _, ok := e.(rpc.ServerError); ok {
+				refused.Set(e)
+			} else if e == nil {
+				rm.gsNodes.Join(node, addr)
 			}
 		}(node)
 	}
 	wg.Wait()
+	if e, ok := refused.Get().(error); ok {
+		return e
+	}
+	return nil
+}
+
+// GetIdentity returns who I am, it is used to detect nodes with the same ID or UUID
+func (rm *ResMan) GetIdentity(x *int, reply *common.Node) error {
+	// doesn't matter what x is
+	*reply = rm.Node
+	return nil
 }
 
 func (rm *ResMan) reporting() {
@@ -208,9 +619,9 @@ func (rm *ResMan) handleCompletionMsg() {
 		}
 	}()
 
-	// send the ids to GS every 100ms
+	// send the ids to GS every tick
 	for {
-		time.Sleep(100 * time.Millisecond)
+		time.Sleep(rm.timings.Tick)
 		if len(ids) == 0 {
 			continue
 		}
@@ -220,8 +631,9 @@ func (rm *ResMan) handleCompletionMsg() {
 		log.Printf("Completed %v jobs.\n", len(ids))
 
 		// range over map is random so this is ok
+		jobs := rm.jobs.completed(ids)
 		for k := range rm.gsNodes.GetAll() {
-			_, e := rpcSyncCompletedJobs(k, &ids)
+			_, e := rpcSyncCompletedJobs(k, &jobs)
 			if e == nil {
 				break
 			}
@@ -0,0 +1,385 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kc1212/virtual-grid/common"
+)
+
+// proxiedHeader is set on the requests that a GS forwards to the leader, they are never forwarded again
+const proxiedHeader = "X-Vgrid-Proxied"
+
+// maxRequestBody is the largest request body that the REST gateway accepts
+const maxRequestBody = 1 << 20
+
+// restSubmit is the body of POST /v1/jobs, the idempotency key may also be sent in the Idempotency-Key header
+type restSubmit struct {
+	Jobs           []JobSpec `json:"jobs"`
+	IdempotencyKey string    `json:"idempotency_key"`
+}
+
+// restJob is how a job is shown by the REST gateway
+type restJob struct {
+	ID          int64          `json:"id"`
+	Owner       string         `json:"owner"`
+	Group       string         `json:"group,omitempty"`
+	State       string         `json:"state"`
+	ResMan      string         `json:"rm,omitempty"`
+	ResManID    string         `json:"rm_id,omitempty"`
+	Duration    string         `json:"duration"`
+	StartTime   time.Time      `json:"start_time"`
+	FinishTime  *time.Time     `json:"finish_time,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	Array       string         `json:"array,omitempty"`
+	ArrayID     int64          `json:"array_id,omitempty"`
+	ArrayIndex  *int           `json:"array_index,omitempty"`
+	Tasks       map[string]int `json:"tasks,omitempty"` // number of tasks of a job array in each state
+	Cron        string         `json:"cron,omitempty"`
+	NotBefore   *time.Time     `json:"not_before,omitempty"`
+	Priority    int            `json:"priority"`
+	Preemptions int            `json:"preemptions,omitempty"`
+	Checkpoint  string         `json:"checkpoint,omitempty"`
+	Command     []string       `json:"command,omitempty"`
+	Script      string         `json:"script,omitempty"`
+	Executor    string         `json:"executor,omitempty"`
+	Inputs      []StageFile    `json:"inputs,omitempty"`
+	Outputs     []StageFile    `json:"outputs,omitempty"`
+	Reason      string         `json:"reason,omitempty"` // what failed, e.g. stage_in
+	Usage       *restUsage     `json:"usage,omitempty"`
+	Term        int64          `json:"term,omitempty"` // the term of the leader that scheduled the job
+}
+
+// restUsage is how the resource usage of a task or a RM is shown by the REST gateway
+type restUsage struct {
+	CPUTime    string `json:"cpu_time"`
+	RSS        int64  `json:"rss"` // bytes
+	MaxRSS     int64  `json:"max_rss"`
+	ReadBytes  int64  `json:"read_bytes"`
+	WriteBytes int64  `json:"write_bytes"`
+}
+
+// toRESTUsage returns nil if nothing was used, e.g. because the job did not run
+func toRESTUsage(s ExecStats) *restUsage {
+	if s == (ExecStats{}) {
+		return nil
+	}
+	return &restUsage{s.CPUTime.String(), s.RSS, s.MaxRSS, s.ReadBytes, s.WriteBytes}
+}
+
+// restNode is how a GS or a RM is shown by the REST gateway, capacity is -1 for GSs and offline RMs
+type restNode struct {
+	ID       int               `json:"id"`
+	Addr     string            `json:"addr"`
+	Type     string            `json:"type"`
+	Leader   bool              `json:"leader"`
+	Capacity int               `json:"capacity"`
+	UUID     string            `json:"uuid"`
+	Workers  int               `json:"workers,omitempty"`
+	Running  int               `json:"running,omitempty"`
+	Usage    *restUsage        `json:"usage,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+func toRESTJob(job Job) restJob {
+	j := restJob{
+		ID:          job.ID,
+		Owner:       job.Owner,
+		Group:       job.Group,
+		State:       strings.TrimPrefix(job.State.String(), "Job"),
+		ResMan:      job.ResMan,
+		ResManID:    job.ResManID,
+		Duration:    job.Duration.String(),
+		StartTime:   job.StartTime,
+		Error:       job.Error,
+		Cron:        job.Cron,
+		Priority:    job.Priority,
+		Preemptions: job.Preemptions,
+		Command:     job.Command,
+		Script:      job.Script,
+		Executor:    job.Executor,
+		Inputs:      job.Inputs,
+		Outputs:     job.Outputs,
+		Reason:      string(job.Reason),
+		Usage:       toRESTUsage(job.Usage),
+		Term:        job.Term,
+	}
+	if !job.FinishTime.IsZero() {
+		j.FinishTime = &job.FinishTime
+	}
+	if !job.NotBefore.IsZero() {
+		j.NotBefore = &job.NotBefore
+	}
+	if job.Checkpoint > 0 {
+		j.Checkpoint = job.Checkpoint.String()
+	}
+	if job.isArray() {
+		j.Array = job.Array.String()
+		j.Tasks = make(map[string]int)
+		for s, n := range job.ArrayTasks {
+			j.Tasks[strings.TrimPrefix(s.String(), "Job")] = n
+		}
+	} else if job.ArrayID != 0 {
+		j.ArrayID, j.ArrayIndex = job.ArrayID, &job.ArrayIndex
+	}
+	return j
+}
+
+// serveREST registers the REST gateway on the default HTTP mux, it is served on the same address as the RPCs.
+// Requests are forwarded to the leader when I'm not the leader, except GET /v1/leader.
+func (gs *GridSdr) serveREST() {
+	http.HandleFunc("/v1/jobs", gs.viaLeader(gs.restJobs))
+	http.HandleFunc("/v1/jobs/", gs.viaLeader(gs.restJob))
+	http.HandleFunc("/v1/nodes", gs.viaLeader(gs.restNodes))
+	http.HandleFunc("/v1/report", gs.viaLeader(gs.restReport))
+	http.HandleFunc("/v1/leader", gs.restLeader)
+}
+
+// viaLeader wraps a handler so that the request is proxied to the leader if I'm not the leader.
+// The request is served locally if the leader can't be reached, every GS has a copy of the job queues.
+func (gs *GridSdr) viaLeader(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, e := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+		if e != nil {
+			writeError(w, http.StatusRequestEntityTooLarge, e)
+			return
+		}
+		reset := func() { r.Body = ioutil.NopCloser(bytes.NewReader(body)) }
+		reset()
+
+		leader := gs.leaderAddr()
+		if gs.imLeader() || leader == "" || leader == gs.Addr || r.Header.Get(proxiedHeader) != "" {
+			h(w, r)
+			return
+		}
+
+		// the REST gateway is on the RPC listener, so it uses TLS if the RPCs do
+		scheme := "http"
+		if common.TLSClientConfig() != nil {
+			scheme = "https"
+		}
+		proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: scheme, Host: leader})
+		proxy.Transport = &http.Transport{TLSClientConfig: common.TLSClientConfig()}
+		proxy.Director = func(req *http.Request) {
+			req.URL.Scheme = scheme
+			req.URL.Host = leader
+			req.Header.Set(proxiedHeader, gs.Addr)
+		}
+		proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, e error) {
+			log.Printf("Failed to forward %v %v to the leader %v, serving it myself, %v\n", r.Method, r.URL.Path, leader, e)
+			reset()
+			h(w, r)
+		}
+		proxy.ServeHTTP(w, r)
+	}
+}
+
+// restJobs handles POST /v1/jobs to submit jobs and GET /v1/jobs to list the jobs of the user
+func (gs *GridSdr) restJobs(w http.ResponseWriter, r *http.Request) {
+	creds := restCredentials(r)
+	switch r.Method {
+	case http.MethodGet:
+		var jobs []Job
+		if e := gs.ListJobs(&creds, &jobs); e != nil {
+			writeError(w, errorStatus(e), e)
+			return
+		}
+		res := make([]restJob, len(jobs))
+		for i, job := range jobs {
+			res[i] = toRESTJob(job)
+		}
+		writeJSON(w, http.StatusOK, res)
+
+	case http.MethodPost:
+		var body restSubmit
+		if e := json.NewDecoder(r.Body).Decode(&body); e != nil {
+			writeError(w, http.StatusBadRequest, e)
+			return
+		}
+		args := UserJobsArgs{Creds: creds, IdempotencyKey: body.IdempotencyKey}
+		if key := r.Header.Get("Idempotency-Key"); key != "" {
+			args.IdempotencyKey = key
+		}
+		jobs, e := JobsFromSpecs(body.Jobs, time.Now())
+		if e != nil {
+			writeError(w, errorStatus(e), e)
+			return
+		}
+		args.Jobs = jobs
+		if len(args.Jobs) == 0 {
+			writeError(w, http.StatusBadRequest, errors.New("No jobs in the request"))
+			return
+		}
+
+		var reply SubmitReply
+		if e := gs.AddJobsViaUser(&args, &reply); e != nil {
+			writeError(w, errorStatus(e), e)
+			return
+		}
+		status := http.StatusCreated
+		if reply.Duplicate {
+			status = http.StatusOK
+		}
+		writeJSON(w, status, map[string]interface{}{"ids": reply.IDs, "duplicate": reply.Duplicate})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	}
+}
+
+// restJob handles GET /v1/jobs/{id} for the status of a job and DELETE /v1/jobs/{id} to cancel it
+func (gs *GridSdr) restJob(w http.ResponseWriter, r *http.Request) {
+	id, e := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/v1/jobs/"), 10, 64)
+	if e != nil {
+		writeError(w, http.StatusNotFound, errors.New("Invalid job ID"))
+		return
+	}
+	args := UserIDsArgs{restCredentials(r), []int64{id}}
+
+	switch r.Method {
+	case http.MethodGet:
+		var jobs []Job
+		if e := gs.JobStatus(&args, &jobs); e != nil {
+			writeError(w, errorStatus(e), e)
+			return
+		}
+		writeJSON(w, http.StatusOK, toRESTJob(jobs[0]))
+
+	case http.MethodDelete:
+		var n int
+		if e := gs.CancelJobs(&args, &n); e != nil {
+			writeError(w, errorStatus(e), e)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"cancelled": n})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+	}
+}
+
+// restNodes handles GET /v1/nodes
+func (gs *GridSdr) restNodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+		return
+	}
+	var nodes []NodeInfo
+	x := 0
+	gs.GetNodes(&x, &nodes)
+	res := make([]restNode, len(nodes))
+	for i, n := range nodes {
+		res[i] = restNode{n.ID, n.Addr, strings.TrimSuffix(n.Type.String(), "Node"), n.Leader, n.Capacity, n.UUID, n.Workers, n.Running, toRESTUsage(n.Usage), n.Labels}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// restReport handles GET /v1/report, the query parameters are by (comma separated), window, since, until
+// and format (json or csv)
+func (gs *GridSdr) restReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+		return
+	}
+	q := r.URL.Query()
+	args := ReportArgs{Creds: restCredentials(r)}
+	if by := q.Get("by"); by != "" {
+		args.By = strings.Split(by, ",")
+	}
+	var e error
+	if window := q.Get("window"); window != "" {
+		if args.Window, e = time.ParseDuration(window); e != nil || args.Window <= 0 {
+			e = errors.New("Invalid window " + strconv.Quote(window))
+		}
+	}
+	now := time.Now()
+	if e == nil {
+		args.Since, e = ParseReportTime(q.Get("since"), now)
+	}
+	if e == nil {
+		args.Until, e = ParseReportTime(q.Get("until"), now)
+	}
+	if e == nil {
+		e = ValidateReportBy(args.By)
+	}
+	format := q.Get("format")
+	if e == nil && format != "" && format != "json" && format != "csv" {
+		e = errors.New("Invalid format " + strconv.Quote(format) + ", expected json or csv")
+	}
+	if e != nil {
+		writeError(w, http.StatusBadRequest, e)
+		return
+	}
+
+	var rows []ReportRow
+	if e := gs.Report(&args, &rows); e != nil {
+		writeError(w, errorStatus(e), e)
+		return
+	}
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		if e := WriteReportCSV(w, rows); e != nil {
+			log.Printf("Failed to write response, %v\n", e)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, ReportJSON(rows))
+}
+
+// restLeader handles GET /v1/leader, it is answered by every GS with the leader and the latest term that it knows about
+func (gs *GridSdr) restLeader(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"leader": gs.leaderAddr(), "term": gs.lease.currentTerm()})
+}
+
+// restCredentials reads the API token from the "Authorization: Bearer <token>" header
+func restCredentials(r *http.Request) Credentials {
+	return Credentials{Token: strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")}
+}
+
+// errorStatus maps the kinds of the errors of the user facing RPCs to HTTP status codes
+func errorStatus(e error) int {
+	switch kindOf(e) {
+	case errInvalid:
+		return http.StatusBadRequest
+	case errUnauthenticated:
+		return http.StatusUnauthorized
+	case errForbidden:
+		return http.StatusForbidden
+	case errNotFound:
+		return http.StatusNotFound
+	case errConflict:
+		return http.StatusConflict
+	case errQuota:
+		return http.StatusTooManyRequests
+	case errNotReady:
+		return http.StatusServiceUnavailable
+	case errDisabled:
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if e := json.NewEncoder(w).Encode(v); e != nil {
+		log.Printf("Failed to write response, %v\n", e)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, e error) {
+	writeJSON(w, status, map[string]string{"error": strings.TrimSpace(e.Error())})
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestErrorStatus(t *testing.T) {
+	_, arrayErr := ParseArraySpec("5-1")
+	_, fileErr := ParseStageFile("in")
+	tests := []struct {
+		name string
+		e    error
+		want int
+	}{
+		{"callback", Job{Callbacks: []string{"ftp://x"}}.validateCallbacks(), http.StatusBadRequest},
+		{"callback host", newWebhooks(WebhookConfig{Hosts: []string{"hooks.example.com"}}).checkHosts(Job{Callbacks: []string{"http://x"}}), http.StatusForbidden},
+		{"command and script", Job{Command: []string{"true"}, Script: "true"}.validate(), http.StatusBadRequest},
+		{"path", Job{Inputs: []StageFile{{"../x", "shared:x"}}}.validate(), http.StatusBadRequest},
+		{"job array", arrayErr, http.StatusBadRequest},
+		{"stage file", fileErr, http.StatusBadRequest},
+		{"priority", PreemptPolicy{MaxUserPriority: 1}.checkPriority(User{Name: "alice"}, Job{Priority: 2}), http.StatusForbidden},
+		{"token", func() error { _, e := (&UserTable{}).Authenticate(Credentials{"x"}); return e }(), http.StatusUnauthorized},
+		{"finished", errJobFinished(Job{ID: 1}), http.StatusConflict},
+		{"other", errors.New("Can't reach the RMs because I'm not ready"), http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		if test.e == nil {
+			t.Errorf("%v: no error", test.name)
+			continue
+		}
+		if got := errorStatus(test.e); got != test.want {
+			t.Errorf("%v: status of %q is %v, expected %v", test.name, test.e, got, test.want)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package model
 
 import (
 	"log"
-	"net/rpc"
 	"sync"
 )
 
@@ -42,26 +41,88 @@ func rpcSyncScheduledJobs(addr string, jobs *[]Job) (int, error) {
 	return reply, e
 }
 
-func rpcDropJobs(addr string, n int) (int, error) {
-	log.Printf("Dropping %v jobs on %v\n", n, addr)
-	reply, e := common.DialAndCallNoFail(addr, "GridSdr.DropJobs", &n)
+func rpcDropJobs(addr string, ids *[]int64) (int, error) {
+	log.Printf("Dropping %v jobs on %v\n", len(*ids), addr)
+	reply, e := common.DialAndCallNoFail(addr, "GridSdr.DropJobs", ids)
 	return reply, e
 }
 
-func rpcSyncCompletedJobs(addr string, jobs *[]int64) (int, error) {
+func rpcSyncCompletedJobs(addr string, jobs *[]Job) (int, error) {
 	reply, e := common.DialAndCallNoFail(addr, "GridSdr.SyncCompletedJobs", jobs)
 	return reply, e
 }
 
-func rpcRemoveCompletedJobs(addr string, jobs *[]int64) (int, error) {
+func rpcRemoveCompletedJobs(addr string, jobs *[]Job) (int, error) {
 	reply, e := common.DialAndCallNoFail(addr, "GridSdr.RemoveCompletedJobs", jobs)
 	return reply, e
 }
 
+func rpcCancelJobsOnRM(addr string, ids *[]int64) (int, error) {
+	log.Printf("Cancelling %v jobs on RM %v\n", len(*ids), addr)
+	reply, e := common.DialAndCallNoFail(addr, "ResMan.CancelJobs", ids)
+	return reply, e
+}
+
+func rpcPreemptJobsOnRM(addr string, ids *[]int64) (int, error) {
+	log.Printf("Preempting %v jobs on RM %v\n", len(*ids), addr)
+	reply, e := common.DialAndCallNoFail(addr, "ResMan.PreemptJobs", ids)
+	return reply, e
+}
+
+func rpcGetLogsFromRM(addr string, args *UserIDsArgs) (map[int64][]string, error) {
+	reply := make(map[int64][]string)
+	remote, e1 := common.DialRPC(addr)
+	if e1 != nil {
+		log.Printf("Node %v not online (DialHTTP)\n", addr)
+		return reply, e1
+	}
+	defer remote.Close()
+	e2 := common.RemoteCallNoFail(remote, "ResMan.GetLogs", args, &reply)
+	return reply, e2
+}
+
+func rpcGetCapacityFromRM(addr string) (RMCapacity, error) {
+	var reply RMCapacity
+	remote, e1 := common.DialRPC(addr)
+	if e1 != nil {
+		log.Printf("Node %v not online (DialHTTP)\n", addr)
+		return reply, e1
+	}
+	defer remote.Close()
+	x := 0
+	e2 := common.RemoteCallNoFail(remote, "ResMan.GetCapacity", &x, &reply)
+	return reply, e2
+}
+
+// rpcRenewLease asks another GS to grant the lease to the leader in args
+func rpcRenewLease(addr string, args *RPCArgs) (LeaseReply, error) {
+	var reply LeaseReply
+	remote, e1 := common.DialRPC(addr)
+	if e1 != nil {
+		log.Printf("Node %v not online (DialHTTP)\n", addr)
+		return reply, e1
+	}
+	defer remote.Close()
+	e2 := common.RemoteCallNoFail(remote, "GridSdr.RenewLease", args, &reply)
+	return reply, e2
+}
+
+// rpcSyncEvents sends events to another GS, the reply is the sequence number of its last event
+func rpcSyncEvents(addr string, events *[]Event) (int, error) {
+	reply, e := common.DialAndCallNoFail(addr, "GridSdr.SyncEvents", events)
+	return reply, e
+}
+
+func rpcLastEvent(addr string) (int, error) {
+	x := 0
+	reply, e := common.DialAndCallNoFail(addr, "GridSdr.LastEvent", &x)
+	return reply, e
+}
+
 // TODO can't use the generic common.DialAndCallNoFail because return type is complex, fix it
 func rpcGetState(addr string, x int) (GridSdrState, error) {
 	reply := GridSdrState{}
-	remote, e1 := rpc.DialHTTP("tcp", addr)
+	remote, e1 := common.DialRPC(addr)
 	if e1 != nil {
 		log.Printf("Node %v not online (DialHTTP)\n", addr)
 		return reply, e1
@@ -148,6 +209,52 @@ func rpcJobsGo(addrs []string, args *[]Job,
 	return res
 }
 
+func rpcQuotaGo(addrs []string, args *QuotaArgs) int {
+	wg := sync.WaitGroup{}
+	ch := make(chan int, len(addrs))
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(s string) {
+			defer wg.Done()
+			_, e := common.DialAndCallNoFail(s, "GridSdr.SyncQuota", args)
+			if e == nil {
+				ch <- 0
+			}
+		}(addr)
+	}
+	wg.Wait()
+
+	close(ch)
+	res := 0
+	for range ch {
+		res++
+	}
+	return res
+}
+
+func rpcCronGo(addrs []string, cron *CronJob) int {
+	wg := sync.WaitGroup{}
+	ch := make(chan int, len(addrs))
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(s string) {
+			defer wg.Done()
+			_, e := common.DialAndCallNoFail(s, "GridSdr.SyncCron", cron)
+			if e == nil {
+				ch <- 0
+			}
+		}(addr)
+	}
+	wg.Wait()
+
+	close(ch)
+	res := 0
+	for range ch {
+		res++
+	}
+	return res
+}
+
 func rpcGo(addrs []string, args *RPCArgs,
 	rpcFn func(string, *RPCArgs) (int, error)) int {
 
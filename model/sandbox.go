@@ -0,0 +1,236 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScratchDirEnv is the environment variable that holds the scratch directory of a task,
+// it is empty when the task starts and removed when it is finished
+const ScratchDirEnv = "SCRATCH_DIR"
+
+// sandboxInitName is argv[0] of the helper that applies the rlimits of a task and then executes its command
+const sandboxInitName = "resman-sandbox-init"
+
+// sandboxLimitsEnv passes the rlimits to the helper, it is not in the environment of the command
+const sandboxLimitsEnv = "VGRID_SANDBOX_RLIMITS"
+
+// defaultPath is the PATH of a command if the job does not set one
+const defaultPath = "/usr/local/bin:/usr/bin:/bin"
+
+const (
+	killGrace       = 10 * time.Second // how long a command may take to exit after SIGTERM before it is killed
+	checkpointGrace = 5 * time.Second  // how long a command may take to write a checkpoint after it was signalled
+	maxOutputLines  = 1000             // the output of a command that is kept in the job log
+)
+
+// Rlimits are the resource limits of every process of a task, 0 is unlimited
+type Rlimits struct {
+	CPUTime      time.Duration // the task gets SIGXCPU when it used this much CPU time
+	AddressSpace int64         // bytes of virtual memory
+	FileSize     int64         // bytes of the largest file that the task may write, it gets SIGXFSZ beyond it
+	OpenFiles    uint64
+	Processes    uint64 // processes of the user, it is only useful with an unprivileged user
+}
+
+// SandboxConfig configures how a RM runs the commands of jobs, every task runs in its own process group
+// and scratch directory under the rlimits, and in its own cgroup if a cgroup v2 directory is given
+type SandboxConfig struct {
+	Rlimits Rlimits
+	Cgroup  string  // cgroup v2 directory that the RM creates the cgroups of the tasks in, cgroups are not used if empty
+	CPUs    float64 // cpu.max of every task in CPUs, 0 is unlimited
+	Memory  int64   // memory.max of every task in bytes, 0 is unlimited
+	User    string  // the tasks run as this user if not empty, the RM must run as root
+	uid     uint32
+	gid     uint32
+}
+
+// sandboxFile is the on-disk format of SandboxConfig, sizes are in bytes with an optional K, M, G or T suffix
+type sandboxFile struct {
+	CPUTime      string  `json:"cpu_time"` // e.g. "1h"
+	AddressSpace string  `json:"address_space"`
+	FileSize     string  `json:"file_size"`
+	OpenFiles    uint64  `json:"open_files"`
+	Processes    uint64  `json:"processes"`
+	Cgroup       string  `json:"cgroup"`
+	CPUs         float64 `json:"cpus"`
+	Memory       string  `json:"memory"`
+	User         string  `json:"user"`
+}
+
+// LoadSandboxConfig reads the sandbox of the tasks from a JSON file, tasks run without limits if the path is empty
+func LoadSandboxConfig(path string) (SandboxConfig, error) {
+	var c SandboxConfig
+	if path == "" {
+		return c, nil
+	}
+
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return c, e
+	}
+	var f sandboxFile
+	if e := json.Unmarshal(b, &f); e != nil {
+		return c, fmt.Errorf("failed to parse sandbox file %v: %v", path, e)
+	}
+	if f.CPUTime != "" {
+		if c.Rlimits.CPUTime, e = time.ParseDuration(f.CPUTime); e != nil || c.Rlimits.CPUTime < time.Second {
+			return c, fmt.Errorf("cpu_time in sandbox file %v must be at least 1s", path)
+		}
+	}
+	for _, s := range []struct {
+		name  string
+		value string
+		dst   *int64
+	}{{"address_space", f.AddressSpace, &c.Rlimits.AddressSpace}, {"file_size", f.FileSize, &c.Rlimits.FileSize}, {"memory", f.Memory, &c.Memory}} {
+		if *s.dst, e = parseSize(s.value); e != nil {
+			return c, fmt.Errorf("invalid %v in sandbox file %v: %v", s.name, path, e)
+		}
+	}
+	if f.CPUs < 0 {
+		return c, fmt.Errorf("cpus in sandbox file %v must not be negative", path)
+	}
+	c.Rlimits.OpenFiles, c.Rlimits.Processes, c.CPUs = f.OpenFiles, f.Processes, f.CPUs
+
+	if f.User != "" {
+		u, e := user.Lookup(f.User)
+		if e != nil {
+			return c, e
+		}
+		uid, e1 := strconv.ParseUint(u.Uid, 10, 32)
+		gid, e2 := strconv.ParseUint(u.Gid, 10, 32)
+		if e1 != nil || e2 != nil {
+			return c, fmt.Errorf("user %v has no numeric uid and gid", f.User)
+		}
+		if os.Geteuid() != 0 {
+			return c, fmt.Errorf("the tasks can only run as user %v if the ResMan runs as root", f.User)
+		}
+		c.User, c.uid, c.gid = f.User, uint32(uid), uint32(gid)
+	}
+
+	if f.Cgroup != "" {
+		if e := enableControllers(f.Cgroup); e != nil {
+			log.Printf("cgroup v2 is not available in %v, tasks run without CPU and memory limits: %v\n", f.Cgroup, e)
+		} else {
+			c.Cgroup = f.Cgroup
+		}
+	}
+	log.Printf("Loaded sandbox from %v\n", path)
+	return c, nil
+}
+
+// parseSize parses a number of bytes with an optional K, M, G or T suffix, an empty string is 0
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	mult := int64(1)
+	if i := strings.IndexAny(s, "KMGT"); i == len(s)-1 {
+		mult = 1 << (10 * uint(strings.IndexByte("KMGT", s[i])+1))
+		s = s[:i]
+	}
+	n, e := strconv.ParseInt(s, 10, 64)
+	if e != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %v", strconv.Quote(s))
+	}
+	return n * mult, nil
+}
+
+// enableControllers checks that dir is a cgroup v2 directory and lets its children use the cpu and memory controllers,
+// the RM itself must not be in dir because a cgroup with processes can't have controlled children
+func enableControllers(dir string) error {
+	b, e := ioutil.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if e != nil {
+		return e
+	}
+	available := strings.Fields(string(b))
+	for _, c := range []string{"cpu", "memory"} {
+		found := false
+		for _, a := range available {
+			found = found || a == c
+		}
+		if !found {
+			return fmt.Errorf("the %v controller is not available", c)
+		}
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+cpu +memory"), 0644)
+}
+
+// chown gives the unprivileged user of the tasks the directories, nothing is done if the tasks run as the RM user
+func (c SandboxConfig) chown(dirs ...string) error {
+	if c.User == "" {
+		return nil
+	}
+	for _, dir := range dirs {
+		e := filepath.Walk(dir, func(path string, info os.FileInfo, e error) error {
+			if e != nil {
+				return e
+			}
+			return os.Lchown(path, int(c.uid), int(c.gid))
+		})
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// commandEnv returns the environment of a command, it only has the variables of the job and those set by the RM
+func commandEnv(env map[string]string, scratch string) []string {
+	res := []string{"HOME=" + scratch, "TMPDIR=" + scratch, "PATH=" + defaultPath}
+	for k, v := range env {
+		if k == "HOME" || k == "TMPDIR" || k == "PATH" {
+			continue
+		}
+		res = append(res, k+"="+v)
+	}
+	if p, ok := env["PATH"]; ok {
+		res[2] = "PATH=" + p
+	}
+	return res
+}
+
+// outputWriter appends the output of a command to the log of its job line by line
+type outputWriter struct {
+	log   func(format string, v ...interface{})
+	buf   []byte
+	lines int
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := strings.IndexByte(string(w.buf), '\n')
+		if i < 0 {
+			break
+		}
+		w.line(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *outputWriter) line(s string) {
+	w.lines++
+	if w.lines < maxOutputLines {
+		w.log("| %v", s)
+	} else if w.lines == maxOutputLines {
+		w.log("output truncated after %v lines", maxOutputLines)
+	}
+}
+
+// flush logs the last line if it did not end with a newline
+func (w *outputWriter) flush() {
+	if len(w.buf) > 0 {
+		w.line(string(w.buf))
+		w.buf = nil
+	}
+}
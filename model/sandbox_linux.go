@@ -0,0 +1,286 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// CheckpointSignal is sent to the process of a command when it should write a checkpoint
+const CheckpointSignal = syscall.SIGUSR1
+
+// rlimitNproc is RLIMIT_NPROC, it is missing in package syscall
+const rlimitNproc = 6
+
+// SandboxInit runs the helper that applies the rlimits of a task and executes its command,
+// it must be called first in main and it returns immediately in any other process
+func SandboxInit() {
+	if len(os.Args) < 2 || os.Args[0] != sandboxInitName {
+		return
+	}
+	fail := func(format string, v ...interface{}) {
+		fmt.Fprintf(os.Stderr, "sandbox: "+format+"\n", v...)
+		os.Exit(126)
+	}
+
+	var limits Rlimits
+	if e := json.Unmarshal([]byte(os.Getenv(sandboxLimitsEnv)), &limits); e != nil {
+		fail("invalid rlimits: %v", e)
+	}
+	os.Unsetenv(sandboxLimitsEnv)
+	set := func(resource int, v uint64) {
+		if v == 0 {
+			return
+		}
+		if e := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: v, Max: v}); e != nil {
+			fail("failed to set rlimit %v: %v", resource, e)
+		}
+	}
+	if limits.CPUTime > 0 {
+		// the hard limit is a bit higher so the task gets SIGXCPU before SIGKILL
+		secs := uint64((limits.CPUTime + time.Second - 1) / time.Second)
+		if e := syscall.Setrlimit(syscall.RLIMIT_CPU, &syscall.Rlimit{Cur: secs, Max: secs + 1}); e != nil {
+			fail("failed to set the CPU time limit: %v", e)
+		}
+	}
+	set(syscall.RLIMIT_AS, uint64(limits.AddressSpace))
+	set(syscall.RLIMIT_FSIZE, uint64(limits.FileSize))
+	set(syscall.RLIMIT_NOFILE, limits.OpenFiles)
+	set(rlimitNproc, limits.Processes)
+
+	path, e := exec.LookPath(os.Args[1])
+	if e != nil {
+		fail("%v", e)
+	}
+	e = syscall.Exec(path, os.Args[1:], os.Environ())
+	fail("failed to execute %v: %v", path, e)
+}
+
+// process is a command that runs in a sandbox
+type process struct {
+	cmd    *exec.Cmd
+	cgroup string // the cgroup of the task, empty if it has none
+	limits Rlimits
+	out    *outputWriter
+	exited chan struct{} // closed when the command exited, err is the result of Wait
+	err    error
+	usage  ExecStats // the highest usage that was sampled
+}
+
+// startProcess starts argv with the directory and the environment of the task through the sandbox helper,
+// in a new process group and in a new cgroup if they are enabled
+func startProcess(spec TaskSpec, argv []string) (*process, error) {
+	exe, e := os.Executable()
+	if e != nil {
+		return nil, e
+	}
+	limits, e := json.Marshal(spec.Sandbox.Rlimits)
+	if e != nil {
+		return nil, e
+	}
+	p := &process{limits: spec.Sandbox.Rlimits, out: &outputWriter{log: spec.Log}, exited: make(chan struct{})}
+	env := commandEnv(spec.Env, spec.Env[ScratchDirEnv])
+	p.cmd = &exec.Cmd{
+		Path:      exe,
+		Args:      append([]string{sandboxInitName}, argv...),
+		Dir:       spec.Dir,
+		Env:       append(env, sandboxLimitsEnv+"="+string(limits)),
+		Stdout:    p.out,
+		Stderr:    p.out,
+		WaitDelay: killGrace,
+		// the command must not keep running if the RM dies because its job is rescheduled
+		SysProcAttr: &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL},
+	}
+	if spec.Sandbox.User != "" {
+		p.cmd.SysProcAttr.Credential = &syscall.Credential{Uid: spec.Sandbox.uid, Gid: spec.Sandbox.gid, Groups: []uint32{}}
+	}
+
+	if spec.Sandbox.Cgroup != "" {
+		fd, e := p.createCgroup(spec.Sandbox, spec.Job.ID)
+		if e != nil {
+			p.removeCgroup()
+			return nil, fmt.Errorf("failed to create cgroup: %v", e)
+		}
+		defer syscall.Close(fd)
+		// the process is in the cgroup from the start, before it can fork
+		p.cmd.SysProcAttr.UseCgroupFD, p.cmd.SysProcAttr.CgroupFD = true, fd
+	}
+
+	if e := p.cmd.Start(); e != nil {
+		p.removeCgroup()
+		return nil, e
+	}
+	go func() {
+		p.err = p.cmd.Wait()
+		p.out.flush()
+		close(p.exited)
+	}()
+	return p, nil
+}
+
+// createCgroup creates the cgroup of a task with the CPU and memory limits and returns an open descriptor of it
+func (p *process) createCgroup(c SandboxConfig, id int64) (int, error) {
+	p.cgroup = filepath.Join(c.Cgroup, "job-"+strconv.FormatInt(id, 10))
+	if e := os.Mkdir(p.cgroup, 0755); e != nil && !os.IsExist(e) {
+		p.cgroup = ""
+		return -1, e
+	}
+	if c.CPUs > 0 {
+		const period = 100000
+		max := fmt.Sprintf("%v %v", int64(c.CPUs*period), period)
+		if e := ioutil.WriteFile(filepath.Join(p.cgroup, "cpu.max"), []byte(max), 0644); e != nil {
+			return -1, e
+		}
+	}
+	if c.Memory > 0 {
+		if e := ioutil.WriteFile(filepath.Join(p.cgroup, "memory.max"), []byte(strconv.FormatInt(c.Memory, 10)), 0644); e != nil {
+			return -1, e
+		}
+		// swapping would hide that the task exceeds its memory, it is not an error if there is no swap
+		ioutil.WriteFile(filepath.Join(p.cgroup, "memory.swap.max"), []byte("0"), 0644)
+	}
+	return syscall.Open(p.cgroup, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+}
+
+// signal sends sig to every process in the process group of the command
+func (p *process) signal(sig syscall.Signal) {
+	syscall.Kill(-p.cmd.Process.Pid, sig)
+}
+
+// checkpoint asks the command to write a checkpoint, only the command gets the signal and not its children
+// because the default action would terminate them
+func (p *process) checkpoint() error {
+	return p.cmd.Process.Signal(CheckpointSignal)
+}
+
+// wait returns the result of Wait when the command exited
+func (p *process) wait() error {
+	<-p.exited
+	return p.err
+}
+
+// kill stops the command, first with SIGTERM and then with SIGKILL, and returns when it exited
+func (p *process) kill() {
+	p.signal(syscall.SIGTERM)
+	select {
+	case <-p.exited:
+		return
+	case <-time.After(killGrace):
+	}
+	p.signal(syscall.SIGKILL)
+	p.killCgroup()
+	<-p.exited
+}
+
+// stats samples the processes of the command from /proc while it runs, the processes in its cgroup or else in its
+// process group. Once it exited the usage of the command and of its children that were waited for is added.
+func (p *process) stats() ExecStats {
+	select {
+	case <-p.exited:
+	default:
+		pids := groupPids(p.cmd.Process.Pid)
+		if p.cgroup != "" {
+			pids = cgroupPids(p.cgroup)
+		}
+		p.usage = p.usage.merge(sampleProcs(pids))
+		return p.usage
+	}
+	var s ExecStats
+	if state := p.cmd.ProcessState; state != nil {
+		s.CPUTime = state.UserTime() + state.SystemTime()
+		if ru, ok := state.SysUsage().(*syscall.Rusage); ok {
+			// the blocks are 512 bytes like the read_bytes and write_bytes in /proc
+			s.MaxRSS, s.ReadBytes, s.WriteBytes = ru.Maxrss*1024, ru.Inblock*512, ru.Oublock*512
+		}
+	}
+	p.usage = p.usage.merge(s)
+	return p.usage
+}
+
+// cleanup kills the processes that the command left behind and removes its cgroup
+func (p *process) cleanup() {
+	p.signal(syscall.SIGKILL)
+	p.killCgroup()
+	p.removeCgroup()
+}
+
+// killCgroup kills every process in the cgroup, also those that left the process group
+func (p *process) killCgroup() {
+	if p.cgroup != "" {
+		ioutil.WriteFile(filepath.Join(p.cgroup, "cgroup.kill"), []byte("1"), 0644)
+	}
+}
+
+// removeCgroup removes the cgroup, it can take a moment until the killed processes are gone
+func (p *process) removeCgroup() {
+	if p.cgroup == "" {
+		return
+	}
+	for i := 0; i < 10; i++ {
+		if e := os.Remove(p.cgroup); e == nil || os.IsNotExist(e) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// result returns the error of a command that exited with the result e of Wait,
+// it is a LimitError if the command was stopped because it exceeded a limit
+func (p *process) result(e error) error {
+	if e == nil {
+		return nil
+	}
+	if p.oomKilled() {
+		return LimitError("memory limit exceeded")
+	}
+	var exit *exec.ExitError
+	if !errors.As(e, &exit) {
+		return e
+	}
+	ws, ok := exit.Sys().(syscall.WaitStatus)
+	if !ok {
+		return e
+	}
+	// a shell reports a child that was killed by a signal with the exit status 128 + signal
+	sig := ws.Signal()
+	if ws.Exited() && ws.ExitStatus() > 128 {
+		sig = syscall.Signal(ws.ExitStatus() - 128)
+	} else if !ws.Signaled() {
+		return e
+	}
+	switch {
+	case sig == syscall.SIGXCPU:
+		return LimitError("CPU time limit exceeded")
+	case sig == syscall.SIGXFSZ:
+		return LimitError("file size limit exceeded")
+	case ws.Signaled() && sig == syscall.SIGKILL && p.limits.CPUTime > 0 &&
+		exit.ProcessState.UserTime()+exit.ProcessState.SystemTime() >= p.limits.CPUTime:
+		return LimitError("CPU time limit exceeded")
+	}
+	return e
+}
+
+// oomKilled checks whether the memory limit of the cgroup killed a process of the task
+func (p *process) oomKilled() bool {
+	if p.cgroup == "" {
+		return false
+	}
+	b, e := ioutil.ReadFile(filepath.Join(p.cgroup, "memory.events"))
+	if e != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if f := strings.Fields(line); len(f) == 2 && f[0] == "oom_kill" && f[1] != "0" {
+			return true
+		}
+	}
+	return false
+}
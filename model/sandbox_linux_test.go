@@ -0,0 +1,144 @@
+package model
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMain lets the test binary act as the sandbox helper, like the RM binary does
+func TestMain(m *testing.M) {
+	SandboxInit()
+	os.Exit(m.Run())
+}
+
+// taskLog collects the log lines of a task
+type taskLog struct {
+	sync.Mutex
+	lines []string
+}
+
+func (l *taskLog) logf(format string, v ...interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *taskLog) String() string {
+	l.Lock()
+	defer l.Unlock()
+	return strings.Join(l.lines, "\n")
+}
+
+// runTask runs job with x in a new scratch directory until it exits and returns the result of Wait
+func runTask(t *testing.T, x Executor, job Job, limits Rlimits) (*taskLog, error) {
+	scratch, e := ioutil.TempDir("", "scratch")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(scratch)
+	log := &taskLog{}
+	spec := TaskSpec{Job: job, Dir: scratch, Env: map[string]string{ScratchDirEnv: scratch},
+		Sandbox: SandboxConfig{Rlimits: limits}, Log: log.logf}
+	if e := x.Start(spec); e != nil {
+		t.Fatal(e)
+	}
+	return log, x.Wait()
+}
+
+func TestProcessLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		limits  Rlimits
+		limit   string // the LimitError, empty if the error is not one
+		failed  bool
+	}{
+		{"success", "true", Rlimits{}, "", false},
+		{"failure", "exit 3", Rlimits{}, "", true},
+		{"killed", "kill -TERM $$", Rlimits{}, "", true},
+		{"SIGXCPU", "kill -XCPU $$", Rlimits{}, "CPU time limit exceeded", true},
+		{"SIGXFSZ", "kill -XFSZ $$", Rlimits{}, "file size limit exceeded", true},
+		// a shell reports the signal of its child as the exit status 128 + signal
+		{"SIGXCPU of a child", "sh -c 'kill -XCPU $$'; exit $?", Rlimits{}, "CPU time limit exceeded", true},
+		{"file size", "head -c 10000 /dev/zero > out", Rlimits{FileSize: 1000}, "file size limit exceeded", true},
+		{"CPU time", "while :; do :; done", Rlimits{CPUTime: time.Second}, "CPU time limit exceeded", true},
+	}
+	for _, test := range tests {
+		log, e := runTask(t, &processExecutor{}, Job{ID: 1, Command: []string{"/bin/sh", "-c", test.command}}, test.limits)
+		limit, isLimit := e.(LimitError)
+		if (e != nil) != test.failed || isLimit != (test.limit != "") || string(limit) != test.limit {
+			t.Errorf("%v: Wait returned %v, expected the limit %q (log %q)", test.name, e, test.limit, log)
+		}
+	}
+}
+
+func TestOOMKilled(t *testing.T) {
+	tests := []struct {
+		events string // memory.events of the cgroup
+		oom    bool
+	}{
+		{"low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n", true},
+		{"low 0\nhigh 0\nmax 0\noom 0\noom_kill 0\n", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		dir, e := ioutil.TempDir("", "cgroup")
+		if e != nil {
+			t.Fatal(e)
+		}
+		ioutil.WriteFile(filepath.Join(dir, "memory.events"), []byte(test.events), 0644)
+		p := &process{cgroup: dir}
+		_, isLimit := p.result(fmt.Errorf("signal: killed")).(LimitError)
+		if p.oomKilled() != test.oom || isLimit != test.oom {
+			t.Errorf("%q: oomKilled is %v and the error is a limit %v, expected %v", test.events, p.oomKilled(), isLimit, test.oom)
+		}
+		os.RemoveAll(dir)
+	}
+	if e := (&process{}).result(nil); e != nil {
+		t.Errorf("the result of a command that succeeded is %v", e)
+	}
+}
+
+func TestProcessWallTime(t *testing.T) {
+	start := time.Now()
+	log, e := runTask(t, &processExecutor{}, Job{ID: 1, Duration: 200 * time.Millisecond, Command: []string{"sleep", "10"}}, Rlimits{})
+	if want := LimitError("wall time limit of 200ms exceeded"); e != want {
+		t.Errorf("Wait returned %v, expected %v (log %q)", e, want, log)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("the command was killed after %v", d)
+	}
+
+	// commands that finish in time are not limited
+	if log, e := runTask(t, &processExecutor{}, Job{ID: 1, Duration: time.Minute, Command: []string{"true"}}, Rlimits{}); e != nil {
+		t.Errorf("Wait returned %v for a command within its limit (log %q)", e, log)
+	}
+}
+
+func TestScriptInterpreter(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		output string // a line of the output
+	}{
+		// cat prints the script, so it must have been run by the interpreter of the #! line
+		{"#! line", "#!/bin/cat\nnot a shell command\n", "| not a shell command"},
+		{"/bin/sh", "echo $((6 * 7))\n", "| 42"},
+		{"/bin/sh with a comment", "# a comment\necho from sh\n", "| from sh"},
+	}
+	for _, test := range tests {
+		log, e := runTask(t, &scriptExecutor{}, Job{ID: 1, Script: test.script}, Rlimits{})
+		if e != nil || !strings.Contains(log.String(), test.output) {
+			t.Errorf("%v: Wait returned %v, expected the output %q in the log %q", test.name, e, test.output, log)
+		}
+	}
+	if e := (&scriptExecutor{}).Start(TaskSpec{Job: Job{ID: 1}}); e == nil {
+		t.Error("a job without a script was started")
+	}
+}
@@ -0,0 +1,35 @@
+//go:build !linux
+
+package model
+
+import "errors"
+
+// SandboxInit does nothing, commands only run on Linux
+func SandboxInit() {}
+
+// process is a command that runs in a sandbox, it is not supported on this platform
+type process struct{}
+
+func startProcess(spec TaskSpec, argv []string) (*process, error) {
+	return nil, errors.New("commands only run on Linux")
+}
+
+func (p *process) checkpoint() error {
+	return nil
+}
+
+func (p *process) wait() error {
+	return nil
+}
+
+func (p *process) kill() {}
+
+func (p *process) stats() ExecStats {
+	return ExecStats{}
+}
+
+func (p *process) cleanup() {}
+
+func (p *process) result(e error) error {
+	return e
+}
@@ -0,0 +1,318 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JobDirEnv is the environment variable that holds the working directory of a job that stages files,
+// the inputs are there when the job starts and the outputs are taken from there when it completes
+const JobDirEnv = "JOB_DIR"
+
+// the URI schemes of the storage backends
+const (
+	SharedScheme = "shared" // a directory that every RM can reach, e.g. shared:data/input.csv
+	ObjectScheme = "object" // an HTTP object store, e.g. object:results/output.csv
+)
+
+// stagingTimeout is how long a single file may take to be staged to or from an HTTP object store
+const stagingTimeout = time.Hour
+
+// StageFile is a file that is staged between the working directory of a job and a storage backend
+type StageFile struct {
+	Path string `json:"path"` // relative to the working directory of the job
+	URI  string `json:"uri"`  // scheme:key, the scheme selects the storage backend
+}
+
+// ParseStageFile parses a file in the form path=scheme:key
+func ParseStageFile(s string) (StageFile, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return StageFile{}, errorf(errInvalid, "Invalid file %v, expected path=scheme:key", strconv.Quote(s))
+	}
+	f := StageFile{parts[0], parts[1]}
+	return f, f.Validate()
+}
+
+func (f StageFile) String() string {
+	return f.Path + "=" + f.URI
+}
+
+// Validate checks that the path stays in the working directory of the job and that the URI has a known scheme and a key
+func (f StageFile) Validate() error {
+	if e := checkKey(filepath.ToSlash(f.Path)); e != nil {
+		return errorf(errInvalid, "Invalid path %v: %v", strconv.Quote(f.Path), e)
+	}
+	scheme, key := f.parseURI()
+	if scheme != SharedScheme && scheme != ObjectScheme {
+		return errorf(errInvalid, "Invalid URI %v, the scheme must be %v or %v", strconv.Quote(f.URI), SharedScheme, ObjectScheme)
+	}
+	if e := checkKey(key); e != nil {
+		return errorf(errInvalid, "Invalid URI %v: %v", strconv.Quote(f.URI), e)
+	}
+	return nil
+}
+
+func (f StageFile) parseURI() (string, string) {
+	parts := strings.SplitN(f.URI, ":", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
+// checkKey returns an error if key is not a relative slash separated path that stays below its root
+func checkKey(key string) error {
+	if key == "" || path.IsAbs(key) {
+		return errors.New("it must be a relative path")
+	}
+	if c := path.Clean(key); c == ".." || strings.HasPrefix(c, "../") || c == "." {
+		return errors.New("it must not leave its directory")
+	}
+	return nil
+}
+
+// validateFiles checks the inputs and outputs of the job
+func (j Job) validateFiles() error {
+	for _, f := range append(append([]StageFile{}, j.Inputs...), j.Outputs...) {
+		if e := f.Validate(); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// StorageBackend is where the inputs of the jobs are staged in from and their outputs are staged out to,
+// keys are relative slash separated paths
+type StorageBackend interface {
+	Get(key string) (io.ReadCloser, error)
+	Put(key string, r io.Reader) error
+}
+
+// sharedDir is a StorageBackend on a directory that every RM can reach, e.g. over NFS
+type sharedDir struct {
+	dir string
+}
+
+func (s sharedDir) Get(key string) (io.ReadCloser, error) {
+	if e := checkKey(key); e != nil {
+		return nil, fmt.Errorf("invalid key %v: %v", strconv.Quote(key), e)
+	}
+	return os.Open(filepath.Join(s.dir, filepath.FromSlash(key)))
+}
+
+func (s sharedDir) Put(key string, r io.Reader) error {
+	if e := checkKey(key); e != nil {
+		return fmt.Errorf("invalid key %v: %v", strconv.Quote(key), e)
+	}
+	p := filepath.Join(s.dir, filepath.FromSlash(key))
+	if e := os.MkdirAll(filepath.Dir(p), 0755); e != nil {
+		return e
+	}
+	// the file only appears under its name when it is complete
+	f, e := ioutil.TempFile(filepath.Dir(p), ".stage-")
+	if e != nil {
+		return e
+	}
+	defer os.Remove(f.Name())
+	if _, e := io.Copy(f, r); e != nil {
+		f.Close()
+		return e
+	}
+	if e := f.Close(); e != nil {
+		return e
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// httpObjectStore is a StorageBackend on an HTTP object store, an object is read with GET and written with PUT
+// on the base URL followed by its key
+type httpObjectStore struct {
+	base   string
+	token  string // sent as a bearer token if not empty
+	client *http.Client
+}
+
+func (s httpObjectStore) do(method string, key string, body io.Reader) (*http.Response, error) {
+	req, e := http.NewRequest(method, s.base+"/"+key, body)
+	if e != nil {
+		return nil, e
+	}
+	// some object stores refuse uploads without a length
+	if f, ok := body.(*os.File); ok {
+		if info, e := f.Stat(); e == nil {
+			req.ContentLength = info.Size()
+		}
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, e := s.client.Do(req)
+	if e != nil {
+		return nil, e
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%v %v returned %v", method, req.URL, resp.Status)
+	}
+	return resp, nil
+}
+
+func (s httpObjectStore) Get(key string) (io.ReadCloser, error) {
+	resp, e := s.do(http.MethodGet, key, nil)
+	if e != nil {
+		return nil, e
+	}
+	return resp.Body, nil
+}
+
+func (s httpObjectStore) Put(key string, r io.Reader) error {
+	resp, e := s.do(http.MethodPut, key, r)
+	if e != nil {
+		return e
+	}
+	return resp.Body.Close()
+}
+
+// StorageBackends returns the storage backends of a RM by their URI scheme, a backend is only added if it is configured,
+// objectStore is the base URL of an HTTP object store and token is its bearer token
+func StorageBackends(sharedPath string, objectStore string, token string) (map[string]StorageBackend, error) {
+	backends := make(map[string]StorageBackend)
+	if sharedPath != "" {
+		backends[SharedScheme] = sharedDir{sharedPath}
+	}
+	if objectStore != "" {
+		u, e := url.Parse(objectStore)
+		if e != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("Invalid object store URL %v", strconv.Quote(objectStore))
+		}
+		backends[ObjectScheme] = httpObjectStore{strings.TrimRight(objectStore, "/"), token, &http.Client{Timeout: stagingTimeout}}
+	}
+	return backends, nil
+}
+
+// backend returns the storage backend and the key of the URI of f. The file is checked again because the jobs
+// are only validated when they are submitted to a GS, and the paths must never leave their directories on the RM.
+func (rm *ResMan) backend(f StageFile) (StorageBackend, string, error) {
+	if e := f.Validate(); e != nil {
+		return nil, "", e
+	}
+	scheme, key := f.parseURI()
+	b, ok := rm.storage[scheme]
+	if !ok {
+		return nil, "", fmt.Errorf("no %v storage on %v", scheme, rm.Addr)
+	}
+	return b, key, nil
+}
+
+// stageIn creates the working directory of job and copies its inputs into it, it returns the directory,
+// or an empty string if the job does not stage any files
+func (rm *ResMan) stageIn(job Job) (string, error) {
+	if len(job.Inputs) == 0 && len(job.Outputs) == 0 {
+		return "", nil
+	}
+	dir := filepath.Join(rm.workDir, "jobs", strconv.FormatInt(job.ID, 10))
+	os.RemoveAll(dir)
+	if e := os.MkdirAll(dir, 0755); e != nil {
+		return "", e
+	}
+	for _, f := range job.Inputs {
+		if e := rm.stageFileIn(f, dir); e != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("%v: %v", f.URI, e)
+		}
+		rm.jobs.logf(job.ID, "staged in %v", f)
+	}
+	return dir, nil
+}
+
+func (rm *ResMan) stageFileIn(f StageFile, dir string) error {
+	b, key, e := rm.backend(f)
+	if e != nil {
+		return e
+	}
+	r, e := b.Get(key)
+	if e != nil {
+		return e
+	}
+	defer r.Close()
+	p := filepath.Join(dir, filepath.FromSlash(f.Path))
+	if e := os.MkdirAll(filepath.Dir(p), 0755); e != nil {
+		return e
+	}
+	w, e := os.Create(p)
+	if e != nil {
+		return e
+	}
+	if _, e := io.Copy(w, r); e != nil {
+		w.Close()
+		return e
+	}
+	return w.Close()
+}
+
+// stageOut copies the outputs of job from its working directory dir to the storage backends
+func (rm *ResMan) stageOut(job Job, dir string) error {
+	for _, f := range job.Outputs {
+		if e := rm.stageFileOut(f, dir); e != nil {
+			return fmt.Errorf("%v: %v", f.Path, e)
+		}
+		rm.jobs.logf(job.ID, "staged out %v", f)
+	}
+	return nil
+}
+
+func (rm *ResMan) stageFileOut(f StageFile, dir string) error {
+	b, key, e := rm.backend(f)
+	if e != nil {
+		return e
+	}
+	r, e := openOutput(dir, f.Path)
+	if e != nil {
+		return e
+	}
+	defer r.Close()
+	return b.Put(key, r)
+}
+
+// openOutput opens the output rel in the working directory dir of a job. The job may have created symlinks
+// in its directory, e.g. to a file of the RM, so no part of rel may be a symlink and the output must be a regular file.
+func openOutput(dir string, rel string) (*os.File, error) {
+	p, shown := dir, ""
+	var info os.FileInfo
+	for _, part := range strings.Split(path.Clean(filepath.ToSlash(rel)), "/") {
+		p, shown = filepath.Join(p, part), path.Join(shown, part)
+		var e error
+		if info, e = os.Lstat(p); os.IsNotExist(e) {
+			return nil, fmt.Errorf("%v does not exist", shown)
+		} else if e != nil {
+			return nil, e
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil, fmt.Errorf("%v is a symlink", shown)
+		}
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%v is not a regular file", shown)
+	}
+	f, e := os.Open(p)
+	if e != nil {
+		return nil, e
+	}
+	// the file may have been replaced after it was checked
+	if opened, e := f.Stat(); e != nil || !os.SameFile(info, opened) {
+		f.Close()
+		return nil, fmt.Errorf("%v changed while it was opened", shown)
+	}
+	return f, nil
+}
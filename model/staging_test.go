@@ -0,0 +1,115 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckKey(t *testing.T) {
+	tests := []struct {
+		key string
+		ok  bool
+	}{
+		{"a", true},
+		{"a/b/c.txt", true},
+		{"a/../b", true},
+		{"./a", true},
+		{"a/", true},
+		{"..a", true},
+		{"", false},
+		{".", false},
+		{"./", false},
+		{"a/..", false},
+		{"..", false},
+		{"../a", false},
+		{"a/../../b", false},
+		{"../../../etc/shadow", false},
+		{"/etc/shadow", false},
+		{"/", false},
+	}
+	for _, test := range tests {
+		if e := checkKey(test.key); (e == nil) != test.ok {
+			t.Errorf("checkKey(%q) = %v, expected ok to be %v", test.key, e, test.ok)
+		}
+	}
+}
+
+func TestSharedDirKeys(t *testing.T) {
+	root, e := ioutil.TempDir("", "shared")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(root)
+	s := sharedDir{filepath.Join(root, "shared")}
+
+	if e := s.Put("a/b.txt", strings.NewReader("data")); e != nil {
+		t.Fatal(e)
+	}
+	if r, e := s.Get("a/b.txt"); e != nil {
+		t.Error(e)
+	} else {
+		r.Close()
+	}
+	for _, key := range []string{"../outside", "/tmp/outside", "a/../../outside"} {
+		if e := s.Put(key, strings.NewReader("data")); e == nil {
+			t.Errorf("Put(%q) was accepted", key)
+		}
+		if _, e := s.Get(key); e == nil {
+			t.Errorf("Get(%q) was accepted", key)
+		}
+	}
+	if _, e := os.Stat(filepath.Join(root, "outside")); !os.IsNotExist(e) {
+		t.Errorf("a file was written outside the shared directory, %v", e)
+	}
+}
+
+func TestOpenOutput(t *testing.T) {
+	dir, e := ioutil.TempDir("", "output")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	secret := filepath.Join(dir, "secret")
+	work := filepath.Join(dir, "work")
+	for _, d := range []string{work, filepath.Join(work, "sub"), filepath.Join(work, "dir")} {
+		if e := os.MkdirAll(d, 0755); e != nil {
+			t.Fatal(e)
+		}
+	}
+	for _, f := range []string{secret, filepath.Join(work, "out"), filepath.Join(work, "sub", "out")} {
+		if e := ioutil.WriteFile(f, []byte("data"), 0644); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if e := os.Symlink(secret, filepath.Join(work, "link")); e != nil {
+		t.Fatal(e)
+	}
+	if e := os.Symlink(dir, filepath.Join(work, "linkdir")); e != nil {
+		t.Fatal(e)
+	}
+
+	tests := []struct {
+		rel string
+		ok  bool
+	}{
+		{"out", true},
+		{"sub/out", true},
+		{"./sub/../out", true},
+		{"link", false},
+		{"linkdir/secret", false},
+		{"dir", false},
+		{"missing", false},
+	}
+	for _, test := range tests {
+		f, e := openOutput(work, test.rel)
+		if (e == nil) != test.ok {
+			t.Errorf("openOutput(%q) = %v, expected ok to be %v", test.rel, e, test.ok)
+		}
+		if f != nil {
+			f.Close()
+		}
+	}
+}
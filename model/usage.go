@@ -0,0 +1,102 @@
+package model
+
+import "time"
+
+// usageInterval is how often the RM samples the resource usage of the running tasks
+const usageInterval = 5 * time.Second
+
+// ExecStats is the resource usage of a task, the totals only grow while the task runs
+type ExecStats struct {
+	CPUTime    time.Duration // user and system time
+	RSS        int64         // bytes of memory in use at the last sample, 0 once the task is finished
+	MaxRSS     int64         // the most bytes of memory in use at a time
+	ReadBytes  int64         // bytes read from storage
+	WriteBytes int64         // bytes written to storage
+}
+
+// merge keeps the highest totals of s and o, the RSS of o is the current one
+func (s ExecStats) merge(o ExecStats) ExecStats {
+	max := func(a, b int64) int64 {
+		if a > b {
+			return a
+		}
+		return b
+	}
+	return ExecStats{
+		CPUTime:    time.Duration(max(int64(s.CPUTime), int64(o.CPUTime))),
+		RSS:        o.RSS,
+		MaxRSS:     max(max(s.MaxRSS, o.MaxRSS), o.RSS),
+		ReadBytes:  max(s.ReadBytes, o.ReadBytes),
+		WriteBytes: max(s.WriteBytes, o.WriteBytes),
+	}
+}
+
+// add returns the usage of two tasks together
+func (s ExecStats) add(o ExecStats) ExecStats {
+	return ExecStats{s.CPUTime + o.CPUTime, s.RSS + o.RSS, s.MaxRSS + o.MaxRSS, s.ReadBytes + o.ReadBytes, s.WriteBytes + o.WriteBytes}
+}
+
+// RMCapacity is the reply of a RM to a capacity request, Usage is the sum of the usage of its running tasks
+type RMCapacity struct {
+	Free    int // number of free workers
+	Workers int
+	Usage   ExecStats
+	Jobs    map[int64]ExecStats // the usage of the running tasks at their last sample
+}
+
+// running returns the last usage samples of the running jobs
+func (r *jobRecords) running() map[int64]ExecStats {
+	r.Lock()
+	defer r.Unlock()
+	res := make(map[int64]ExecStats)
+	for id, rec := range r.m {
+		if !rec.start.IsZero() && rec.finish.IsZero() {
+			res[id] = rec.usage
+		}
+	}
+	return res
+}
+
+// sampleUsage records the current usage of a task, the last sample of a finished task is its accounting record
+func (rm *ResMan) sampleUsage(id int64, ex Executor) ExecStats {
+	s := ex.Stats()
+	rm.jobs.update(id, func(rec *jobRecord) { rec.usage = s })
+	return s
+}
+
+// logUsage adds the usage of a finished task to its log if the executor reported it
+func (rm *ResMan) logUsage(id int64, s ExecStats) {
+	if s != (ExecStats{}) {
+		rm.jobs.logf(id, "used %v of CPU time, %v bytes of memory at most, read %v and wrote %v bytes",
+			s.CPUTime, s.MaxRSS, s.ReadBytes, s.WriteBytes)
+	}
+}
+
+// GetCapacity RPC returns the free workers of this RM and the resource usage of its running tasks
+func (rm *ResMan) GetCapacity(x *int, reply *RMCapacity) error {
+	// doesn't matter what x is
+	*reply = RMCapacity{Free: rm.computeCapacity(), Workers: rm.n, Jobs: rm.jobs.running()}
+	for _, s := range reply.Jobs {
+		reply.Usage = reply.Usage.add(s)
+	}
+	return nil
+}
+
+// setUsage sets the usage of the scheduled jobs to the last sample of their RMs, finished jobs already have
+// the usage that their RM reported when they finished
+func (gs *GridSdr) setUsage(jobs []Job) {
+	caps := make(map[string]RMCapacity)
+	for i, job := range jobs {
+		if job.State != JobScheduled {
+			continue
+		}
+		addr := gs.rmAddr(job)
+		c, ok := caps[addr]
+		if !ok {
+			// an offline RM reports no usage
+			c, _ = rpcGetCapacityFromRM(addr)
+			caps[addr] = c
+		}
+		jobs[i].Usage = c.Jobs[job.ID]
+	}
+}
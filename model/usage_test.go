@@ -0,0 +1,67 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExecStatsMerge(t *testing.T) {
+	tests := []struct {
+		name   string
+		prev   ExecStats
+		sample ExecStats
+		want   ExecStats
+	}{
+		{"first sample", ExecStats{}, ExecStats{time.Second, 100, 0, 10, 20},
+			ExecStats{time.Second, 100, 100, 10, 20}},
+		{"growing", ExecStats{time.Second, 100, 100, 10, 20}, ExecStats{2 * time.Second, 300, 0, 15, 40},
+			ExecStats{2 * time.Second, 300, 300, 15, 40}},
+		// a process that exited is missing from the next sample, the totals do not shrink
+		{"process exited", ExecStats{2 * time.Second, 300, 300, 15, 40}, ExecStats{time.Second, 50, 0, 5, 0},
+			ExecStats{2 * time.Second, 50, 300, 15, 40}},
+		// the final usage from the rusage of the command has no RSS
+		{"finished", ExecStats{2 * time.Second, 50, 300, 15, 40}, ExecStats{3 * time.Second, 0, 400, 15, 50},
+			ExecStats{3 * time.Second, 0, 400, 15, 50}},
+	}
+	for _, test := range tests {
+		if got := test.prev.merge(test.sample); got != test.want {
+			t.Errorf("%v: merged %+v, expected %+v", test.name, got, test.want)
+		}
+	}
+
+	a, b := ExecStats{time.Second, 1, 2, 3, 4}, ExecStats{time.Minute, 10, 20, 30, 40}
+	if got, want := a.add(b), (ExecStats{61 * time.Second, 11, 22, 33, 44}); got != want {
+		t.Errorf("added %+v, expected %+v", got, want)
+	}
+}
+
+func TestUsageAccounting(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	running := ExecStats{CPUTime: time.Second, RSS: 1 << 20, MaxRSS: 2 << 20}
+	done := ExecStats{CPUTime: time.Minute, MaxRSS: 3 << 20, ReadBytes: 4096, WriteBytes: 8192}
+	records := jobRecords{m: map[int64]*jobRecord{
+		1: {owner: "alice", start: start, usage: running},
+		2: {owner: "alice", start: start, finish: start.Add(time.Minute), state: JobCompleted, usage: done},
+		3: {owner: "bob"}, // waiting for a worker
+	}}
+
+	// only the running jobs are reported with the capacity of the RM
+	if got, want := records.running(), map[int64]ExecStats{1: running}; !reflect.DeepEqual(got, want) {
+		t.Errorf("the running jobs are %v, expected %v", got, want)
+	}
+
+	// the usage of a finished job goes from its RM to the accounting record
+	jobs := records.completed([]int64{2})
+	if jobs[0].Usage != done {
+		t.Errorf("the completed job has the usage %+v, expected %+v", jobs[0].Usage, done)
+	}
+	jobs[0].ResMan = "localhost:5001"
+	rec := newAccountingRecord(jobs[0])
+	want := AccountingRecord{JobID: 2, Owner: "alice", State: "Completed", ResMan: "localhost:5001", StartTime: start,
+		FinishTime: start.Add(time.Minute), WallTime: time.Minute, CPUTime: time.Minute, MaxRSS: 3 << 20,
+		ReadBytes: 4096, WriteBytes: 8192}
+	if !reflect.DeepEqual(rec, want) {
+		t.Errorf("the accounting record is %+v, expected %+v", rec, want)
+	}
+}
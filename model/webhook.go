@@ -0,0 +1,231 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// event types of the job state transitions that are sent to the callbacks
+const (
+	EventQueued      = "queued"
+	EventScheduled   = "scheduled"
+	EventRescheduled = "rescheduled"
+	EventPreempted   = "preempted"
+	EventCompleted   = "completed"
+	EventFailed      = "failed"
+	EventCancelled   = "cancelled"
+)
+
+const (
+	webhookAttempts   = 5                // deliveries are retried up to this many times
+	webhookBackoff    = time.Second      // doubled after every failed attempt
+	webhookTimeout    = 10 * time.Second // per HTTP request
+	webhookQueueSize  = 10000            // events are dropped if the queue is full
+	webhookRecordSize = 1000             // number of delivery records that are kept
+)
+
+// JobEvent is the JSON body that is POSTed to the callback URLs
+type JobEvent struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Leader string    `json:"leader"` // the GS that sent the event
+	Job    Job       `json:"job"`
+}
+
+// Delivery records the status of sending one event to one URL
+type Delivery struct {
+	URL       string
+	Event     string
+	JobID     int64
+	Owner     string
+	Attempts  int
+	Delivered bool
+	LastError string
+	Time      time.Time // of the last attempt
+}
+
+// WebhookConfig are the callbacks that the operator configures
+type WebhookConfig struct {
+	URLs  []string // receive the events of every job
+	Hosts []string // the hosts that the callbacks of the jobs may be sent to, any host if empty
+}
+
+// webhooks sends job events to the callback URLs of the jobs and the cluster wide URLs
+type webhooks struct {
+	sync.Mutex
+	urls    []string        // sent to for every job
+	hosts   map[string]bool // the hosts of the callbacks of the jobs, any host if empty
+	queue   chan JobEvent
+	records []Delivery    // the most recent deliveries, oldest first
+	backoff time.Duration // before the first retry
+	client  *http.Client
+}
+
+func newWebhooks(conf WebhookConfig) *webhooks {
+	hosts := make(map[string]bool)
+	for _, h := range conf.Hosts {
+		hosts[strings.ToLower(h)] = true
+	}
+	return &webhooks{
+		urls:    conf.URLs,
+		hosts:   hosts,
+		queue:   make(chan JobEvent, webhookQueueSize),
+		backoff: webhookBackoff,
+		client:  &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+// ValidateCallback checks that u is an http or https URL with a host
+func ValidateCallback(u string) error {
+	p, e := url.Parse(u)
+	if e != nil || (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+		return errorf(errInvalid, "Invalid callback URL %v, expected an http or https URL", strconv.Quote(u))
+	}
+	return nil
+}
+
+// validateCallbacks checks the callback URLs of the job
+func (j Job) validateCallbacks() error {
+	for _, u := range j.Callbacks {
+		if e := ValidateCallback(u); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// checkHosts refuses the callbacks of a job whose hosts the operator did not allow, the URLs must be valid
+func (w *webhooks) checkHosts(job Job) error {
+	for _, u := range job.Callbacks {
+		if !w.allowed(u) {
+			return errorf(errForbidden, "Callbacks may not be sent to %v", strconv.Quote(u))
+		}
+	}
+	return nil
+}
+
+func (w *webhooks) allowed(u string) bool {
+	if len(w.hosts) == 0 {
+		return true
+	}
+	p, e := url.Parse(u)
+	return e == nil && w.hosts[strings.ToLower(p.Hostname())]
+}
+
+// eventFor returns the event type of a job that reached a terminal state
+func eventFor(state JobState) string {
+	switch state {
+	case JobCompleted:
+		return EventCompleted
+	case JobFailed:
+		return EventFailed
+	case JobCancelled:
+		return EventCancelled
+	}
+	return ""
+}
+
+// emit queues an event for every job that has callback URLs, it never blocks
+func (w *webhooks) emit(typ string, leader string, jobs []Job) {
+	now := time.Now()
+	for _, job := range jobs {
+		if len(job.Callbacks) == 0 && len(w.urls) == 0 {
+			continue
+		}
+		select {
+		case w.queue <- JobEvent{typ, now, leader, job}:
+		default:
+			log.Printf("Webhook queue is full, dropping %v event of job %v\n", typ, job.ID)
+		}
+	}
+}
+
+// run delivers the queued events, events are delivered concurrently so a slow URL doesn't hold up the others.
+// The callbacks of the jobs are checked again because the jobs may have been submitted to another GS.
+func (w *webhooks) run() {
+	for ev := range w.queue {
+		urls := append([]string(nil), w.urls...)
+		for _, u := range ev.Job.Callbacks {
+			if ValidateCallback(u) != nil || !w.allowed(u) {
+				log.Printf("Not sending %v event of job %v to %v, the callback is not allowed\n", ev.Type, ev.Job.ID, u)
+				continue
+			}
+			urls = append(urls, u)
+		}
+		for _, url := range urls {
+			go w.deliver(url, ev)
+		}
+	}
+}
+
+// deliver POSTs the event to url, it retries with exponential backoff
+func (w *webhooks) deliver(url string, ev JobEvent) {
+	body, e := json.Marshal(ev)
+	if e != nil {
+		log.Printf("Failed to encode %v event of job %v, %v\n", ev.Type, ev.Job.ID, e)
+		return
+	}
+
+	d := Delivery{URL: url, Event: ev.Type, JobID: ev.Job.ID, Owner: ev.Job.Owner}
+	backoff := w.backoff
+	for d.Attempts < webhookAttempts {
+		if d.Attempts > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		d.Attempts++
+		d.Time = time.Now()
+		if e := w.post(url, body); e != nil {
+			d.LastError = e.Error()
+			continue
+		}
+		d.Delivered = true
+		break
+	}
+	if !d.Delivered {
+		log.Printf("Failed to deliver %v event of job %v to %v, %v\n", ev.Type, ev.Job.ID, url, d.LastError)
+	}
+	w.record(d)
+}
+
+func (w *webhooks) post(url string, body []byte) error {
+	resp, e := w.client.Post(url, "application/json", bytes.NewReader(body))
+	if e != nil {
+		return e
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	return nil
+}
+
+func (w *webhooks) record(d Delivery) {
+	w.Lock()
+	defer w.Unlock()
+	w.records = append(w.records, d)
+	if len(w.records) > webhookRecordSize {
+		w.records = w.records[1:]
+	}
+}
+
+// getRecords returns the delivery records that are accessible by the user
+func (w *webhooks) getRecords(user User) []Delivery {
+	w.Lock()
+	defer w.Unlock()
+	var res []Delivery
+	for _, d := range w.records {
+		if user.CanAccess(Job{ID: d.JobID, Owner: d.Owner}) {
+			res = append(res, d)
+		}
+	}
+	return res
+}
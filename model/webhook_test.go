@@ -0,0 +1,161 @@
+package model
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hookServer is a callback URL that answers with the given status codes in turn, 200 after the last one
+type hookServer struct {
+	sync.Mutex
+	*httptest.Server
+	statuses []int
+	events   []JobEvent
+	times    []time.Time // of every request
+}
+
+func newHookServer(statuses ...int) *hookServer {
+	s := &hookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Lock()
+		defer s.Unlock()
+		var ev JobEvent
+		json.NewDecoder(r.Body).Decode(&ev)
+		s.events = append(s.events, ev)
+		s.times = append(s.times, time.Now())
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	return s
+}
+
+func TestWebhookEvents(t *testing.T) {
+	srv := newHookServer()
+	defer srv.Close()
+	w := newWebhooks(WebhookConfig{})
+	go w.run()
+
+	job := Job{ID: 7, Owner: "alice", Callbacks: []string{srv.URL}}
+	types := []string{EventQueued, EventScheduled, EventRescheduled, EventPreempted, EventCompleted}
+	for _, typ := range types {
+		w.emit(typ, "localhost:4001", []Job{job})
+	}
+	// jobs without callbacks are not sent anywhere
+	w.emit(EventQueued, "localhost:4001", []Job{{ID: 8, Owner: "alice"}})
+
+	admin := User{Name: "root", Admin: true}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(w.getRecords(admin)) < len(types) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	srv.Lock()
+	defer srv.Unlock()
+	// the events of one job are delivered concurrently, so they may arrive in any order
+	var got []string
+	for _, ev := range srv.events {
+		if ev.Job.ID != job.ID || ev.Job.Owner != job.Owner || ev.Leader != "localhost:4001" || ev.Time.IsZero() {
+			t.Errorf("unexpected %v event %+v", ev.Type, ev)
+		}
+		got = append(got, ev.Type)
+	}
+	sort.Strings(got)
+	want := append([]string(nil), types...)
+	sort.Strings(want)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("received events %v, expected %v", got, want)
+	}
+	for _, d := range w.getRecords(admin) {
+		if !d.Delivered || d.Attempts != 1 || d.URL != srv.URL || d.JobID != job.ID {
+			t.Errorf("unexpected delivery %+v", d)
+		}
+	}
+	if n := len(w.getRecords(User{Name: "bob"})); n != 0 {
+		t.Errorf("bob can see %v deliveries of the jobs of alice", n)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int // the responses of the callback, 200 after the last one
+		attempts  int
+		delivered bool
+		lastError string
+	}{
+		{"delivered", nil, 1, true, ""},
+		{"retried after 5xx", []int{500, 503}, 3, true, "503"},
+		{"retried after 4xx", []int{404}, 2, true, "404"},
+		{"given up", []int{500, 500, 500, 500, 500, 500}, webhookAttempts, false, "500"},
+	}
+	backoff := 20 * time.Millisecond
+	for _, test := range tests {
+		srv := newHookServer(test.statuses...)
+		w := newWebhooks(WebhookConfig{})
+		w.backoff = backoff
+		w.deliver(srv.URL, JobEvent{Type: EventFailed, Job: Job{ID: 1, Owner: "alice"}})
+		srv.Close()
+
+		records := w.getRecords(User{Name: "alice"})
+		if len(records) != 1 {
+			t.Fatalf("%v: %v delivery records, expected 1", test.name, len(records))
+		}
+		d := records[0]
+		if d.Attempts != test.attempts || d.Delivered != test.delivered || !strings.Contains(d.LastError, test.lastError) {
+			t.Errorf("%v: delivery %+v, expected %v attempts, delivered %v and error %q",
+				test.name, d, test.attempts, test.delivered, test.lastError)
+		}
+		if len(srv.times) != test.attempts {
+			t.Errorf("%v: the callback received %v requests, expected %v", test.name, len(srv.times), test.attempts)
+		}
+		// the backoff doubles after every attempt
+		for i := 1; i < len(srv.times); i++ {
+			if gap, min := srv.times[i].Sub(srv.times[i-1]), backoff<<uint(i-1); gap < min {
+				t.Errorf("%v: attempt %v was %v after the previous one, expected at least %v", test.name, i+1, gap, min)
+			}
+		}
+	}
+}
+
+func TestWebhookCallbacks(t *testing.T) {
+	tests := []struct {
+		url     string
+		hosts   []string
+		valid   bool
+		allowed bool
+	}{
+		{"http://hooks.example.com/x", nil, true, true},
+		{"https://hooks.example.com:8443/x", []string{"hooks.example.com"}, true, true},
+		{"https://HOOKS.example.com/x", []string{"hooks.example.com"}, true, true},
+		{"http://169.254.169.254/latest", []string{"hooks.example.com"}, true, false},
+		{"http://localhost:4001/", []string{"hooks.example.com"}, true, false},
+		{"ftp://hooks.example.com/x", nil, false, false},
+		{"file:///etc/passwd", nil, false, false},
+		{"hooks.example.com/x", nil, false, false},
+		{"http://", nil, false, false},
+		{"://x", nil, false, false},
+	}
+	for _, test := range tests {
+		job := Job{Callbacks: []string{test.url}}
+		e := job.validate()
+		if (e == nil) != test.valid || (e != nil && kindOf(e) != errInvalid) {
+			t.Errorf("%v: validate returned %v, expected valid to be %v", test.url, e, test.valid)
+		}
+		if !test.valid {
+			continue
+		}
+		e = newWebhooks(WebhookConfig{Hosts: test.hosts}).checkHosts(job)
+		if (e == nil) != test.allowed || (e != nil && kindOf(e) != errForbidden) {
+			t.Errorf("%v: checkHosts with %v returned %v, expected allowed to be %v", test.url, test.hosts, e, test.allowed)
+		}
+	}
+}